@@ -13,7 +13,7 @@ func NewParser() Parser {
 func (p bertyParser) Parse(previous *ResultReturn) *ResultReturn {
 	str, ok := previous.Object.(string)
 	if ok {
-		r, err := bertymessenger.UnmarshalLink(str)
+		r, err := bertymessenger.UnmarshalLink(str, bertymessenger.WithLegacyFallback())
 		if err != nil {
 			return nil
 		}
@@ -119,7 +119,8 @@ func (svc *service) ParseDeepLink(_ context.Context, req *ParseDeepLink_Request)
 	}
 	ret := ParseDeepLink_Reply{}
 
-	link, err := UnmarshalLink(req.Link)
+	// the v0 contact links of the older apps are still found in the chat histories
+	link, err := UnmarshalLink(req.Link, WithLegacyFallback())
 	if err != nil {
 		return nil, errcode.ErrMessengerInvalidDeepLink.Wrap(err)
 	}
@@ -810,7 +811,8 @@ func (svc *service) AccountUpdate(ctx context.Context, req *AccountUpdate_Reques
 }
 
 func (svc *service) ContactRequest(ctx context.Context, req *ContactRequest_Request) (*ContactRequest_Reply, error) {
-	link, err := UnmarshalLink(req.GetLink())
+	// i.e., the BetaBot link of the app is still a v0 link
+	link, err := UnmarshalLink(req.GetLink(), WithLegacyFallback())
 	if err != nil {
 		return nil, errcode.ErrMessengerInvalidDeepLink.Wrap(err)
 	}
@@ -92,6 +92,7 @@ func TestServiceParseDeepLink(t *testing.T) {
 		{"internal-2", &ParseDeepLink_Request{Link: "berty://pb/" + validContactInternalBlob}, nil, true, true},
 		{"weburl", &ParseDeepLink_Request{Link: "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice"}, nil, true, true},
 		{"weburl-noname", &ParseDeepLink_Request{Link: "https://berty.tech/id#contact/" + validContactBlob}, nil, true, false},
+		{"legacy", &ParseDeepLink_Request{Link: "https://berty.tech/id#key=CiBYAkJkmvcCZOl2hWuSK34arbzSpcpQGLowIvi7ZsEdyRIgMmKs-zHKksC74gjOfSj5puOAQQGWNhsC8o9gEtQ8zrQ&name=BetaBot"}, nil, true, true},
 		{"test-real-case", &ParseDeepLink_Request{Link: "https://berty.tech/id#contact/oZBLEm2jFndwNvX9yQTVqcMkVuuJQYMoU1XQsmxhS9Q1n1L9npGFVhrFitqR9p8Wgd8Kf3sTLdLoQxKDmr3aK2pP9humsHz/name=moul+%28cli%29"}, nil, true, true},
 	}
 
@@ -1,13 +1,26 @@
 package bertymessenger
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"html"
 	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/eknkc/basex"
 	"github.com/gogo/protobuf/proto"
 	"github.com/mr-tron/base58"
+	"golang.org/x/text/unicode/norm"
 
 	"berty.tech/berty/v2/go/pkg/bertytypes"
 	"berty.tech/berty/v2/go/pkg/errcode"
@@ -24,238 +37,1742 @@ import (
 //
 // Marshal will return an error if the provided link does not contain all the mandatory fields;
 // it may also filter-out some sensitive data.
-func (link *BertyLink) Marshal() (internal string, web string, err error) {
+//
+// Marshal returns ErrLinkTooLarge if the web URL is longer than the configured URL length limit
+// (see WithURLLengthLimit), in this case, the internal URL should be shared instead.
+//
+// The web URL is always a valid RFC 3986 URI, all its human-readable parts are percent-encoded.
+// Both URLs use the canonical casing described in LinkInternalPrefix.
+func (link *BertyLink) Marshal(opts ...MarshalOption) (internal string, web string, err error) {
+	options, err := link.marshalOptions(opts)
+	if err != nil {
+		return "", "", err
+	}
+	if web, err = link.marshalWeb(options); err != nil {
+		return "", "", err
+	}
+	if internal, err = link.marshalInternal(options); err != nil {
+		return "", "", err
+	}
+	return internal, web, nil
+}
+
+// MarshalWebOnly returns the web URL of Marshal, without computing the internal URL,
+// i.e., to generate a lot of links that are never shown as QR codes.
+func (link *BertyLink) MarshalWebOnly(opts ...MarshalOption) (string, error) {
+	options, err := link.marshalOptions(opts)
+	if err != nil {
+		return "", err
+	}
+	return link.marshalWeb(options)
+}
+
+// MarshalInternalOnly returns the internal URL of Marshal, without computing the web URL,
+// so it doesn't fail if the web URL would be too long.
+func (link *BertyLink) MarshalInternalOnly(opts ...MarshalOption) (string, error) {
+	options, err := link.marshalOptions(opts)
+	if err != nil {
+		return "", err
+	}
+	return link.marshalInternal(options)
+}
+
+// ToInternal returns the internal link only, i.e., to store the compact form of a received web link.
+//
+// Unlike Marshal, it doesn't fail if the web link would be too long, since it is not generated.
+// The internal link carries all the fields of the link, but a link parsed from a web link only has the fields of
+// the web form, so the fields that the web form never had (i.e., the regeneration log of a group) can't be recovered.
+func (link *BertyLink) ToInternal() (string, error) {
+	return link.MarshalInternalOnly()
+}
+
+// marshalOptions returns the options of opts, after checking that link can be marshaled with them.
+//
+// The checks are the same for both forms, so a link that can be marshaled in one form can be marshaled in the other.
+func (link *BertyLink) marshalOptions(opts []MarshalOption) (MarshalOptions, error) {
 	if link == nil || link.Kind == BertyLink_UnknownKind {
-		return "", "", errcode.ErrMissingInput
+		return MarshalOptions{}, errcode.ErrMissingInput
+	}
+
+	options, err := newMarshalOptions(opts)
+	if err != nil {
+		return options, err
+	}
+
+	if err := link.isValid(options.AllowNonMultiMember); err != nil {
+		return options, err
+	}
+
+	info, ok := kindInfoOf(link.Kind)
+	if !ok || !info.Shareable || link.Kind.WebSlug() == "" {
+		return options, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported link kind: %q", link.Kind))
+	}
+	if err := options.checkKind(link.Kind); err != nil {
+		return options, err
+	}
+
+	metadataSize := 0
+	for key, value := range link.Metadata {
+		if key == "" || webLinkReservedKeys[key] {
+			return options, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid metadata key: %q", key))
+		}
+		metadataSize += len(key) + len(value)
+	}
+	if metadataSize > MaxLinkMetadataSize {
+		return options, errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("metadata is %d bytes long, the limit is %d bytes", metadataSize, MaxLinkMetadataSize))
+	}
+	if _, ok := expiryDisplayHintTokens[link.ExpiryDisplayHint]; !ok {
+		return options, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported expiry display hint: %q", link.ExpiryDisplayHint))
+	}
+	return options, nil
+}
+
+// linkBufferPool is a pool of proto buffers, reused by the calls of Marshal since the links are often generated
+// in batches, i.e., for a directory of groups.
+var linkBufferPool = sync.Pool{
+	New: func() interface{} { return proto.NewBuffer(nil) },
+}
+
+// marshalDeterministic calls fn with the deterministic proto encoding of msg, the encoding is only valid during
+// the call of fn, since its buffer is reused afterwards.
+func marshalDeterministic(msg proto.Message, fn func(bin []byte)) error {
+	buf := linkBufferPool.Get().(*proto.Buffer)
+	defer linkBufferPool.Put(buf)
+	buf.Reset()
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(msg); err != nil {
+		return errcode.ErrInvalidInput.Wrap(err)
+	}
+	fn(buf.Bytes())
+	return nil
+}
+
+// marshalWeb computes the web shareable link of a link checked by marshalOptions.
+//
+// in this mode, we have:
+// - a human-readable link kind
+// - a base58-encoded binary (proto) representation of the link (without the kind and metadata)
+// - human-readable metadata, encoded as query string (including display name)
+func (link *BertyLink) marshalWeb(options MarshalOptions) (string, error) {
+	machine := link.identity()
+	human := url.Values{}
+
+	// the proof-of-work stamp is part of the blob, since it can't be checked without it
+	machine.PoWNonce = link.PoWNonce
+	machine.Signature = link.Signature
+
+	switch link.Kind {
+	case BertyLink_ContactInviteV1Kind:
+		if !options.StripDisplayName && link.BertyID.DisplayName != "" {
+			human.Add("name", NormalizeDisplayName(link.BertyID.DisplayName))
+		}
+		if link.BertyID.ReferralCode != "" {
+			human.Add("ref", link.BertyID.ReferralCode)
+		}
+
+		// for contact sharing, there are no fields to hide
+	case BertyLink_GroupV1Kind:
+		if !options.StripDisplayName && link.BertyGroup.DisplayName != "" {
+			human.Add("name", NormalizeDisplayName(link.BertyGroup.DisplayName))
+		}
+		if options.WithoutGroupSecret {
+			machine.BertyGroup.Group.Secret = nil
+			machine.BertyGroup.Group.SecretSig = nil
+		}
+	case BertyLink_GroupSetV1Kind:
+		names := make([]string, len(link.BertyGroups))
+		named := false
+		for i, group := range link.BertyGroups {
+			names[i] = NormalizeDisplayName(group.DisplayName)
+			named = named || group.DisplayName != ""
+		}
+		// the names are aligned with the groups, so the empty ones are kept, unless they are all empty
+		if !options.StripDisplayName && named {
+			human["name"] = names
+		}
+		// the number of groups is only meant for the previews of the link, i.e., "join 3 groups",
+		// it is ignored by UnmarshalLink which counts the groups of the blob
+		human.Add("count", strconv.Itoa(len(link.BertyGroups)))
+	case BertyLink_MessageV1Kind:
+		// the whole message reference is in the blob, there is no human-readable part
+	case BertyLink_AccountPubV1Kind:
+		// the account public key is the only field of the link, it is in the blob
+	default:
+		return "", errcode.ErrInvalidInput
+	}
+
+	// the validity period and the metadata are common to all the kinds
+	for key, value := range link.Metadata {
+		human.Add(key, value)
+	}
+	if link.NotBeforeUnix != 0 {
+		human.Add("nbf", strconv.FormatInt(link.NotBeforeUnix, 10))
+	}
+	if link.ExpiresAtUnix != 0 {
+		human.Add("exp", strconv.FormatInt(link.ExpiresAtUnix, 10))
+	}
+	if link.ExpiryDisplayHint != BertyLink_ExpiryRelative {
+		human.Add("exp_hint", expiryDisplayHintTokens[link.ExpiryDisplayHint])
+	}
+	if options.AnalyticsTag != "" {
+		human.Add(analyticsTagKey, options.AnalyticsTag)
+	}
+
+	// here we use base58 by default, which is compressed enough whilst being easy to read by a human.
+	// WithWebEncoding selects base64.RawURLEncoding, which is a little bit more compressed and also only containing unescaped URL chars.
+	var machineEncoded string
+	if err := marshalDeterministic(machine, func(bin []byte) { machineEncoded = encodeWebBlob(bin, options.WebEncoding) }); err != nil {
+		return "", err
+	}
+	path := link.Kind.WebSlug() + "/" + machineEncoded
+	if len(human) > 0 {
+		path += "/" + human.Encode()
+	}
+	// we use a '#' to improve privacy by preventing the webservers to get aware of the right part of this URL
+	web := options.WebPrefix + path
+	if len(web) > options.URLLengthLimit {
+		return "", errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("web link is %d chars long, the limit is %d chars, use the internal link instead", len(web), options.URLLengthLimit))
+	}
+	if err := checkWebLinkURI(web, options.WebPrefix); err != nil {
+		return "", err
+	}
+	return web, nil
+}
+
+// marshalInternal computes the internal shareable link of a link checked by marshalOptions.
+//
+// in this mode, the url is as short as possible, in the format: berty://{base45(proto.marshal(link))}.
+// strippedCopy returns a deep copy of the link, so it can be edited without altering the input link, with its names
+// normalized, and without the fields removed by options (see WithoutDisplayName and WithoutGroupSecret).
+func (link *BertyLink) strippedCopy(options MarshalOptions) *BertyLink {
+	stripped := proto.Clone(link).(*BertyLink)
+	stripped.normalizeDisplayNames()
+
+	if options.StripDisplayName {
+		if stripped.BertyID != nil {
+			stripped.BertyID.DisplayName = ""
+		}
+		if stripped.BertyGroup != nil {
+			stripped.BertyGroup.DisplayName = ""
+		}
+		for _, group := range stripped.BertyGroups {
+			group.DisplayName = ""
+		}
+	}
+	if options.WithoutGroupSecret && link.Kind == BertyLink_GroupV1Kind {
+		stripped.BertyGroup.Group.Secret = nil
+		stripped.BertyGroup.Group.SecretSig = nil
+	}
+	return stripped
+}
+
+func (link *BertyLink) marshalInternal(options MarshalOptions) (string, error) {
+	qrOptimized := link.strippedCopy(options)
+
+	// the metadata map must always be marshaled in the same order to generate stable links
+	var payload string
+	var sum uint32
+	var compressErr error
+	marker := internalLinkMarker
+	err := marshalDeterministic(qrOptimized, func(qrBin []byte) {
+		// the payload is only deflated if it is actually smaller, the random keys don't compress
+		if options.CompressPayload && len(qrBin) > CompressedPayloadThreshold {
+			var compressed []byte
+			if compressed, compressErr = deflateLinkPayload(qrBin); compressErr == nil && len(compressed) < len(qrBin) {
+				qrBin = compressed
+				marker = compressedLinkInternalMarker
+			}
+		}
+		// using uppercase to stay in the QR AlphaNum's 45chars alphabet
+		payload = qrBaseEncoder.Encode(qrBin)
+		sum = crc32.ChecksumIEEE(qrBin)
+	})
+	if err != nil {
+		return "", err
+	}
+	if compressErr != nil {
+		return "", compressErr
+	}
+	if options.ScanChecksum {
+		payload += encodeScanChecksum(sum)
+		marker = checksumLinkInternalMarker
+	}
+	if options.GroupedPayloadLen > 0 {
+		payload = groupPayload(payload, options.GroupedPayloadLen)
+	}
+	return CurrentLinkInternalPrefix() + marker + "/" + payload, nil
+}
+
+// UnmarshalLink takes an URL generated by BertyLink.Marshal (or manually crafted), and returns a BertyLink object.
+//
+// UnmarshalLink returns ErrLinkNotYetValid or ErrLinkExpired if the link is used outside of its validity period,
+// unless WithAllowExpired is set.
+//
+// Web links of a kind planned for a newer version of Berty return ErrLinkNeedsUpdate,
+// while the unknown kinds return ErrLinkUnknownKind.
+// Internal links generated with WithScanChecksum return ErrLinkInvalidScan if they were altered.
+//
+// The display names of the human-readable part of the web links are sanitized (see SanitizeDisplayName).
+//
+// The links longer than DefaultMaxLinkSize bytes, or decoding to a larger payload, return ErrLinkTooLarge,
+// use UnmarshalLinkWithLimits to configure the limit.
+//
+// UnmarshalLink detects the form of the link, use UnmarshalInternalLink or UnmarshalWebLink to only accept one of them.
+func UnmarshalLink(uri string, opts ...UnmarshalOption) (*BertyLink, error) {
+	return unmarshalLinkWith(uri, opts, unmarshalLink)
+}
+
+// DefaultMaxLinkSize is the default maximum size in bytes of the links parsed by UnmarshalLink, and of their
+// decoded payload, far above the size of the largest links generated by Marshal.
+const DefaultMaxLinkSize = 64 * 1024
+
+// UnmarshalLinkWithLimits is UnmarshalLink, with the maximum size in bytes of uri and of its decoded payload
+// set to maxBytes, i.e., for the link previews of untrusted inputs.
+//
+// The oversized links are rejected with ErrLinkTooLarge before being decoded, so a crafted input can't make
+// the decoding allocate an unbounded amount of memory.
+func UnmarshalLinkWithLimits(uri string, maxBytes int, opts ...UnmarshalOption) (*BertyLink, error) {
+	if maxBytes < 1 {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("the size limit should be positive, got %d", maxBytes))
+	}
+	limited := append([]UnmarshalOption{}, opts...)
+	limited = append(limited, func(options *UnmarshalOptions) error {
+		options.MaxLinkSize = maxBytes
+		return nil
+	})
+	return unmarshalLinkWith(uri, limited, unmarshalLink)
+}
+
+// UnmarshalInternalLink is UnmarshalLink for the internal links only, i.e., for the QR codes scanned by the camera,
+// it returns ErrInvalidInput for any other link, the web links included.
+func UnmarshalInternalLink(uri string, opts ...UnmarshalOption) (*BertyLink, error) {
+	return unmarshalLinkWith(uri, opts, unmarshalInternalLink)
+}
+
+// UnmarshalWebLink is UnmarshalLink for the web links only, i.e., for the pasted URLs,
+// it returns ErrInvalidInput for any other link, the internal links included.
+func UnmarshalWebLink(uri string, opts ...UnmarshalOption) (*BertyLink, error) {
+	return unmarshalLinkWith(uri, opts, unmarshalWebLink)
+}
+
+// unmarshalLinkWith decodes uri with decode, and runs the checks common to all the forms of links.
+func unmarshalLinkWith(uri string, opts []UnmarshalOption, decode func(uri string, options UnmarshalOptions) (*BertyLink, error)) (*BertyLink, error) {
+	options, err := newUnmarshalOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLinkSize("link", len(uri), options); err != nil {
+		return nil, err
+	}
+
+	if options.HTMLUnwrap {
+		uri = unwrapLinkMarkup(uri)
+	}
+	if options.NestedLinkUnwrap {
+		var err error
+		if uri, err = unwrapNestedLink(uri, options.internalSchemes()); err != nil {
+			return nil, err
+		}
+	}
+	if uri == "" {
+		return nil, errcode.ErrMissingInput
+	}
+	uri = unescapeWebLinkFragment(uri)
+
+	link, err := decode(uri, options)
+	if err != nil {
+		return nil, err
+	}
+	// the links generated by the older versions may have names in any normalization form
+	link.normalizeDisplayNames()
+	if err := checkReferralCode(link.GetBertyID().GetReferralCode()); err != nil {
+		return nil, err
+	}
+	if err := checkAdditionalRendezvousSeeds(link.GetBertyID()); err != nil {
+		return nil, err
+	}
+	if err := link.checkKeyLengths(); err != nil {
+		return nil, err
+	}
+	if err := link.checkSignature(); err != nil {
+		return nil, err
+	}
+	if options.MinPoW > 0 {
+		if err := link.checkPoW(options.MinPoW); err != nil {
+			return nil, err
+		}
+	}
+
+	if !options.AllowExpired {
+		if err := link.checkActive(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return link, nil
+}
+
+// checkLinkSize returns ErrLinkTooLarge if size is above the size limit of options.
+func checkLinkSize(what string, size int, options UnmarshalOptions) error {
+	if limit := options.maxLinkSize(); size > limit {
+		return errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("%s is %d bytes long, the limit is %d bytes", what, size, limit))
+	}
+	return nil
+}
+
+// unmarshalLink decodes uri with the decoder of its form.
+func unmarshalLink(uri string, options UnmarshalOptions) (*BertyLink, error) {
+	// the "berty://id/#key=" and "/id#key=" prefixes of the v0 links can't start a current link
+	if _, ok := legacyLinkFragment(uri); ok && options.LegacyFallback {
+		return unmarshalLegacyLink(uri, options)
+	}
+	if _, ok := internalLinkPayloadOf(uri, options.internalSchemes()); ok {
+		return unmarshalInternalLink(uri, options)
+	}
+	if _, ok := webLinkFragmentStart(uri); ok || isWebLinkWithoutFragment(uri) {
+		return unmarshalWebLink(uri, options)
+	}
+	if options.LegacyFallback {
+		return nil, linkParseError(LinkParsePrefix, -1, -1, fmt.Errorf("unsupported link format, neither a current nor a legacy link"))
+	}
+	return nil, linkParseError(LinkParsePrefix, -1, -1, fmt.Errorf("unsupported link format"))
+}
+
+func unmarshalInternalLink(uri string, options UnmarshalOptions) (*BertyLink, error) {
+	right, ok := internalLinkPayloadOf(uri, options.internalSchemes())
+	if !ok {
+		return nil, linkParseError(LinkParsePrefix, -1, -1, fmt.Errorf("not an internal link"))
+	}
+	qrBin, err := internalLinkBlob(right)
+	if err != nil {
+		return nil, err
+	}
+	// the compressed payloads may inflate to more than the size of the link
+	if err := checkLinkSize("decoded payload", len(qrBin), options); err != nil {
+		return nil, err
+	}
+	var link BertyLink
+	err = proto.Unmarshal(qrBin, &link)
+	if err != nil {
+		return nil, linkParseError(LinkParseProto, 1, -1, err)
+	}
+
+	// a damaged QR code can decode to a link of a known kind, but without its mandatory fields,
+	// the groups of any type are accepted, only Marshal restricts them (see WithAllowNonMultiMember);
+	// the specific codes (i.e., ErrLinkGroupSecretMismatch) are kept
+	if err := link.isValid(true); err != nil {
+		if errcode.Is(err, errcode.ErrMissingInput) {
+			return nil, errcode.ErrInvalidInput.Wrap(err)
+		}
+		return nil, err
+	}
+	return &link, nil
+}
+
+func unmarshalWebLink(uri string, options UnmarshalOptions) (*BertyLink, error) {
+	if _, ok := webLinkFragmentStart(uri); !ok {
+		if isWebLinkWithoutFragment(uri) {
+			return nil, linkParseError(LinkParsePrefix, -1, -1, fmt.Errorf("web link is missing the '#' fragment"))
+		}
+		return nil, linkParseError(LinkParsePrefix, -1, -1, fmt.Errorf("not a web link"))
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, webLinkURLError(uri, err)
+	}
+	if parsed.Fragment == "" {
+		return nil, linkParseError(LinkParsePrefix, -1, -1, fmt.Errorf("the '#' fragment of the web link is empty"))
+	}
+
+	// the escaped form of the fragment is required to keep the percent-encoded chars of the human-readable part
+	rawFragment := parsed.EscapedFragment()
+
+	link := BertyLink{}
+	slug, blob, encodedValues, err := splitWebFragment(rawFragment)
+	if err != nil {
+		return nil, linkParseError(LinkParsePrefix, -1, -1, err)
+	}
+	if err := protectedLinkError(slug); err != nil {
+		return nil, err
+	}
+	kind, err := ParseWebSlug(slug)
+	if err != nil {
+		return nil, linkParseError(LinkParseKind, 0, -1, err)
+	}
+
+	// decode blob
+	machineBin, err := decodeWebBlob(blob)
+	if err != nil {
+		return nil, linkParseError(LinkParseDecode, 1, webBlobErrorOffset(blob, err), err)
+	}
+	err = proto.Unmarshal(machineBin, &link)
+	// a valid blob always contains the contact, the group or the message identity, so an empty link is a failure too
+	if options.DoubleDecodeFallback && (err != nil || (link.BertyID == nil && link.BertyGroup == nil && len(link.BertyGroups) == 0 && link.BertyMessageRef == nil)) {
+		if doubleEncoded, decodeErr := base58.Decode(string(machineBin)); decodeErr == nil {
+			link = BertyLink{}
+			err = proto.Unmarshal(doubleEncoded, &link)
+		}
+	}
+	if err != nil {
+		return nil, linkParseError(LinkParseProto, 1, -1, err)
+	}
+
+	// decode url.Values
+	human, err := url.ParseQuery(encodedValues)
+	if err != nil {
+		return nil, linkParseError(LinkParseQuery, 2, escapeErrorOffset(encodedValues, err), err)
+	}
+
+	// decode the validity period
+	if link.NotBeforeUnix, err = parseLinkTimestamp(human, "nbf"); err != nil {
+		return nil, linkParseError(LinkParseQuery, 2, -1, err)
+	}
+	if link.ExpiresAtUnix, err = parseLinkTimestamp(human, "exp"); err != nil {
+		return nil, linkParseError(LinkParseQuery, 2, -1, err)
+	}
+	link.ExpiryDisplayHint = parseExpiryDisplayHint(human.Get("exp_hint"))
+
+	// the other values are metadata
+	for key := range human {
+		if webLinkReservedKeys[key] {
+			continue
+		}
+		if link.Metadata == nil {
+			link.Metadata = map[string]string{}
+		}
+		link.Metadata[key] = human.Get(key)
+	}
+
+	// per-kind merging strategies and checks
+	link.Kind = kind
+	link.clearForeignSubStructs()
+	switch link.Kind {
+	case BertyLink_ContactInviteV1Kind:
+		if link.BertyID == nil {
+			link.BertyID = &BertyID{}
+		}
+		if name := human.Get("name"); name != "" && link.BertyID.DisplayName == "" {
+			link.BertyID.DisplayName = webDisplayName(name)
+		}
+		if ref := human.Get("ref"); ref != "" && link.BertyID.ReferralCode == "" {
+			link.BertyID.ReferralCode = ref
+		}
+	case BertyLink_GroupV1Kind:
+		if link.BertyGroup == nil {
+			link.BertyGroup = &BertyGroup{}
+		}
+		if name := human.Get("name"); name != "" && link.BertyGroup.DisplayName == "" {
+			link.BertyGroup.DisplayName = webDisplayName(name)
+		}
+	case BertyLink_GroupSetV1Kind:
+		if names := human["name"]; len(names) == len(link.BertyGroups) {
+			for i, group := range link.BertyGroups {
+				if group != nil && group.DisplayName == "" {
+					group.DisplayName = webDisplayName(names[i])
+				}
+			}
+		}
+	case BertyLink_MessageV1Kind:
+		if link.BertyMessageRef == nil {
+			link.BertyMessageRef = &BertyMessageRef{}
+		}
+	case BertyLink_AccountPubV1Kind:
+		if link.BertyID == nil {
+			link.BertyID = &BertyID{}
+		}
+	default:
+		return nil, linkParseError(LinkParseKind, 0, -1, fmt.Errorf("unsupported link kind: %q", link.Kind))
+	}
+
+	// the groups of any type are accepted, only Marshal restricts them (see WithAllowNonMultiMember)
+	if err := link.isValid(true); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// clearForeignSubStructs removes the sub-structs that don't match the kind of the link.
+//
+// The kind of a web link comes from its slug, not from its blob, so a crafted blob can contain the sub-struct
+// of another kind, i.e., a group under the "contact" slug.
+func (link *BertyLink) clearForeignSubStructs() {
+	if link.Kind != BertyLink_ContactInviteV1Kind && link.Kind != BertyLink_AccountPubV1Kind {
+		link.BertyID = nil
+	}
+	if link.Kind != BertyLink_GroupV1Kind {
+		link.BertyGroup = nil
+	}
+	if link.Kind != BertyLink_GroupSetV1Kind {
+		link.BertyGroups = nil
+	}
+	if link.Kind != BertyLink_MessageV1Kind {
+		link.BertyMessageRef = nil
+	}
+}
+
+// LinkKindOf returns the kind of a link without fully decoding it.
+//
+// It is cheaper than UnmarshalLink for routing decisions, but it does not check that the link is valid.
+func LinkKindOf(uri string) (BertyLink_Kind, error) {
+	if uri == "" {
+		return BertyLink_UnknownKind, errcode.ErrMissingInput
+	}
+
+	// internal format, only decode the kind field of the proto
+	if right, ok := internalLinkPayload(uri); ok {
+		qrBin, err := internalLinkBlob(right)
+		if err != nil {
+			return BertyLink_UnknownKind, err
+		}
+		kind, err := linkKindField(qrBin)
+		if err != nil {
+			return BertyLink_UnknownKind, err
+		}
+		if _, known := kindInfoOf(kind); !known {
+			return BertyLink_UnknownKind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported link kind: %d", kind))
+		}
+		return kind, nil
+	}
+
+	// web format, the kind is the first part of the fragment
+	if start, ok := webLinkFragmentStart(uri); ok {
+		fragment := uri[start:]
+		token := strings.SplitN(fragment, "/", 2)[0]
+		if err := protectedLinkError(token); err != nil {
+			return BertyLink_UnknownKind, err
+		}
+		return ParseWebSlug(token)
+	}
+
+	return BertyLink_UnknownKind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported link format"))
+}
+
+// ValidateURI returns the kind of a link, after checking that it is well-formed, without building the BertyLink,
+// i.e., to pick the prompt of a link preview before decoding the link (and its keys) with UnmarshalLink.
+//
+// The payload of the link is decoded and its proto wire format is scanned, so the malformed links return the same
+// errors as UnmarshalLink; but the validity period is not checked, and the mandatory fields of the kind are not
+// checked either.
+func ValidateURI(uri string) (BertyLink_Kind, error) {
+	kind, err := LinkKindOf(uri)
+	if err != nil {
+		return BertyLink_UnknownKind, err
+	}
+	// the kind of the internal links is already read with a scan of the whole payload
+	if _, internal := internalLinkPayload(uri); internal {
+		return kind, nil
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return BertyLink_UnknownKind, errcode.ErrInvalidInput.Wrap(err)
+	}
+	_, blob, encodedValues, err := splitWebFragment(parsed.EscapedFragment())
+	if err != nil {
+		return BertyLink_UnknownKind, err
+	}
+	machineBin, err := decodeWebBlob(blob)
+	if err != nil {
+		return BertyLink_UnknownKind, errcode.ErrInvalidInput.Wrap(err)
+	}
+	if _, err := linkKindField(machineBin); err != nil {
+		return BertyLink_UnknownKind, err
+	}
+	human, err := url.ParseQuery(encodedValues)
+	if err != nil {
+		return BertyLink_UnknownKind, errcode.ErrInvalidInput.Wrap(err)
+	}
+	for _, key := range []string{"nbf", "exp"} {
+		if _, err := parseLinkTimestamp(human, key); err != nil {
+			return BertyLink_UnknownKind, err
+		}
+	}
+	return kind, nil
+}
+
+// splitWebFragment splits the fragment of a web link, i.e., "{slug}/{blob}/{query}", on its first two '/' only,
+// so the query, whose values may contain raw '/' in the hand-crafted links, is returned verbatim.
+//
+// The query is optional, the slug and the blob are not.
+func splitWebFragment(fragment string) (slug string, blob string, query string, err error) {
+	parts := strings.SplitN(fragment, "/", 3)
+	if len(parts) < 2 {
+		return "", "", "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("web link is missing the payload after its kind: %q", parts[0]))
+	}
+	if len(parts) == 3 {
+		query = parts[2]
+	}
+	return parts[0], parts[1], query, nil
+}
+
+// internalLinkBlob decodes the binary payload of an internal link from its right part, i.e., "PB1/{payload}",
+// with the decoder of its marker (see internalLinkDecoders).
+func internalLinkBlob(right string) ([]byte, error) {
+	parts := strings.Split(right, "/")
+	if len(parts) < 2 {
+		return nil, linkParseError(LinkParsePrefix, -1, -1, fmt.Errorf("URI should have at least 2 parts"))
+	}
+	// the delimiters of the grouped payloads, and the spaces they may have been turned into, are ignored
+	payload := strings.Join(parts[1:], "/")
+	blob := strings.NewReplacer(payloadGroupDelimiter, "", " ", "").Replace(payload)
+	if err := protectedLinkError(parts[0]); err != nil {
+		return nil, err
+	}
+	decode, ok := internalLinkDecoders[parts[0]]
+	if !ok {
+		return nil, linkParseError(LinkParsePrefix, 0, -1, fmt.Errorf("unsupported link type: %q", parts[0]))
+	}
+	qrBin, err := decode(blob)
+	if err != nil {
+		return nil, linkParseError(LinkParseDecode, 1, invalidCharOffset(payload, qrAlphabet+payloadGroupDelimiter+" "), err)
+	}
+	return qrBin, nil
+}
+
+const (
+	// internalLinkMarker is the marker of the internal links generated by Marshal, i.e., "BERTY://PB1/{payload}",
+	// made of "PB" and of the version of the payload format.
+	internalLinkMarker = "PB1"
+
+	// legacyInternalLinkMarker is the unversioned marker of the links generated by the older apps,
+	// their payload is in the first version of the format.
+	// It is the oldest internal format of the BertyLink proto, the v0 links predating it are decoded by
+	// UnmarshalLegacyLink.
+	legacyInternalLinkMarker = "PB"
+)
+
+// internalLinkDecoders are the decoders of the internal link payloads, by marker.
+//
+// A change of the payload format must come with a new marker (i.e., "PB2") and a new decoder, so the links of every
+// version keep being decoded the same way, and the older apps reject the links they can't decode instead of
+// misreading them.
+var internalLinkDecoders = map[string]func(payload string) ([]byte, error){
+	legacyInternalLinkMarker:     decodeLinkPayloadV1,
+	internalLinkMarker:           decodeLinkPayloadV1,
+	checksumLinkInternalMarker:   checkedLinkBlob,
+	compressedLinkInternalMarker: decodeCompressedLinkPayload,
+}
+
+// decodeLinkPayloadV1 decodes a payload in the first version of the format, a proto BertyLink encoded with
+// qrBaseEncoder.
+func decodeLinkPayloadV1(payload string) ([]byte, error) {
+	qrBin, err := qrBaseEncoder.Decode(payload)
+	if err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+	return qrBin, nil
+}
+
+// linkKindField reads the kind field of a binary BertyLink, skipping the other fields without decoding them.
+func linkKindField(bin []byte) (BertyLink_Kind, error) {
+	kind := BertyLink_UnknownKind
+	for len(bin) > 0 {
+		key, n := binary.Uvarint(bin)
+		if n <= 0 {
+			return kind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid field key"))
+		}
+		bin = bin[n:]
+
+		var size uint64
+		switch wireType := key & 0x7; wireType {
+		case proto.WireVarint:
+			value, n := binary.Uvarint(bin)
+			if n <= 0 {
+				return kind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid varint"))
+			}
+			if key>>3 == 1 {
+				kind = BertyLink_Kind(value)
+			}
+			size = uint64(n)
+		case proto.WireBytes:
+			length, n := binary.Uvarint(bin)
+			if n <= 0 {
+				return kind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid length"))
+			}
+			size = uint64(n) + length
+		case proto.WireFixed64:
+			size = 8
+		case proto.WireFixed32:
+			size = 4
+		default:
+			return kind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported wire type: %d", wireType))
+		}
+		if size > uint64(len(bin)) {
+			return kind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("truncated field"))
+		}
+		bin = bin[size:]
+	}
+	return kind, nil
+}
+
+func parseLinkTimestamp(human url.Values, key string) (int64, error) {
+	raw := human.Get(key)
+	if raw == "" {
+		return 0, nil
+	}
+	ts, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid %q timestamp: %w", key, err))
+	}
+	return ts, nil
+}
+
+// LinkWebPrefix and LinkInternalPrefix are the canonical prefixes of the links generated by Marshal.
+//
+// The internal links are always generated in uppercase (i.e., "BERTY://PB1/{payload}") to stay in the QR alphanumeric
+// alphabet, and the web links in lowercase, except for their case-sensitive blob and human-readable part.
+// When parsing, the scheme and host of both forms, and the "PB1" segment of the internal links, are case-insensitive.
+//
+// LinkWebPrefix is only the default prefix of the web links, the self-hosted deployments can use their own host
+// (see WithWebPrefix), and UnmarshalLink accepts the web links of any host, i.e., "https://{host}/id#...".
+// Likewise, LinkInternalPrefix is only the default prefix of the internal links, the white-label builds can use
+// their own scheme (see SetLinkInternalPrefix).
+const (
+	LinkWebPrefix      = "https://berty.tech/id#"
+	LinkInternalPrefix = "BERTY://"
+)
+
+var (
+	linkInternalPrefixMutex sync.RWMutex
+	linkInternalPrefix      = LinkInternalPrefix
+)
+
+// SetLinkInternalPrefix sets the prefix of the internal links generated by Marshal (and by the other generators,
+// i.e., MarshalPINProtected), for the white-label builds whose deep links must not collide with the ones of
+// the official app, i.e., "MYCHAT://".
+//
+// The prefix must be an URI scheme followed by "://", it is uppercased like the rest of the internal links.
+// UnmarshalLink only accepts the internal links of the configured scheme, unless WithInternalSchemes is set.
+// It is meant to be called once, when the app starts, before any link is generated.
+func SetLinkInternalPrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, "://") {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("internal prefix should end with \"://\": %q", prefix))
+	}
+	scheme, err := internalLinkScheme(strings.TrimSuffix(prefix, "://"))
+	if err != nil {
+		return err
+	}
+
+	linkInternalPrefixMutex.Lock()
+	linkInternalPrefix = scheme + "://"
+	linkInternalPrefixMutex.Unlock()
+	return nil
+}
+
+// CurrentLinkInternalPrefix returns the prefix of the generated internal links, LinkInternalPrefix unless
+// SetLinkInternalPrefix was called.
+func CurrentLinkInternalPrefix() string {
+	linkInternalPrefixMutex.RLock()
+	defer linkInternalPrefixMutex.RUnlock()
+	return linkInternalPrefix
+}
+
+// internalLinkScheme returns the uppercased scheme, or ErrInvalidInput if it is not a valid URI scheme
+// (a letter followed by letters, digits, '+', '-' or '.', all in the QR alphanumeric alphabet once uppercased).
+func internalLinkScheme(scheme string) (string, error) {
+	if scheme == "" {
+		return "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("missing internal link scheme"))
+	}
+	scheme = strings.ToUpper(scheme)
+	for i, c := range scheme {
+		if !(c >= 'A' && c <= 'Z') && (i == 0 || !(c >= '0' && c <= '9') && !strings.ContainsRune("+-.", c)) {
+			return "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid internal link scheme: %q", scheme))
+		}
+	}
+	return scheme, nil
+}
+
+// MaxLinkMetadataSize is the maximum total size in bytes of the keys and values of BertyLink.Metadata,
+// before their percent-encoding in the web links.
+const MaxLinkMetadataSize = 4096
+
+// webLinkReservedKeys are the web link query keys that can't be used as metadata keys.
+var webLinkReservedKeys = map[string]bool{
+	"name":     true,
+	"ref":      true,
+	"nbf":      true,
+	"exp":      true,
+	"exp_hint": true,
+	"count":    true,
+
+	analyticsTagKey: true,
+}
+
+// analyticsTagKey is the web link query key of the analytics tag (see WithAnalyticsTag).
+const analyticsTagKey = "a"
+
+// expiryDisplayHintTokens are the web representations of the expiry display hints.
+var expiryDisplayHintTokens = map[BertyLink_ExpiryDisplayHint]string{
+	BertyLink_ExpiryRelative: "relative",
+	BertyLink_ExpiryAbsolute: "absolute",
+}
+
+// parseExpiryDisplayHint returns the expiry display hint of a web link token.
+//
+// The hint is purely presentational, so the unknown tokens fall back to the default (relative) hint
+// instead of failing the whole link.
+func parseExpiryDisplayHint(token string) BertyLink_ExpiryDisplayHint {
+	for hint, candidate := range expiryDisplayHintTokens {
+		if candidate == token {
+			return hint
+		}
+	}
+	return BertyLink_ExpiryRelative
+}
+
+// MaxReferralCodeLength is the maximum length of BertyID.ReferralCode.
+const MaxReferralCodeLength = 32
+
+// checkReferralCode returns an error if the referral code is too long or contains chars other than
+// ASCII letters, digits, '-' and '_'; an empty referral code is valid.
+//
+// The referral code is only an attribution hint, it is not authenticated in any way.
+func checkReferralCode(code string) error {
+	if len(code) > MaxReferralCodeLength {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("referral code should have at most %d chars", MaxReferralCodeLength))
+	}
+	for _, r := range code {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid referral code char: %q", r))
+		}
+	}
+	return nil
+}
+
+// checkWebLinkURI returns an error if web is not a valid RFC 3986 URI starting with prefix, with a fragment only made of
+// unreserved chars, sub-delims, ':', '@', '/', '?' and valid percent-encoded octets.
+//
+// Every component of a generated web link is supposed to be URI-safe (the kind token, the base58 blob,
+// and the query-escaped metadata), so a failure here means that an unescaped char leaked into the link.
+func checkWebLinkURI(web string, prefix string) error {
+	parsed, err := url.Parse(web)
+	if err != nil {
+		return errcode.ErrInternal.Wrap(fmt.Errorf("invalid web link: %w", err))
+	}
+	start, ok := webLinkFragmentStart(web)
+	if !ok || start != len(prefix) || parsed.Scheme != "https" || parsed.Host == "" || parsed.RawQuery != "" {
+		return errcode.ErrInternal.Wrap(fmt.Errorf("invalid web link URI: %q", web))
+	}
+
+	fragment := web[len(prefix):]
+	for i := 0; i < len(fragment); i++ {
+		c := fragment[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("-._~!$&'()*+,;=:@/?", c) != -1:
+		case c == '%' && i+2 < len(fragment) && isHexDigit(fragment[i+1]) && isHexDigit(fragment[i+2]):
+			i += 2
+		default:
+			return errcode.ErrInternal.Wrap(fmt.Errorf("invalid web link char at %d: %q", len(prefix)+i, c))
+		}
+	}
+	return nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+var (
+	htmlAnchor   = regexp.MustCompile(`(?is)^<a\s[^>]*?\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))[^>]*>.*</a>$`)
+	markdownLink = regexp.MustCompile(`(?s)^\[.*\]\(\s*<?([^\s<>()]+)>?(?:\s+(?:"[^"]*"|'[^']*'))?\s*\)$`)
+)
+
+// unwrapLinkMarkup returns the target of an HTML anchor or of a markdown link,
+// or the trimmed input if it is neither of them.
+func unwrapLinkMarkup(input string) string {
+	input = strings.TrimSpace(input)
+	if match := htmlAnchor.FindStringSubmatch(input); match != nil {
+		return html.UnescapeString(strings.TrimSpace(match[1] + match[2] + match[3]))
+	}
+	if match := markdownLink.FindStringSubmatch(input); match != nil {
+		return match[1]
+	}
+	return input
+}
+
+// maxNestedLinkDepth is the maximum number of URLs wrapping a link that WithNestedLinkUnwrap unwraps,
+// so a crafted URL can't make UnmarshalLink loop.
+const maxNestedLinkDepth = 3
+
+// unwrapNestedLink returns the link wrapped in the query of uri, i.e., "https://share.example.org/?u={link}",
+// or in the query of an URL wrapped in the query of uri, and so on, up to maxNestedLinkDepth wrapping URLs.
+//
+// The query values that are links are preferred to the ones that are URLs with a query, the values are tried
+// in the order of their keys. uri is returned unchanged if it is a link, or if it wraps neither a link nor an URL.
+func unwrapNestedLink(uri string, schemes []string) (string, error) {
+	isLink := func(candidate string) bool {
+		if _, ok := internalLinkPayloadOf(candidate, schemes); ok {
+			return true
+		}
+		_, ok := webLinkFragmentStart(candidate)
+		return ok
+	}
+
+	for depth := 0; ; depth++ {
+		if isLink(uri) {
+			return uri, nil
+		}
+		parsed, err := url.Parse(uri)
+		if err != nil || parsed.RawQuery == "" {
+			return uri, nil
+		}
+
+		values := parsed.Query()
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var link, wrapper string
+		for _, key := range keys {
+			for _, value := range values[key] {
+				// an unescaped '#' of the wrapped web link starts the fragment of the wrapping URL
+				if isWebLinkWithoutFragment(value) && parsed.Fragment != "" {
+					value += "#" + parsed.EscapedFragment()
+				}
+				if link == "" && isLink(value) {
+					link = value
+				}
+				if nested, err := url.Parse(value); wrapper == "" && err == nil && nested.Scheme != "" && nested.RawQuery != "" {
+					wrapper = value
+				}
+			}
+		}
+		if link == "" && wrapper == "" {
+			return uri, nil
+		}
+		if depth == maxNestedLinkDepth {
+			return "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("link wrapped in more than %d URLs", maxNestedLinkDepth))
+		}
+		if link != "" {
+			return link, nil
+		}
+		uri = wrapper
+	}
+}
+
+// internalLinkPayload returns the uppercased right part of an internal link of the configured scheme
+// (see SetLinkInternalPrefix), i.e., "PB1/{payload}".
+//
+// Some deep-link frameworks parse the internal link as an URI with an authority ("PB1" being the host),
+// and hand it back to us in a different form, i.e., "berty://pb1/...", "berty:///PB1/..." or "berty:PB1/...",
+// sometimes with a percent-encoded path.
+// Since the QR alphabet only contains uppercase chars, the whole payload can safely be uppercased.
+func internalLinkPayload(uri string) (string, bool) {
+	return internalLinkPayloadOf(uri, []string{strings.TrimSuffix(CurrentLinkInternalPrefix(), "://")})
+}
+
+// internalLinkPayloadOf is internalLinkPayload for the internal links of any of the given schemes.
+func internalLinkPayloadOf(uri string, schemes []string) (string, bool) {
+	schemeLen := -1
+	for _, scheme := range schemes {
+		if len(uri) > len(scheme) && strings.EqualFold(uri[:len(scheme)+1], scheme+":") {
+			schemeLen = len(scheme) + 1
+			break
+		}
+	}
+	if schemeLen == -1 {
+		return "", false
+	}
+
+	right := strings.TrimLeft(uri[schemeLen:], "/")
+	if strings.Contains(right, "%") {
+		if unescaped, err := url.PathUnescape(right); err == nil {
+			right = unescaped
+		}
+	}
+	return strings.ToUpper(right), true
+}
+
+// webLinkFragmentStart returns the index of the fragment of a web link, i.e., of the first char after "/id#",
+// and false if uri is not a web link.
+//
+// The web links of any host are accepted, so the links of the self-hosted deployments (see WithWebPrefix) are decoded
+// too; the scheme, the host, and the "/id#" path are case-insensitive, a host with user info is rejected.
+func webLinkFragmentStart(uri string) (int, bool) {
+	const path = "/id#"
+	start, ok := webLinkPathStart(uri)
+	if !ok || len(uri) < start+len(path) || !strings.EqualFold(uri[start:start+len(path)], path) {
+		return 0, false
+	}
+	return start + len(path), true
+}
+
+// webLinkPathStart returns the index of the path of an "https://" URI, i.e., of the first char after its host,
+// and false if uri has another scheme, no host, or a host with user info.
+func webLinkPathStart(uri string) (int, bool) {
+	const scheme = "https://"
+	if len(uri) < len(scheme) || !strings.EqualFold(uri[:len(scheme)], scheme) {
+		return 0, false
+	}
+	hostLen := strings.IndexAny(uri[len(scheme):], "/?#@")
+	if hostLen <= 0 {
+		return 0, false
+	}
+	return len(scheme) + hostLen, true
+}
+
+// unescapeWebLinkFragment returns uri with its fragment percent-decoded if the '#' starting it was percent-encoded
+// with the rest of the fragment, i.e., "https://berty.tech/id%23contact%2F...", as some messaging apps do when
+// forwarding a link; the other URIs are returned as is.
+//
+// A single decoding restores the fragment generated by Marshal, the percent-encoded chars of its human-readable part
+// included, since they were encoded a second time.
+func unescapeWebLinkFragment(uri string) string {
+	const path = "/id%23"
+	start, ok := webLinkPathStart(uri)
+	if !ok || strings.Contains(uri[start:], "#") || len(uri) < start+len(path) || !strings.EqualFold(uri[start:start+len(path)], path) {
+		return uri
 	}
+	fragment, err := url.PathUnescape(uri[start+len(path):])
+	if err != nil {
+		return uri
+	}
+	return uri[:start] + "/id#" + fragment
+}
 
-	if err := link.IsValid(); err != nil {
-		return "", "", err
+// isWebLinkWithoutFragment returns true if uri is a web link whose fragment was dropped, i.e., "https://berty.tech/id",
+// as some apps and redirections do, so UnmarshalLink can tell why it can't be decoded.
+func isWebLinkWithoutFragment(uri string) bool {
+	start, ok := webLinkPathStart(uri)
+	if !ok || strings.Contains(uri[start:], "#") {
+		return false
 	}
+	path := uri[start:]
+	if end := strings.IndexByte(path, '?'); end != -1 {
+		path = path[:end]
+	}
+	return strings.EqualFold(strings.TrimSuffix(path, "/"), "/id")
+}
 
-	var (
-		// web
-		kind    string
-		machine = &BertyLink{}
-		human   = url.Values{}
+// from https://www.swisseduc.ch/informatik/theoretische_informatik/qr_codes/docs/qr_standard.pdf
+//
+// Alphanumeric Mode encodes data from a set of 45 characters, i.e.
+// - 10 numeric digits (0 - 9) (ASCII values 30 to 39),
+// - 26 alphabetic characters (A - Z) (ASCII values 41 to 5A),
+// - and 9 symbols (SP, $, %, *, +, -, ., /, :) (ASCII values 20, 24, 25, 2A, 2B, 2D to 2F, 3A).
+//
+// we remove SP, %, +, which changes when passed through url.Encode.
+//
+// the generated string is longer than a base58 one, but the generated QR code is smaller which is best for scanning.
+var qrBaseEncoder, _ = basex.NewEncoding(qrAlphabet)
 
-		// internal
-		qrOptimized = &BertyLink{}
-	)
+const qrAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$*-.:/"
+
+// QRBaseEncode encodes bin like the payloads of the internal links, in base 42 with the alphabet
+// "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$*-.:/", the chars of the QR alphanumeric mode that URLs keep as-is,
+// i.e., for the tools that need to craft or inspect internal links.
+func QRBaseEncode(bin []byte) string {
+	return qrBaseEncoder.Encode(bin)
+}
+
+// QRBaseDecode decodes a string encoded with QRBaseEncode, it returns ErrInvalidInput if s contains a char
+// out of the alphabet, the lowercase letters included.
+func QRBaseDecode(s string) ([]byte, error) {
+	bin, err := qrBaseEncoder.Decode(s)
+	if err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+	return bin, nil
+}
+
+// checksumLinkInternalMarker is the marker of the internal links with a checksum (see WithScanChecksum),
+// in the format: "PC/{payload}{checksum}".
+const checksumLinkInternalMarker = "PC"
+
+// scanChecksumLen is the length of an encoded checksum, the smallest number of qrBaseEncoder digits
+// able to hold a CRC32 (42^6 > 2^32).
+const scanChecksumLen = 6
+
+// encodeScanChecksum returns the fixed-length encoding of sum, with the alphabet of qrBaseEncoder.
+func encodeScanChecksum(sum uint32) string {
+	encoded := make([]byte, scanChecksumLen)
+	value := uint64(sum)
+	for i := scanChecksumLen - 1; i >= 0; i-- {
+		encoded[i] = qrAlphabet[value%uint64(len(qrAlphabet))]
+		value /= uint64(len(qrAlphabet))
+	}
+	return string(encoded)
+}
+
+// checkedLinkBlob decodes the payload of a "PC" internal link, and checks its checksum.
+//
+// Since a scan error can alter the payload as well as the checksum, all the decoding failures are reported
+// as ErrLinkInvalidScan.
+func checkedLinkBlob(blob string) ([]byte, error) {
+	if len(blob) <= scanChecksumLen {
+		return nil, errcode.ErrLinkInvalidScan.Wrap(fmt.Errorf("payload too short: %d chars", len(blob)))
+	}
+	payload, encodedSum := blob[:len(blob)-scanChecksumLen], blob[len(blob)-scanChecksumLen:]
+
+	var sum uint64
+	for _, c := range []byte(encodedSum) {
+		digit := strings.IndexByte(qrAlphabet, c)
+		if digit == -1 {
+			return nil, errcode.ErrLinkInvalidScan.Wrap(fmt.Errorf("invalid checksum char: %q", c))
+		}
+		sum = sum*uint64(len(qrAlphabet)) + uint64(digit)
+	}
+	qrBin, err := qrBaseEncoder.Decode(payload)
+	if err != nil {
+		return nil, errcode.ErrLinkInvalidScan.Wrap(err)
+	}
+	if sum != uint64(crc32.ChecksumIEEE(qrBin)) {
+		return nil, errcode.ErrLinkInvalidScan.Wrap(fmt.Errorf("checksum mismatch"))
+	}
+	return qrBin, nil
+}
+
+// payloadGroupDelimiter separates the groups of a grouped internal link payload (see WithGroupedPayload).
+//
+// It is in the QR AlphaNum alphabet, but not in the alphabet of qrBaseEncoder (unlike '-'), so it can be safely removed.
+const payloadGroupDelimiter = "+"
+
+// groupPayload splits payload into groups of groupLen chars, separated by payloadGroupDelimiter.
+func groupPayload(payload string, groupLen int) string {
+	groups := make([]string, 0, len(payload)/groupLen+1)
+	for len(payload) > groupLen {
+		groups = append(groups, payload[:groupLen])
+		payload = payload[groupLen:]
+	}
+	groups = append(groups, payload)
+	return strings.Join(groups, payloadGroupDelimiter)
+}
 
+// identity returns a copy of the link that only contains the fields identifying the contact, the group
+// or the message, without the kind and without any metadata (i.e., display name).
+func (link *BertyLink) identity() *BertyLink {
+	identity := &BertyLink{}
 	switch link.Kind {
 	case BertyLink_ContactInviteV1Kind:
-		kind = "contact"
-		machine.BertyID = &BertyID{
-			PublicRendezvousSeed: link.BertyID.PublicRendezvousSeed,
-			AccountPK:            link.BertyID.AccountPK,
+		identity.BertyID = &BertyID{
+			PublicRendezvousSeed:      link.BertyID.PublicRendezvousSeed,
+			AccountPK:                 link.BertyID.AccountPK,
+			AdditionalRendezvousSeeds: link.BertyID.AdditionalRendezvousSeeds,
 		}
-		if link.BertyID.DisplayName != "" {
-			human.Add("name", link.BertyID.DisplayName)
+	case BertyLink_GroupV1Kind:
+		identity.BertyGroup = groupIdentity(link.BertyGroup)
+	case BertyLink_GroupSetV1Kind:
+		identity.BertyGroups = make([]*BertyGroup, len(link.BertyGroups))
+		for i, group := range link.BertyGroups {
+			identity.BertyGroups[i] = groupIdentity(group)
+		}
+	case BertyLink_MessageV1Kind:
+		identity.BertyMessageRef = &BertyMessageRef{
+			GroupPK:    link.BertyMessageRef.GroupPK,
+			MessageCID: link.BertyMessageRef.MessageCID,
 		}
+	case BertyLink_AccountPubV1Kind:
+		identity.BertyID = &BertyID{AccountPK: link.BertyID.AccountPK}
+	}
+	return identity
+}
+
+// groupIdentity returns a copy of group that only contains the fields identifying it.
+func groupIdentity(group *BertyGroup) *BertyGroup {
+	return &BertyGroup{
+		Group: &bertytypes.Group{
+			PublicKey: group.Group.PublicKey,
+			Secret:    group.Group.Secret,
+			SecretSig: group.Group.SecretSig,
+			GroupType: group.Group.GroupType,
+			SignPub:   group.Group.SignPub,
+		},
+	}
+}
+
+func (link *BertyLink) IsContact() bool {
+	return link.Kind == BertyLink_ContactInviteV1Kind &&
+		link.IsValid() == nil
+}
+
+func (link *BertyLink) IsGroup() bool {
+	return link.Kind == BertyLink_GroupV1Kind &&
+		link.IsValid() == nil
+}
+
+// IsMessage returns true if link is a valid link to a conversation, and optionally to one of its messages.
+func (link *BertyLink) IsMessage() bool {
+	return link.Kind == BertyLink_MessageV1Kind &&
+		link.IsValid() == nil
+}
 
-		// for contact sharing, there are no fields to hide, so just copy the input link
-		*qrOptimized = *link
+// IsGroupSet returns true if link is a valid group set link, bundling the invites to several groups.
+func (link *BertyLink) IsGroupSet() bool {
+	return link.Kind == BertyLink_GroupSetV1Kind &&
+		link.IsValid() == nil
+}
+
+// BertyLink_GroupBundleV1Kind is the kind of the group bundle links, another name of the group set links
+// (BertyLink_GroupSetV1Kind), bundling the invites to several groups.
+const BertyLink_GroupBundleV1Kind = BertyLink_GroupSetV1Kind // nolint:golint,stylecheck // named like the generated kinds
+
+// IsGroupBundle is IsGroupSet, a group bundle link is a group set link.
+func (link *BertyLink) IsGroupBundle() bool {
+	return link.IsGroupSet()
+}
+
+// Groups returns the groups of a group or group set link, in order, or nil for the other kinds.
+func (link *BertyLink) Groups() []*BertyGroup {
+	switch link.GetKind() {
 	case BertyLink_GroupV1Kind:
-		kind = "group"
-		machine.BertyGroup = &BertyGroup{
-			Group: &bertytypes.Group{
-				PublicKey: link.BertyGroup.Group.PublicKey,
-				Secret:    link.BertyGroup.Group.Secret,
-				SecretSig: link.BertyGroup.Group.SecretSig,
-				GroupType: link.BertyGroup.Group.GroupType,
-				SignPub:   link.BertyGroup.Group.SignPub,
-			},
-		}
-		if link.BertyGroup.DisplayName != "" {
-			human.Add("name", link.BertyGroup.DisplayName)
-		}
-		*qrOptimized = *link
-	default:
-		return "", "", errcode.ErrInvalidInput
+		if link.BertyGroup == nil {
+			return nil
+		}
+		return []*BertyGroup{link.BertyGroup}
+	case BertyLink_GroupSetV1Kind:
+		return link.BertyGroups
 	}
+	return nil
+}
 
-	// compute the web shareable link.
-	// in this mode, we have:
-	// - a human-readable link kind
-	// - a base58-encoded binary (proto) representation of the link (without the kind and metadata)
-	// - human-readable metadata, encoded as query string (including display name)
-	{
-		machineBin, err := proto.Marshal(machine)
-		if err != nil {
-			return "", "", errcode.ErrInvalidInput.Wrap(err)
+// IsAccount returns true if link is a valid account link, i.e., to look the account up in a directory.
+func (link *BertyLink) IsAccount() bool {
+	return link.Kind == BertyLink_AccountPubV1Kind &&
+		link.IsValid() == nil
+}
+
+// IsForGroup returns true if link is a valid group link for the group with the public key groupPK,
+// i.e., to check that an invite is for a group whose public key is already known.
+func (link *BertyLink) IsForGroup(groupPK []byte) bool {
+	return link != nil && len(groupPK) > 0 &&
+		link.IsGroup() &&
+		bytes.Equal(link.BertyGroup.Group.PublicKey, groupPK)
+}
+
+// IsValid returns an error if the link is missing mandatory fields, or if it contains a sub-struct not matching its kind.
+func (link *BertyLink) IsValid() error {
+	return link.isValid(false)
+}
+
+// isValid is IsValid, but it accepts the groups of any type if allowNonMultiMember is set (see WithAllowNonMultiMember).
+func (link *BertyLink) isValid(allowNonMultiMember bool) error {
+	if link == nil {
+		return errcode.ErrMissingInput
+	}
+	if link.Kind != BertyLink_MessageV1Kind && link.BertyMessageRef != nil {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can't contain a message reference", link.Kind))
+	}
+	if link.Kind != BertyLink_ContactInviteV1Kind && len(link.Signature) > 0 {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can't be signed", link.Kind))
+	}
+	switch link.Kind {
+	case BertyLink_ContactInviteV1Kind:
+		if link.BertyGroup != nil || len(link.BertyGroups) > 0 {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can't contain a group", link.Kind))
+		}
+		// empty slices are checked too, they would be decoded as nil fields
+		if link.BertyID == nil ||
+			len(link.BertyID.AccountPK) == 0 ||
+			len(link.BertyID.PublicRendezvousSeed) == 0 {
+			return errcode.ErrMissingInput
+		}
+		if err := checkKeyLength("account public key", link.BertyID.AccountPK, LinkAccountPKLength); err != nil {
+			return err
+		}
+		if err := checkKeyLength("rendezvous seed", link.BertyID.PublicRendezvousSeed, LinkRendezvousSeedLength); err != nil {
+			return err
+		}
+		if err := checkDisplayName(link.BertyID.DisplayName); err != nil {
+			return err
 		}
-		// here we use base58 which is compressed enough whilst being easy to read by a human.
-		// another candidate could be base58.RawURLEncoding which is a little bit more compressed and also only containing unescaped URL chars.
-		machineEncoded := base58.Encode(machineBin)
-		path := kind + "/" + machineEncoded
-		if len(human) > 0 {
-			path += "/" + human.Encode()
+		if err := checkAdditionalRendezvousSeeds(link.BertyID); err != nil {
+			return err
 		}
-		// we use a '#' to improve privacy by preventing the webservers to get aware of the right part of this URL
-		web = LinkWebPrefix + path
+		return checkReferralCode(link.BertyID.ReferralCode)
+	case BertyLink_GroupV1Kind:
+		if link.BertyID != nil {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can't contain a contact", link.Kind))
+		}
+		if len(link.BertyGroups) > 0 {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can't contain a group set", link.Kind))
+		}
+		return checkLinkGroup(link.BertyGroup, allowNonMultiMember)
+	case BertyLink_GroupSetV1Kind:
+		if link.BertyID != nil || link.BertyGroup != nil {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can only contain a group set", link.Kind))
+		}
+		if len(link.BertyGroups) == 0 {
+			return errcode.ErrMissingInput
+		}
+		if len(link.BertyGroups) > MaxLinkGroupSetSize {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a group set can contain at most %d groups", MaxLinkGroupSetSize))
+		}
+		publicKeys := make(map[string]bool, len(link.BertyGroups))
+		for _, group := range link.BertyGroups {
+			if err := checkLinkGroup(group, allowNonMultiMember); err != nil {
+				return err
+			}
+			if publicKeys[string(group.Group.PublicKey)] {
+				return errcode.ErrInvalidInput.Wrap(fmt.Errorf("duplicate group in the group set"))
+			}
+			publicKeys[string(group.Group.PublicKey)] = true
+		}
+		return nil
+	case BertyLink_MessageV1Kind:
+		if link.BertyID != nil || link.BertyGroup != nil || len(link.BertyGroups) > 0 {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can only contain a message reference", link.Kind))
+		}
+		if link.BertyMessageRef == nil || len(link.BertyMessageRef.GroupPK) == 0 {
+			return errcode.ErrMissingInput.Wrap(fmt.Errorf("missing group public key"))
+		}
+		return checkKeyLength("group public key", link.BertyMessageRef.GroupPK, LinkGroupPublicKeyLength)
+	case BertyLink_AccountPubV1Kind:
+		if link.BertyGroup != nil || len(link.BertyGroups) > 0 {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can't contain a group", link.Kind))
+		}
+		if link.BertyID == nil || len(link.BertyID.AccountPK) == 0 {
+			return errcode.ErrMissingInput.Wrap(fmt.Errorf("missing account public key"))
+		}
+		// an account link is only meant to look the account up, so it can't carry what is needed to contact it
+		if id := link.BertyID; len(id.PublicRendezvousSeed) > 0 || len(id.AdditionalRendezvousSeeds) > 0 || id.DisplayName != "" || id.ReferralCode != "" {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a %q link can only contain the account public key", link.Kind))
+		}
+		return checkKeyLength("account public key", link.BertyID.AccountPK, LinkAccountPKLength)
 	}
+	return errcode.ErrInvalidInput
+}
 
-	// compute the internal shareable link.
-	// in this mode, the url is as short as possible, in the format: berty://{base45(proto.marshal(link))}.
-	{
-		qrBin, err := proto.Marshal(qrOptimized)
-		if err != nil {
-			return "", "", errcode.ErrInvalidInput.Wrap(err)
+// MaxAdditionalRendezvousSeeds is the maximum number of additional rendezvous seeds of a contact link,
+// each seed makes the QR code larger.
+const MaxAdditionalRendezvousSeeds = 3
+
+// RendezvousSeeds returns the rendezvous seeds of the contact, the public one first, then the additional ones from
+// the most recent, i.e., the order in which the recipient should try them.
+//
+// The links without additional seeds, i.e., the ones generated by the older apps, return a one-element list.
+func (id *BertyID) RendezvousSeeds() [][]byte {
+	if id == nil || len(id.PublicRendezvousSeed) == 0 {
+		return nil
+	}
+	seeds := make([][]byte, 0, 1+len(id.AdditionalRendezvousSeeds))
+	seeds = append(seeds, id.PublicRendezvousSeed)
+	return append(seeds, id.AdditionalRendezvousSeeds...)
+}
+
+// checkAdditionalRendezvousSeeds returns ErrInvalidInput if the contact has too many additional rendezvous seeds,
+// or an empty or duplicate one.
+func checkAdditionalRendezvousSeeds(id *BertyID) error {
+	if len(id.GetAdditionalRendezvousSeeds()) > MaxAdditionalRendezvousSeeds {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("a contact link can have at most %d additional rendezvous seeds", MaxAdditionalRendezvousSeeds))
+	}
+	seen := map[string]bool{string(id.GetPublicRendezvousSeed()): true}
+	for _, seed := range id.GetAdditionalRendezvousSeeds() {
+		if len(seed) == 0 {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("empty additional rendezvous seed"))
 		}
-		// using uppercase to stay in the QR AlphaNum's 45chars alphabet
-		internal = LinkInternalPrefix + "PB/" + qrBaseEncoder.Encode(qrBin)
+		if err := checkKeyLength("additional rendezvous seed", seed, LinkRendezvousSeedLength); err != nil {
+			return err
+		}
+		if seen[string(seed)] {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("duplicate rendezvous seed"))
+		}
+		seen[string(seed)] = true
 	}
+	return nil
+}
 
-	return internal, web, nil
+// checkLinkGroup returns an error if group is missing mandatory fields, or can't be shared.
+//
+// Only the multi-member groups can be shared, unless allowNonMultiMember is set, which also lets the account and
+// contact groups carry their secret (see checkGroupSecretPolicy).
+func checkLinkGroup(group *BertyGroup, allowNonMultiMember bool) error {
+	if group == nil || group.Group == nil {
+		return errcode.ErrMissingInput
+	}
+	// the secrets are optional (see WithoutGroupSecret), but the public keys are needed to join the group
+	if len(group.Group.PublicKey) == 0 {
+		return errcode.ErrMissingInput.Wrap(fmt.Errorf("missing group public key"))
+	}
+	if len(group.Group.SignPub) == 0 {
+		return errcode.ErrMissingInput.Wrap(fmt.Errorf("missing group signing public key"))
+	}
+	if err := checkGroupKeyLengths(group.Group); err != nil {
+		return err
+	}
+	// the group type comes first, a group that can't be shared is rejected as such, whatever its secret
+	if groupType := group.Group.GroupType; groupType != bertytypes.GroupTypeMultiMember && !allowNonMultiMember {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("can't share a %q group type", groupType))
+	}
+	if err := checkGroupSecretPolicy(group.Group, allowNonMultiMember); err != nil {
+		return err
+	}
+	if err := checkDisplayName(group.DisplayName); err != nil {
+		return err
+	}
+	return checkRegenerationLog(group.RegenerationLog)
 }
 
-// UnmarshalLink takes an URL generated by BertyLink.Marshal (or manually crafted), and returns a BertyLink object.
-func UnmarshalLink(uri string) (*BertyLink, error) {
-	if uri == "" {
-		return nil, errcode.ErrMissingInput
+// The lengths of the keys carried by the links, IsValid and UnmarshalLink reject the keys of any other length, so a
+// corrupted or forged link fails early instead of during the connection setup.
+const (
+	// LinkAccountPKLength is the length of the account public key of a contact (an Ed25519 public key).
+	LinkAccountPKLength = ed25519.PublicKeySize
+	// LinkRendezvousSeedLength is the length of the rendezvous seeds of a contact.
+	LinkRendezvousSeedLength = bertytypes.RendezvousSeedLength
+	// LinkGroupPublicKeyLength is the length of the public key of a group (an Ed25519 public key).
+	LinkGroupPublicKeyLength = ed25519.PublicKeySize
+	// LinkGroupSecretLength is the length of the secret of a group (an Ed25519 seed).
+	LinkGroupSecretLength = ed25519.SeedSize
+	// LinkGroupSecretSigLength is the length of the signature of the secret of a group (an Ed25519 signature).
+	LinkGroupSecretSigLength = ed25519.SignatureSize
+	// LinkGroupSignPubLength is the length of the signing public key of a group (an Ed25519 public key).
+	LinkGroupSignPubLength = ed25519.PublicKeySize
+)
+
+// checkKeyLength returns ErrInvalidInput if key is not length bytes long, name being used in the error message.
+func checkKeyLength(name string, key []byte, length int) error {
+	if len(key) != length {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("%s length should be %d, not %d", name, length, len(key)))
 	}
+	return nil
+}
 
-	// internal format
-	if strings.HasPrefix(strings.ToLower(uri), strings.ToLower(LinkInternalPrefix)) {
-		right := uri[len(LinkInternalPrefix):]
-		parts := strings.Split(right, "/")
-		if len(parts) < 2 {
-			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("URI should have at least 2 parts"))
-		}
-		switch strings.ToLower(parts[0]) {
-		case "pb":
-			blob := strings.Join(parts[1:], "/")
-			qrBin, err := qrBaseEncoder.Decode(blob)
-			if err != nil {
-				return nil, errcode.ErrInvalidInput.Wrap(err)
+// checkKeyLengths returns ErrInvalidInput if one of the keys of the link has an unexpected length,
+// the missing keys are left to IsValid.
+func (link *BertyLink) checkKeyLengths() error {
+	if id := link.GetBertyID(); id != nil {
+		if len(id.AccountPK) > 0 {
+			if err := checkKeyLength("account public key", id.AccountPK, LinkAccountPKLength); err != nil {
+				return err
 			}
-			var link BertyLink
-			err = proto.Unmarshal(qrBin, &link)
-			if err != nil {
-				return nil, errcode.ErrInvalidInput.Wrap(err)
+		}
+		if len(id.PublicRendezvousSeed) > 0 {
+			if err := checkKeyLength("rendezvous seed", id.PublicRendezvousSeed, LinkRendezvousSeedLength); err != nil {
+				return err
 			}
-			return &link, nil
-		default:
-			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported link type: %q", parts[0]))
 		}
 	}
+	for _, group := range append([]*BertyGroup{link.BertyGroup}, link.BertyGroups...) {
+		if group.GetGroup() == nil {
+			continue
+		}
+		if err := checkGroupKeyLengths(group.Group); err != nil {
+			return err
+		}
+	}
+	if ref := link.GetBertyMessageRef(); ref != nil && len(ref.GroupPK) > 0 {
+		return checkKeyLength("group public key", ref.GroupPK, LinkGroupPublicKeyLength)
+	}
+	return nil
+}
 
-	// web format
-	if strings.HasPrefix(strings.ToLower(uri), strings.ToLower(LinkWebPrefix)) {
-		parsed, err := url.Parse(uri)
-		if err != nil {
-			return nil, errcode.ErrInvalidInput.Wrap(err)
+// checkGroupKeyLengths returns ErrInvalidInput if one of the keys of g has an unexpected length,
+// the missing keys are ignored, since the secret can be stripped from the links.
+func checkGroupKeyLengths(g *bertytypes.Group) error {
+	for _, key := range []struct {
+		name   string
+		value  []byte
+		length int
+	}{
+		{"group public key", g.PublicKey, LinkGroupPublicKeyLength},
+		{"group secret", g.Secret, LinkGroupSecretLength},
+		{"group secret signature", g.SecretSig, LinkGroupSecretSigLength},
+		{"group signing public key", g.SignPub, LinkGroupSignPubLength},
+	} {
+		if len(key.value) == 0 {
+			continue
 		}
-		if parsed.Fragment == "" {
-			return nil, errcode.ErrInvalidInput.Wrap(err)
+		if err := checkKeyLength(key.name, key.value, key.length); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		rawFragment := strings.Join(strings.Split(uri, "#")[1:], "#") // required by go1.14
-		// when minimal version of berty will be go1.15, we can just use `parsed.EscapedFragment()`
+// MaxDisplayNameLength is the maximum length in bytes of the display name of a contact or a group link,
+// a longer name would make the QR code too dense to be scanned.
+//
+// IsValid rejects the longer names, so Marshal never generates them, while the names of the human-readable part
+// of the web links, that anyone can edit, are truncated (on a rune boundary) by UnmarshalLink.
+const MaxDisplayNameLength = 256
 
-		link := BertyLink{}
-		parts := strings.Split(rawFragment, "/")
-		if len(parts) < 2 {
-			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("URI should have at least 2 parts"))
-		}
+func checkDisplayName(name string) error {
+	if len(name) > MaxDisplayNameLength {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("display name should have at most %d bytes", MaxDisplayNameLength))
+	}
+	return nil
+}
 
-		// decode blob
-		machineBin, err := base58.Decode(parts[1])
-		if err != nil {
-			return nil, errcode.ErrInvalidInput.Wrap(err)
+// SanitizeDisplayName returns name without its control chars (C0, C1, and the Unicode bidi controls, i.e., the RTL
+// override), with its whitespace runs, line breaks included, collapsed into a single space, and trimmed.
+//
+// Anyone can edit the names of the human-readable part of a web link, UnmarshalLink sanitizes them so they can't
+// alter the rendering of the UI; the names of the other sources should be sanitized before being displayed too.
+func SanitizeDisplayName(name string) string {
+	var (
+		sanitized strings.Builder
+		space     bool
+	)
+	sanitized.Grow(len(name))
+	for _, r := range name {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case unicode.IsControl(r), unicode.Is(unicode.Bidi_Control, r):
+			continue
 		}
-		if err := proto.Unmarshal(machineBin, &link); err != nil {
-			return nil, errcode.ErrInvalidInput.Wrap(err)
+		if space && sanitized.Len() > 0 {
+			sanitized.WriteByte(' ')
 		}
+		space = false
+		sanitized.WriteRune(r)
+	}
+	return sanitized.String()
+}
 
-		// decode url.Values
-		var human url.Values
-		if len(parts) > 2 {
-			encodedValues := strings.Join(parts[2:], "/")
-			human, err = url.ParseQuery(encodedValues)
-			if err != nil {
-				return nil, errcode.ErrInvalidInput.Wrap(err)
-			}
-		}
+// webDisplayName returns a display name read from the human-readable part of a web link, sanitized and truncated
+// to MaxDisplayNameLength.
+func webDisplayName(name string) string {
+	return truncateUTF8(NormalizeDisplayName(SanitizeDisplayName(name)), MaxDisplayNameLength)
+}
 
-		// per-kind merging strategies and checks
-		switch kind := parts[0]; kind {
-		case "contact":
-			link.Kind = BertyLink_ContactInviteV1Kind
-			if link.BertyID == nil {
-				link.BertyID = &BertyID{}
-			}
-			if name := human.Get("name"); name != "" && link.BertyID.DisplayName == "" {
-				link.BertyID.DisplayName = name
-			}
-		case "group":
-			link.Kind = BertyLink_GroupV1Kind
-			if link.BertyGroup == nil {
-				link.BertyGroup = &BertyGroup{}
-			}
-			if name := human.Get("name"); name != "" && link.BertyGroup.DisplayName == "" {
-				link.BertyGroup.DisplayName = name
-			}
-		default:
-			return nil, errcode.ErrInvalidInput
-		}
+// NormalizeDisplayName returns name in the Unicode NFC normalization form, so the visually identical names typed
+// with different composition forms, i.e., "é" as one code point or as "e" followed by a combining accent,
+// are generated and compared the same way.
+//
+// Marshal normalizes the display names of the generated links, and UnmarshalLink the ones of the parsed links.
+func NormalizeDisplayName(name string) string {
+	return norm.NFC.String(name)
+}
 
-		return &link, nil
+// normalizeDisplayNames normalizes the display names of the link in place (see NormalizeDisplayName).
+func (link *BertyLink) normalizeDisplayNames() {
+	if link.BertyID != nil {
+		link.BertyID.DisplayName = NormalizeDisplayName(link.BertyID.DisplayName)
 	}
+	if link.BertyGroup != nil {
+		link.BertyGroup.DisplayName = NormalizeDisplayName(link.BertyGroup.DisplayName)
+	}
+	for _, group := range link.BertyGroups {
+		if group != nil {
+			group.DisplayName = NormalizeDisplayName(group.DisplayName)
+		}
+	}
+}
 
-	return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported link format"))
+// truncateUTF8 returns the longest prefix of s of at most max bytes that doesn't split a rune.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
 }
 
+// groupSecretPolicy tells whether a shared group link can carry the group secret.
+type groupSecretPolicy int
+
 const (
-	LinkWebPrefix      = "https://berty.tech/id#"
-	LinkInternalPrefix = "BERTY://"
+	groupSecretForbidden groupSecretPolicy = iota
+	groupSecretOptional
 )
 
-// from https://www.swisseduc.ch/informatik/theoretische_informatik/qr_codes/docs/qr_standard.pdf
+// groupSecretPolicies are the group secret policies of the group types, the missing types forbid the secret.
 //
-// Alphanumeric Mode encodes data from a set of 45 characters, i.e.
-// - 10 numeric digits (0 - 9) (ASCII values 30 to 39),
-// - 26 alphabetic characters (A - Z) (ASCII values 41 to 5A),
-// - and 9 symbols (SP, $, %, *, +, -, ., /, :) (ASCII values 20, 24, 25, 2A, 2B, 2D to 2F, 3A).
+// The secret of a multi-member group is what allows the recipient to join it, but it can be stripped to only
+// share the group identity (see WithoutGroupSecret).
+// The account and contact groups are never shared, leaking their secret would expose all their messages,
+// and so would the secret of the future public group types, meant to be readable without it.
+var groupSecretPolicies = map[bertytypes.GroupType]groupSecretPolicy{
+	bertytypes.GroupTypeMultiMember: groupSecretOptional,
+}
+
+// nonMultiMemberGroupSecretPolicies override groupSecretPolicies for the links of WithAllowNonMultiMember.
 //
-// we remove SP, %, +, which changes when passed through url.Encode.
+// These links are the admin, debug, or backup ones, a stored account or contact group always has its secret,
+// and serializing it is the point. The future public group types still forbid it.
+var nonMultiMemberGroupSecretPolicies = map[bertytypes.GroupType]groupSecretPolicy{
+	bertytypes.GroupTypeAccount: groupSecretOptional,
+	bertytypes.GroupTypeContact: groupSecretOptional,
+}
+
+// checkGroupSecretPolicy returns ErrLinkGroupSecretMismatch if g carries a secret (or its signature)
+// while its group type forbids it, allowNonMultiMember being the one of checkLinkGroup.
+func checkGroupSecretPolicy(g *bertytypes.Group, allowNonMultiMember bool) error {
+	if len(g.Secret) == 0 && len(g.SecretSig) == 0 {
+		return nil
+	}
+	policy := groupSecretPolicies[g.GroupType]
+	if override, ok := nonMultiMemberGroupSecretPolicies[g.GroupType]; ok && allowNonMultiMember {
+		policy = override
+	}
+	if policy == groupSecretForbidden {
+		return errcode.ErrLinkGroupSecretMismatch.Wrap(fmt.Errorf("a %q group link can't carry the group secret", g.GroupType))
+	}
+	return nil
+}
+
+// IsActive returns true if now is within the validity period of the link.
 //
-// the generated string is longer than a base58 one, but the generated QR code is smaller which is best for scanning.
-var qrBaseEncoder, _ = basex.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$*-.:/")
+// A link without NotBeforeUnix and ExpiresAtUnix is always active.
+func (link *BertyLink) IsActive(now time.Time) bool {
+	return link.checkActive(now) == nil
+}
 
-func (link *BertyLink) IsContact() bool {
-	return link.Kind == BertyLink_ContactInviteV1Kind &&
-		link.IsValid() == nil
+// ExpiresAt returns the time after which the link can't be used anymore, or the zero time if the link never expires.
+func (link *BertyLink) ExpiresAt() time.Time {
+	expiresAt := link.GetExpiresAtUnix()
+	if expiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(expiresAt, 0)
 }
 
-func (link *BertyLink) IsGroup() bool {
-	return link.Kind == BertyLink_GroupV1Kind &&
-		link.IsValid() == nil
+// IsExpired returns true if the link has an expiry, and now is after it.
+//
+// Unlike IsActive, it ignores NotBeforeUnix, a link that is not valid yet is not expired.
+func (link *BertyLink) IsExpired(now time.Time) bool {
+	expiresAt := link.ExpiresAt()
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
 }
 
-func (link *BertyLink) IsValid() error {
-	if link == nil {
-		return errcode.ErrMissingInput
+func (link *BertyLink) checkActive(now time.Time) error {
+	if notBefore := link.GetNotBeforeUnix(); notBefore != 0 && now.Unix() < notBefore {
+		return errcode.ErrLinkNotYetValid.Wrap(fmt.Errorf("link is not valid before %s", time.Unix(notBefore, 0).UTC()))
 	}
-	switch link.Kind {
-	case BertyLink_ContactInviteV1Kind:
-		if link.BertyID == nil ||
-			link.BertyID.AccountPK == nil ||
-			link.BertyID.PublicRendezvousSeed == nil {
-			return errcode.ErrMissingInput
-		}
-		return nil
+	if expiresAt := link.GetExpiresAtUnix(); expiresAt != 0 && now.Unix() >= expiresAt {
+		return errcode.ErrLinkExpired.Wrap(fmt.Errorf("link expired at %s", time.Unix(expiresAt, 0).UTC()))
+	}
+	return nil
+}
+
+// RecommendedSecretLinkExpiry is the expiry recommended for the links carrying a secret (see RecommendedExpiry).
+const RecommendedSecretLinkExpiry = 7 * 24 * time.Hour
+
+// RecommendedExpiry returns a suggested lifetime for the link, i.e., to pre-fill an expiry picker,
+// 0 meaning that no expiry is recommended.
+//
+// The group links carrying the group secret allow anyone to join the group, so they should not be valid forever,
+// while the contact links (and the group links without secret) are public and can be shared without expiry.
+// This is only advisory, nothing enforces it.
+func (link *BertyLink) RecommendedExpiry() time.Duration {
+	if link.HasGroupSecret() {
+		return RecommendedSecretLinkExpiry
+	}
+	return 0
+}
+
+// HasGroupSecret returns true if the link carries the secret of at least one group.
+func (link *BertyLink) HasGroupSecret() bool {
+	switch link.GetKind() {
 	case BertyLink_GroupV1Kind:
-		if link.BertyGroup == nil {
-			return errcode.ErrMissingInput
-		}
-		if groupType := link.BertyGroup.Group.GroupType; groupType != bertytypes.GroupTypeMultiMember {
-			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("can't share a %q group type", groupType))
+		return len(link.GetBertyGroup().GetGroup().GetSecret()) > 0
+	case BertyLink_GroupSetV1Kind:
+		for _, group := range link.BertyGroups {
+			if len(group.GetGroup().GetSecret()) > 0 {
+				return true
+			}
 		}
-		return nil
 	}
-	return errcode.ErrInvalidInput
+	return false
 }
 
 func (id *BertyID) GetBertyLink() *BertyLink {
@@ -271,3 +1788,55 @@ func (group *BertyGroup) GetBertyLink() *BertyLink {
 		BertyGroup: group,
 	}
 }
+
+// GetBertyLinkWithName is GetBertyLink, with the display name of the contact set to name, i.e., for a name coming
+// from the settings of the app; id is left untouched, the link contains a copy of it.
+func (id *BertyID) GetBertyLinkWithName(name string) *BertyLink {
+	named := &BertyID{}
+	if id != nil {
+		named = proto.Clone(id).(*BertyID)
+	}
+	named.DisplayName = name
+	return named.GetBertyLink()
+}
+
+// GetBertyLinkWithName is GetBertyLink, with the display name of the group set to name;
+// group is left untouched, the link contains a copy of it.
+func (group *BertyGroup) GetBertyLinkWithName(name string) *BertyLink {
+	named := &BertyGroup{}
+	if group != nil {
+		named = proto.Clone(group).(*BertyGroup)
+	}
+	named.DisplayName = name
+	return named.GetBertyLink()
+}
+
+// MaxLinkGroupSetSize is the maximum number of groups of a group set link, so its QR code stays easy to scan.
+const MaxLinkGroupSetSize = 5
+
+// MarshalGroups returns shareable web and internal URLs of a group set link, bundling several group invites,
+// i.e., "all my public communities".
+//
+// Each group must be shareable, like the groups of the GroupV1Kind links.
+func MarshalGroups(groups []*BertyGroup, opts ...MarshalOption) (internal string, web string, err error) {
+	link := &BertyLink{
+		Kind:        BertyLink_GroupSetV1Kind,
+		BertyGroups: groups,
+	}
+	return link.Marshal(opts...)
+}
+
+// BertyLinkFromGroup returns a group invitation link for a stored group, i.e., the group of a joined conversation.
+//
+// It returns ErrInvalidInput if the group can't be shared, only the multi-member groups can, i.e., the contact and
+// account groups of the account, which always carry their secret.
+func BertyLinkFromGroup(g *bertytypes.Group, name string) (*BertyLink, error) {
+	if g == nil {
+		return nil, errcode.ErrMissingInput
+	}
+	link := (&BertyGroup{Group: g, DisplayName: name}).GetBertyLink()
+	if err := link.IsValid(); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
@@ -0,0 +1,126 @@
+package bertymessenger
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/aztec"
+	"github.com/boombuler/barcode/datamatrix"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// BarcodeFormat is the symbology of a barcode rendered by BertyLink.MarshalBarcode.
+type BarcodeFormat int
+
+const (
+	// BarcodeFormatQR is a QR code, the default format and the only one scanned by the Berty apps.
+	BarcodeFormatQR BarcodeFormat = iota
+	// BarcodeFormatAztec is an Aztec code, it doesn't need a quiet zone.
+	BarcodeFormatAztec
+	// BarcodeFormatDataMatrix is an ECC 200 Data Matrix code.
+	BarcodeFormatDataMatrix
+)
+
+func (format BarcodeFormat) String() string {
+	switch format {
+	case BarcodeFormatQR:
+		return "QR"
+	case BarcodeFormatAztec:
+		return "Aztec"
+	case BarcodeFormatDataMatrix:
+		return "DataMatrix"
+	}
+	return fmt.Sprintf("BarcodeFormat(%d)", int(format))
+}
+
+const (
+	// aztecMinECCPercent is the minimal error correction of the Aztec codes, the one recommended by the spec.
+	aztecMinECCPercent = 23
+
+	// defaultBarcodeModuleSize is the size in pixels of a module of the barcodes rendered by MarshalBarcode.
+	defaultBarcodeModuleSize = defaultQRCardModuleSize
+)
+
+// barcodeQuietZones are the widths, in modules, of the quiet zone required by each barcode format.
+var barcodeQuietZones = map[BarcodeFormat]int{
+	BarcodeFormatQR:         qrQuietZone,
+	BarcodeFormatAztec:      0,
+	BarcodeFormatDataMatrix: 1,
+}
+
+// MarshalBarcode renders the internal link as a PNG barcode of the given format, i.e., for the industrial scanners
+// that read Aztec or Data Matrix codes better than QR codes.
+//
+// The barcode has a module size of 8 pixels, and keeps the quiet zone required by its format.
+// MarshalBarcode returns ErrLinkTooLarge if the internal link exceeds the capacity of the format.
+func (link *BertyLink) MarshalBarcode(format BarcodeFormat) ([]byte, error) {
+	quietZone, ok := barcodeQuietZones[format]
+	if !ok {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported barcode format: %s", format))
+	}
+
+	bitmap, err := link.barcodeBitmap(format)
+	if err != nil {
+		return nil, err
+	}
+
+	width := (len(bitmap[0]) + 2*quietZone) * defaultBarcodeModuleSize
+	height := (len(bitmap) + 2*quietZone) * defaultBarcodeModuleSize
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	origin := image.Pt(quietZone*defaultBarcodeModuleSize, quietZone*defaultBarcodeModuleSize)
+	drawQRModules(img, bitmap, origin, defaultBarcodeModuleSize, color.Black)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// barcodeBitmap returns the modules of the barcode encoding the internal link, without quiet zone,
+// indexed by row then column, true being a dark module.
+func (link *BertyLink) barcodeBitmap(format BarcodeFormat) ([][]bool, error) {
+	internal, err := link.ToInternal()
+	if err != nil {
+		return nil, err
+	}
+
+	var code barcode.Barcode
+	switch format {
+	case BarcodeFormatQR:
+		qr, err := qrcode.New(internal, qrRecoveryLevel)
+		if err != nil {
+			return nil, errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("the link doesn't fit in a %s code: %w", format, err))
+		}
+		qr.DisableBorder = true
+		return qr.Bitmap(), nil
+	case BarcodeFormatAztec:
+		code, err = aztec.Encode([]byte(internal), aztecMinECCPercent, aztec.DEFAULT_LAYERS)
+	case BarcodeFormatDataMatrix:
+		code, err = datamatrix.Encode(internal)
+	default:
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported barcode format: %s", format))
+	}
+	if err != nil {
+		return nil, errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("the link doesn't fit in a %s code: %w", format, err))
+	}
+
+	bounds := code.Bounds()
+	bitmap := make([][]bool, bounds.Dy())
+	for y := range bitmap {
+		bitmap[y] = make([]bool, bounds.Dx())
+		for x := range bitmap[y] {
+			r, g, b, _ := code.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			bitmap[y][x] = r+g+b < 3*0x7fff
+		}
+	}
+	return bitmap, nil
+}
@@ -0,0 +1,109 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/aztec"
+	"github.com/boombuler/barcode/datamatrix"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkMarshalBarcode(t *testing.T) {
+	const moduleSize = 8
+
+	cases := []struct {
+		format    bertymessenger.BarcodeFormat
+		quietZone int
+		encode    func(t *testing.T, content string) [][]bool
+	}{
+		{bertymessenger.BarcodeFormatQR, 4, func(t *testing.T, content string) [][]bool {
+			qr, err := qrcode.New(content, qrcode.Medium)
+			require.NoError(t, err)
+			qr.DisableBorder = true
+			return qr.Bitmap()
+		}},
+		{bertymessenger.BarcodeFormatAztec, 0, func(t *testing.T, content string) [][]bool {
+			code, err := aztec.Encode([]byte(content), 23, aztec.DEFAULT_LAYERS)
+			require.NoError(t, err)
+			return barcodeBitmap(code)
+		}},
+		{bertymessenger.BarcodeFormatDataMatrix, 1, func(t *testing.T, content string) [][]bool {
+			code, err := datamatrix.Encode(content)
+			require.NoError(t, err)
+			require.Equal(t, content, code.Content())
+			return barcodeBitmap(code)
+		}},
+	}
+
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		for _, tc := range cases {
+			t.Run(link.Kind.String()+"/"+tc.format.String(), func(t *testing.T) {
+				out, err := link.MarshalBarcode(tc.format)
+				require.NoError(t, err)
+				img, err := png.Decode(bytes.NewReader(out))
+				require.NoError(t, err)
+
+				// read the modules of the rendered barcode, and compare them with the barcode of the internal link
+				internal, err := link.ToInternal()
+				require.NoError(t, err)
+				expected := tc.encode(t, internal)
+				bounds := img.Bounds()
+				require.Equal(t, (len(expected[0])+2*tc.quietZone)*moduleSize, bounds.Dx())
+				require.Equal(t, (len(expected)+2*tc.quietZone)*moduleSize, bounds.Dy())
+				for y, row := range expected {
+					for x, black := range row {
+						r, g, b, _ := img.At((tc.quietZone+x)*moduleSize+moduleSize/2, (tc.quietZone+y)*moduleSize+moduleSize/2).RGBA()
+						dark := r+g+b < 3*0x7fff
+						require.Equal(t, black, dark, "module x=%d y=%d", x, y)
+					}
+				}
+
+				// the matched barcode contains the internal link
+				parsed, err := bertymessenger.UnmarshalLink(internal)
+				require.NoError(t, err)
+				require.Equal(t, link, parsed)
+			})
+		}
+	}
+
+	// QR is the default format
+	var format bertymessenger.BarcodeFormat
+	require.Equal(t, bertymessenger.BarcodeFormatQR, format)
+
+	t.Run("too-large", func(t *testing.T) {
+		link := testGroupLink()
+		link.Metadata = map[string]string{"description": strings.Repeat("A group about everything. ", 200)}
+		for _, tc := range cases {
+			_, err := link.MarshalBarcode(tc.format)
+			require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge), tc.format)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := testContactLink().MarshalBarcode(bertymessenger.BarcodeFormat(42))
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		_, err = (&bertymessenger.BertyLink{}).MarshalBarcode(bertymessenger.BarcodeFormatAztec)
+		require.Error(t, err)
+	})
+}
+
+func barcodeBitmap(code barcode.Barcode) [][]bool {
+	bounds := code.Bounds()
+	bitmap := make([][]bool, bounds.Dy())
+	for y := range bitmap {
+		bitmap[y] = make([]bool, bounds.Dx())
+		for x := range bitmap[y] {
+			r, g, b, _ := code.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			bitmap[y][x] = r+g+b < 3*0x7fff
+		}
+	}
+	return bitmap
+}
@@ -0,0 +1,159 @@
+package bertymessenger
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// UnmarshalLinksParallel parses uris with a pool of workers goroutines.
+//
+// The returned slices are aligned with uris: links[i] and errs[i] are the results of UnmarshalLink(uris[i]).
+// If workers is lower or equal to 0, runtime.NumCPU() workers are used.
+func UnmarshalLinksParallel(uris []string, workers int) ([]*BertyLink, []error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(uris) {
+		workers = len(uris)
+	}
+
+	links := make([]*BertyLink, len(uris))
+	errs := make([]error, len(uris))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				links[idx], errs[idx] = UnmarshalLink(uris[idx])
+			}
+		}()
+	}
+	for idx := range uris {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+
+	return links, errs
+}
+
+// linkCandidatePattern returns the pattern of the substrings of a text that look like a web link, of any host,
+// or an internal link of one of schemes.
+func linkCandidatePattern(schemes []string) *regexp.Regexp {
+	quoted := make([]string, len(schemes))
+	for i, scheme := range schemes {
+		quoted[i] = regexp.QuoteMeta(scheme)
+	}
+	return regexp.MustCompile(`(?i)(?:\bhttps://[^\s/?#@]+/id#|\b(?:` + strings.Join(quoted, "|") + `):)\S+`)
+}
+
+// linkCandidateTrailingChars are the chars trimmed from the end of a link candidate, i.e., the punctuation of
+// the surrounding sentence. None of them can end a generated link: they are neither in the alphabets of the
+// blobs nor left unescaped in the human-readable part.
+const linkCandidateTrailingChars = `),;!?"'>]`
+
+// UnmarshalLinks extracts every substring of text that looks like a web or an internal link, i.e., from a pasted
+// backup note, and parses it with UnmarshalLink.
+//
+// The internal links are the ones of the schemes accepted by opts (see WithInternalSchemes), which are passed
+// to UnmarshalLink. The identical links are only parsed once, the returned slices follow the order of their
+// first occurrence, and are aligned: links[i] and errs[i] are the results of the i-th extracted link.
+func UnmarshalLinks(text string, opts ...UnmarshalOption) ([]*BertyLink, []error) {
+	options, err := newUnmarshalOptions(opts)
+	if err != nil {
+		// the default schemes are used to extract the links, UnmarshalLink reports the error for each of them
+		options = UnmarshalOptions{}
+	}
+
+	links := []*BertyLink{}
+	errs := []error{}
+	seen := map[string]bool{}
+	for _, candidate := range linkCandidatePattern(options.internalSchemes()).FindAllString(text, -1) {
+		uri := strings.TrimRight(candidate, linkCandidateTrailingChars)
+		if seen[uri] {
+			continue
+		}
+		seen[uri] = true
+
+		link, err := UnmarshalLink(uri, opts...)
+		links = append(links, link)
+		errs = append(errs, err)
+	}
+	return links, errs
+}
+
+// UnmarshalLinkJSON parses a JSON array of links, i.e., a bundled config of default contacts and groups.
+//
+// Each string is parsed with UnmarshalLink, if one of them can't be parsed, the returned error contains its index,
+// and has the code of the UnmarshalLink error.
+func UnmarshalLinkJSON(data []byte) ([]*BertyLink, error) {
+	var uris []string
+	if err := json.Unmarshal(data, &uris); err != nil {
+		return nil, errcode.ErrDeserialization.Wrap(err)
+	}
+
+	links := make([]*BertyLink, len(uris))
+	for i, uri := range uris {
+		link, err := UnmarshalLink(uri)
+		if err != nil {
+			return nil, linkErrorCode(err).Wrap(fmt.Errorf("link %d: %w", i, err))
+		}
+		links[i] = link
+	}
+	return links, nil
+}
+
+// DedupeLinks parses uris and returns the unique links, in the order of their first occurrence.
+//
+// The links with the same CanonicalKey (i.e., the web and the internal forms of the same contact) are collapsed,
+// keeping the one with the richest metadata: the one with a display name, then the one with the most metadata entries.
+// If an uri can't be parsed, the returned error contains its index, and has the code of the UnmarshalLink error.
+func DedupeLinks(uris []string) ([]*BertyLink, error) {
+	links := []*BertyLink{}
+	indexes := map[string]int{}
+	for i, uri := range uris {
+		link, err := UnmarshalLink(uri)
+		if err != nil {
+			return nil, linkErrorCode(err).Wrap(fmt.Errorf("link %d: %w", i, err))
+		}
+		key, err := link.CanonicalKey()
+		if err != nil {
+			return nil, linkErrorCode(err).Wrap(fmt.Errorf("link %d: %w", i, err))
+		}
+
+		if idx, found := indexes[key]; found {
+			if link.hasRicherMetadataThan(links[idx]) {
+				links[idx] = link
+			}
+			continue
+		}
+		indexes[key] = len(links)
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+func (link *BertyLink) hasRicherMetadataThan(other *BertyLink) bool {
+	displayName := func(link *BertyLink) string {
+		switch link.GetKind() {
+		case BertyLink_ContactInviteV1Kind:
+			return link.GetBertyID().GetDisplayName()
+		case BertyLink_GroupV1Kind:
+			return link.GetBertyGroup().GetDisplayName()
+		}
+		return ""
+	}
+	if hasName, otherHasName := displayName(link) != "", displayName(other) != ""; hasName != otherHasName {
+		return hasName
+	}
+	return len(link.Metadata) > len(other.Metadata)
+}
@@ -0,0 +1,219 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func testLinkBatch(t testing.TB, n int) []string {
+	uris := make([]string, n)
+	for i := range uris {
+		link := testContactLink()
+		link.BertyID.DisplayName = fmt.Sprintf("Alice %d", i)
+		internal, web, err := link.Marshal()
+		require.NoError(t, err)
+		switch i % 3 {
+		case 0:
+			uris[i] = internal
+		case 1:
+			uris[i] = web
+		case 2:
+			uris[i] = "invalid"
+		}
+	}
+	return uris
+}
+
+func TestUnmarshalLinksParallel(t *testing.T) {
+	uris := testLinkBatch(t, 100)
+
+	for _, workers := range []int{-1, 0, 1, 4, 1000} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			links, errs := bertymessenger.UnmarshalLinksParallel(uris, workers)
+			require.Len(t, links, len(uris))
+			require.Len(t, errs, len(uris))
+			for i, uri := range uris {
+				expectedLink, expectedErr := bertymessenger.UnmarshalLink(uri)
+				require.Equal(t, expectedLink, links[i])
+				require.Equal(t, expectedErr, errs[i])
+			}
+		})
+	}
+
+	links, errs := bertymessenger.UnmarshalLinksParallel(nil, 0)
+	require.Empty(t, links)
+	require.Empty(t, errs)
+}
+
+func TestDedupeLinks(t *testing.T) {
+	contact, group := testContactLink(), testGroupLink()
+	contactInternal, contactWeb, err := contact.Marshal()
+	require.NoError(t, err)
+	groupInternal, _, err := group.Marshal()
+	require.NoError(t, err)
+
+	// the same contact, shared without its name
+	anonymous := testContactLink()
+	anonymous.BertyID.DisplayName = ""
+	anonymousInternal, anonymousWeb, err := anonymous.Marshal()
+	require.NoError(t, err)
+
+	// another contact
+	other := testContactLink()
+	other.BertyID.AccountPK = bytes.Repeat([]byte{42}, 32)
+	otherInternal, _, err := other.Marshal()
+	require.NoError(t, err)
+
+	t.Run("web-and-internal", func(t *testing.T) {
+		for _, uris := range [][]string{
+			{anonymousWeb, contactInternal},
+			{contactInternal, anonymousWeb},
+			{anonymousInternal, contactWeb},
+			{contactWeb, contactInternal, anonymousInternal, anonymousWeb},
+		} {
+			links, err := bertymessenger.DedupeLinks(uris)
+			require.NoError(t, err)
+			require.Equal(t, []*bertymessenger.BertyLink{contact}, links)
+		}
+	})
+
+	t.Run("different-identities", func(t *testing.T) {
+		links, err := bertymessenger.DedupeLinks([]string{otherInternal, contactWeb, groupInternal, anonymousInternal, otherInternal})
+		require.NoError(t, err)
+		require.Equal(t, []*bertymessenger.BertyLink{other, contact, group}, links)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := bertymessenger.DedupeLinks([]string{contactWeb, "invalid"})
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		require.Contains(t, err.Error(), "link 1")
+
+		// the specific codes are kept
+		expired := testContactLink()
+		expired.ExpiresAtUnix = time.Now().Add(-time.Hour).Unix()
+		expiredInternal, _, err := expired.Marshal()
+		require.NoError(t, err)
+		_, err = bertymessenger.DedupeLinks([]string{contactWeb, expiredInternal})
+		require.True(t, errcode.Is(err, errcode.ErrLinkExpired))
+		require.Contains(t, err.Error(), "link 1")
+	})
+
+	links, err := bertymessenger.DedupeLinks(nil)
+	require.NoError(t, err)
+	require.Empty(t, links)
+}
+
+func BenchmarkUnmarshalLinks(b *testing.B) {
+	uris := testLinkBatch(b, 10000)
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, uri := range uris {
+				_, _ = bertymessenger.UnmarshalLink(uri)
+			}
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = bertymessenger.UnmarshalLinksParallel(uris, 0)
+		}
+	})
+}
+
+func TestUnmarshalLinkJSON(t *testing.T) {
+	contactInternal, _, err := testContactLink().Marshal()
+	require.NoError(t, err)
+	_, groupWeb, err := testGroupLink().Marshal()
+	require.NoError(t, err)
+
+	data, err := json.Marshal([]string{contactInternal, groupWeb})
+	require.NoError(t, err)
+	links, err := bertymessenger.UnmarshalLinkJSON(data)
+	require.NoError(t, err)
+	require.Equal(t, []*bertymessenger.BertyLink{testContactLink(), testGroupLink()}, links)
+
+	data, err = json.Marshal([]string{contactInternal, groupWeb, "invalid"})
+	require.NoError(t, err)
+	_, err = bertymessenger.UnmarshalLinkJSON(data)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	require.Contains(t, err.Error(), "link 2")
+
+	// the specific codes are kept
+	expired := testGroupLink()
+	expired.ExpiresAtUnix = time.Now().Add(-time.Hour).Unix()
+	_, expiredWeb, err := expired.Marshal()
+	require.NoError(t, err)
+	data, err = json.Marshal([]string{contactInternal, expiredWeb})
+	require.NoError(t, err)
+	_, err = bertymessenger.UnmarshalLinkJSON(data)
+	require.True(t, errcode.Is(err, errcode.ErrLinkExpired))
+	require.Contains(t, err.Error(), "link 1")
+
+	for _, data := range []string{"", "{}", `["a", 42]`} {
+		_, err = bertymessenger.UnmarshalLinkJSON([]byte(data))
+		require.True(t, errcode.Is(err, errcode.ErrDeserialization), data)
+	}
+
+	links, err = bertymessenger.UnmarshalLinkJSON([]byte("[]"))
+	require.NoError(t, err)
+	require.Empty(t, links)
+}
+
+func TestUnmarshalLinks(t *testing.T) {
+	contact, group := testContactLink(), testGroupLink()
+	contactInternal, contactWeb, err := contact.Marshal()
+	require.NoError(t, err)
+	groupInternal, _, err := group.Marshal()
+	require.NoError(t, err)
+
+	text := fmt.Sprintf(`My contacts backup:
+- Alice: %s
+- The Group (%s), again %s
+	%s
+an invalid one: berty://PB/INV@LID!
+not links: berty: the app, https://berty.tech/blog`, contactWeb, groupInternal, contactWeb, strings.ToLower(contactInternal))
+
+	links, errs := bertymessenger.UnmarshalLinks(text)
+	require.Len(t, links, 4)
+	require.Len(t, errs, 4)
+	for i, expected := range []*bertymessenger.BertyLink{contact, group, contact} {
+		require.NoError(t, errs[i])
+		require.Equal(t, expected, links[i])
+	}
+	require.Nil(t, links[3])
+	require.True(t, errcode.Is(errs[3], errcode.ErrInvalidInput))
+
+	links, errs = bertymessenger.UnmarshalLinks("nothing to see here")
+	require.Empty(t, links)
+	require.Empty(t, errs)
+
+	// the internal links of the other accepted schemes
+	myChatInternal := "MYCHAT://" + strings.TrimPrefix(groupInternal, "BERTY://")
+	text = fmt.Sprintf("ours: %s, theirs: %s", myChatInternal, contactInternal)
+	links, errs = bertymessenger.UnmarshalLinks(text)
+	require.Len(t, links, 1)
+	require.NoError(t, errs[0])
+	require.Equal(t, contact, links[0])
+
+	links, errs = bertymessenger.UnmarshalLinks(text, bertymessenger.WithInternalSchemes("MYCHAT", "BERTY"))
+	require.Len(t, links, 2)
+	for i, expected := range []*bertymessenger.BertyLink{group, contact} {
+		require.NoError(t, errs[i])
+		require.Equal(t, expected, links[i])
+	}
+
+	// the invalid options are reported for each link
+	links, errs = bertymessenger.UnmarshalLinks(text, bertymessenger.WithInternalSchemes())
+	require.Len(t, links, 1)
+	require.True(t, errcode.Is(errs[0], errcode.ErrMissingInput))
+}
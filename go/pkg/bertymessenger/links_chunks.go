@@ -0,0 +1,127 @@
+package bertymessenger
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// MaxLinkChunks is the maximum number of chunks of a chunked internal link (see MarshalChunked),
+// more frames would take too long to be scanned.
+const MaxLinkChunks = 99
+
+// chunkedLinkInternalMarker is the marker of the chunks of an internal link (see MarshalChunked),
+// in the format: "BERTY://PBC/{index}/{total}/{checksum}/{data}", the index starting at 1.
+const chunkedLinkInternalMarker = "PBC"
+
+// MarshalChunked splits the internal link into chunks of at most maxChars chars, prefix included,
+// i.e., for the apps cycling through several QR codes when a large group link doesn't fit in a scannable one.
+//
+// Each chunk contains its index, the number of chunks, and a checksum of the whole payload, so UnmarshalChunks
+// can reassemble them in any order and reject an incomplete or mixed set of chunks.
+// It returns ErrLinkTooLarge if the link needs more than MaxLinkChunks chunks.
+func (link *BertyLink) MarshalChunked(maxChars int) ([]string, error) {
+	internal, err := link.ToInternal()
+	if err != nil {
+		return nil, err
+	}
+	payload := strings.TrimPrefix(internal, CurrentLinkInternalPrefix()+internalLinkMarker+"/")
+	checksum := encodeScanChecksum(crc32.ChecksumIEEE([]byte(payload)))
+
+	// the length of the prefix depends on the number of digits of the total, which depends on the length of the prefix
+	var chunkLen, total int
+	for digits := 1; ; digits++ {
+		prefixLen := len(CurrentLinkInternalPrefix()+chunkedLinkInternalMarker+"/") + 2*(digits+1) + len(checksum) + 1
+		chunkLen = maxChars - prefixLen
+		if chunkLen <= 0 {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("a chunk should have more than %d chars, got %d", prefixLen, maxChars))
+		}
+		total = (len(payload) + chunkLen - 1) / chunkLen
+		if total > MaxLinkChunks {
+			return nil, errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("the link needs %d chunks of %d chars, the limit is %d chunks", total, maxChars, MaxLinkChunks))
+		}
+		if len(strconv.Itoa(total)) <= digits {
+			break
+		}
+	}
+
+	chunks := make([]string, total)
+	for i := range chunks {
+		data := payload[i*chunkLen:]
+		if len(data) > chunkLen {
+			data = data[:chunkLen]
+		}
+		chunks[i] = fmt.Sprintf("%s%s/%d/%d/%s/%s", CurrentLinkInternalPrefix(), chunkedLinkInternalMarker, i+1, total, checksum, data)
+	}
+	return chunks, nil
+}
+
+// UnmarshalChunks reassembles the chunks generated by MarshalChunked, in any order, and decodes the link
+// like UnmarshalLink does.
+//
+// The same chunk can be given several times, i.e., when the frames are scanned in a loop. It returns ErrMissingInput
+// if some chunks are missing, and ErrInvalidInput if the chunks don't belong to the same link.
+func UnmarshalChunks(chunks []string, opts ...UnmarshalOption) (*BertyLink, error) {
+	if len(chunks) == 0 {
+		return nil, errcode.ErrMissingInput
+	}
+	options, err := newUnmarshalOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	schemes := options.internalSchemes()
+
+	var (
+		total    int
+		checksum string
+		data     = map[int]string{}
+	)
+	for _, chunk := range chunks {
+		right, ok := internalLinkPayloadOf(chunk, schemes)
+		if !ok {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("not an internal link"))
+		}
+		parts := strings.SplitN(right, "/", 5)
+		if len(parts) != 5 || parts[0] != chunkedLinkInternalMarker {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("not a link chunk"))
+		}
+		index, indexErr := strconv.Atoi(parts[1])
+		chunkTotal, totalErr := strconv.Atoi(parts[2])
+		if indexErr != nil || totalErr != nil || chunkTotal < 1 || chunkTotal > MaxLinkChunks || index < 1 || index > chunkTotal {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid chunk index: %s/%s", parts[1], parts[2]))
+		}
+
+		if checksum == "" {
+			total, checksum = chunkTotal, parts[3]
+		}
+		if chunkTotal != total || parts[3] != checksum {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("the chunks belong to different links"))
+		}
+		if previous, ok := data[index]; ok && previous != parts[4] {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("conflicting chunks for index %d", index))
+		}
+		data[index] = parts[4]
+	}
+
+	var (
+		payload strings.Builder
+		missing []string
+	)
+	for index := 1; index <= total; index++ {
+		if _, ok := data[index]; !ok {
+			missing = append(missing, strconv.Itoa(index))
+		}
+		payload.WriteString(data[index])
+	}
+	if len(missing) > 0 {
+		return nil, errcode.ErrMissingInput.Wrap(fmt.Errorf("missing chunks %s of %d", strings.Join(missing, ", "), total))
+	}
+	if encodeScanChecksum(crc32.ChecksumIEEE([]byte(payload.String()))) != checksum {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("checksum mismatch"))
+	}
+
+	return UnmarshalInternalLink(schemes[0]+"://"+internalLinkMarker+"/"+payload.String(), opts...)
+}
@@ -0,0 +1,84 @@
+package bertymessenger_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestMarshalChunked(t *testing.T) {
+	large := testGroupLink()
+	large.BertyGroup.DisplayName = strings.Repeat("The Group ", 20)
+	internal, err := large.ToInternal()
+	require.NoError(t, err)
+
+	const maxChars = 80
+	chunks, err := large.MarshalChunked(maxChars)
+	require.NoError(t, err)
+	require.Greater(t, len(chunks), 2)
+	for i, chunk := range chunks {
+		require.LessOrEqual(t, len(chunk), maxChars, chunk)
+		require.True(t, strings.HasPrefix(chunk, "BERTY://PBC/"+strconv.Itoa(i+1)+"/"+strconv.Itoa(len(chunks))+"/"), chunk)
+	}
+
+	// in any order, with repeated chunks
+	shuffled := []string{chunks[len(chunks)-1]}
+	shuffled = append(shuffled, chunks...)
+	shuffled[1], shuffled[2] = shuffled[2], shuffled[1]
+	parsed, err := bertymessenger.UnmarshalChunks(shuffled)
+	require.NoError(t, err)
+	require.Equal(t, large, parsed)
+
+	// a link that fits in a single chunk
+	single, err := large.MarshalChunked(len(internal) + 32)
+	require.NoError(t, err)
+	require.Len(t, single, 1)
+	parsed, err = bertymessenger.UnmarshalChunks(single)
+	require.NoError(t, err)
+	require.Equal(t, large, parsed)
+
+	// incomplete sets
+	_, err = bertymessenger.UnmarshalChunks(chunks[1:])
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+	_, err = bertymessenger.UnmarshalChunks(nil)
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+
+	// mixed sets
+	other := testGroupLink()
+	other.BertyGroup.DisplayName = strings.Repeat("Another Group ", 15)
+	otherChunks, err := other.MarshalChunked(maxChars)
+	require.NoError(t, err)
+	_, err = bertymessenger.UnmarshalChunks(append([]string{otherChunks[0]}, chunks[1:]...))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	// altered chunks
+	altered := append([]string{}, chunks...)
+	last := altered[1][len(altered[1])-1:]
+	replacement := "A"
+	if last == "A" {
+		replacement = "B"
+	}
+	altered[1] = altered[1][:len(altered[1])-1] + replacement
+	_, err = bertymessenger.UnmarshalChunks(altered)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = bertymessenger.UnmarshalChunks(append(chunks, altered[1]))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	// a chunk is not a link
+	_, err = bertymessenger.UnmarshalLink(chunks[0])
+	require.Error(t, err)
+	_, err = bertymessenger.UnmarshalChunks([]string{internal})
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	_, err = large.MarshalChunked(20)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = large.MarshalChunked(25)
+	require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+	_, err = (&bertymessenger.BertyLink{}).MarshalChunked(maxChars)
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+}
@@ -0,0 +1,61 @@
+package bertymessenger
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// CompressedPayloadThreshold is the size in bytes of the binary payload above which WithCompressedPayload
+// deflates it, the smaller payloads (i.e., the contact links) don't benefit from the compression.
+const CompressedPayloadThreshold = 128
+
+const (
+	// compressedLinkInternalMarker is the marker of the internal links with a deflated payload
+	// (see WithCompressedPayload), i.e., "BERTY://PBZ/{payload}".
+	compressedLinkInternalMarker = "PBZ"
+
+	// maxInflatedLinkPayloadSize is the maximum size of an inflated payload, so a crafted link can't make
+	// UnmarshalLink allocate an unbounded amount of memory.
+	maxInflatedLinkPayloadSize = 64 * 1024
+)
+
+// deflateLinkPayload returns the raw deflate (RFC 1951) compression of bin.
+func deflateLinkPayload(bin []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	if _, err := w.Write(bin); err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeCompressedLinkPayload decodes a payload generated with WithCompressedPayload, a deflated proto BertyLink
+// encoded with qrBaseEncoder.
+func decodeCompressedLinkPayload(payload string) ([]byte, error) {
+	compressed, err := qrBaseEncoder.Decode(payload)
+	if err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+
+	r := flate.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	qrBin, err := ioutil.ReadAll(io.LimitReader(r, maxInflatedLinkPayloadSize+1))
+	if err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+	if len(qrBin) > maxInflatedLinkPayloadSize {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("inflated payload larger than %d bytes", maxInflatedLinkPayloadSize))
+	}
+	return qrBin, nil
+}
@@ -0,0 +1,86 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"compress/flate"
+	crand "crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/eknkc/basex"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestMarshalLinkWithCompressedPayload(t *testing.T) {
+	// the small payloads stay uncompressed
+	contact := testContactLink()
+	internal, _, err := contact.Marshal(bertymessenger.WithCompressedPayload())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(internal, "BERTY://PB1/"), internal)
+	uncompressed, _, err := contact.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, uncompressed, internal)
+
+	// so do the large ones that don't compress
+	random := testGroupLink()
+	for _, key := range [][]byte{random.BertyGroup.Group.PublicKey, random.BertyGroup.Group.Secret, random.BertyGroup.Group.SecretSig, random.BertyGroup.Group.SignPub} {
+		_, err := crand.Read(key)
+		require.NoError(t, err)
+	}
+	internal, _, err = random.Marshal(bertymessenger.WithCompressedPayload())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(internal, "BERTY://PB1/"), internal)
+
+	// the large ones that compress are deflated, and round-trip
+	large := testGroupLink()
+	large.BertyGroup.DisplayName = strings.Repeat("The Group ", 20)
+	large.Metadata = map[string]string{"description": strings.Repeat("a group about groups, ", 8)}
+	uncompressed, _, err = large.Marshal()
+	require.NoError(t, err)
+	internal, _, err = large.Marshal(bertymessenger.WithCompressedPayload(), bertymessenger.WithGroupedPayload(8))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(internal, "BERTY://PBZ/"), internal)
+	require.Less(t, len(strings.ReplaceAll(internal, "+", "")), len(uncompressed))
+
+	parsed, err := bertymessenger.UnmarshalLink(internal)
+	require.NoError(t, err)
+	require.Equal(t, large, parsed)
+	kind, err := bertymessenger.LinkKindOf(internal)
+	require.NoError(t, err)
+	require.Equal(t, bertymessenger.BertyLink_GroupV1Kind, kind)
+
+	// the web link is not affected
+	_, web, err := large.Marshal(bertymessenger.WithCompressedPayload())
+	require.NoError(t, err)
+	_, expectedWeb, err := large.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, expectedWeb, web)
+
+	_, _, err = large.Marshal(bertymessenger.WithCompressedPayload(), bertymessenger.WithScanChecksum())
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestUnmarshalLinkCompressedPayloadLimit(t *testing.T) {
+	encoder, err := basex.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$*-.:/")
+	require.NoError(t, err)
+	deflate := func(bin []byte) string {
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.BestCompression)
+		require.NoError(t, err)
+		_, err = w.Write(bin)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return encoder.Encode(buf.Bytes())
+	}
+
+	// a payload inflating to a huge size is rejected
+	_, err = bertymessenger.UnmarshalLink("BERTY://PBZ/" + deflate(make([]byte, 1<<20)))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	// so is a payload that is not deflated, "H" is a block with the reserved type
+	_, err = bertymessenger.UnmarshalLink("BERTY://PBZ/H")
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
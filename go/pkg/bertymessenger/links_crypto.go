@@ -0,0 +1,276 @@
+package bertymessenger
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+
+	"berty.tech/berty/v2/go/internal/cryptoutil"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// MinLinkPINLength is the minimal number of digits of the PIN of a PIN-protected link.
+//
+// A PIN only has 10^n combinations, and nothing prevents someone with the link from trying them all offline,
+// the KDF only makes each attempt costly (about a second on a phone).
+// A 6-digit PIN can be brute-forced in a few days with a single CPU, and a lot faster with dedicated hardware,
+// so we recommend using at least 8 digits, and to stop sharing a PIN-protected link once it has been used.
+const MinLinkPINLength = 6
+
+const (
+	pinLinkInternalMarker = "PIN"
+	pinLinkWebKind        = "pin"
+
+	// pinLinkVersion is the first byte of a PIN-protected blob, it identifies the KDF and cipher parameters.
+	pinLinkVersion byte = 1
+
+	passphraseLinkInternalMarker = "ENC"
+	passphraseLinkWebKind        = "enc"
+
+	// passphraseLinkVersion is the first byte of the blobs generated by MarshalEncrypted.
+	passphraseLinkVersion byte = 1
+
+	protectedLinkSaltSize = 16
+)
+
+// linkKDFParams are the scrypt parameters of a protected link version.
+type linkKDFParams struct{ N, r, p int }
+
+// pinLinkKDFParams are the scrypt parameters of pinLinkVersion, deliberately higher than
+// the interactive login recommendations (N=2^15) because of the low entropy of PINs.
+var pinLinkKDFParams = linkKDFParams{N: 1 << 16, r: 8, p: 1}
+
+// passphraseLinkKDFParams are the scrypt parameters of each version of the passphrase-protected links,
+// the previous versions are kept so the links generated by older apps stay decodable.
+var passphraseLinkKDFParams = map[byte]linkKDFParams{
+	1: {N: 1 << 15, r: 8, p: 1},
+}
+
+// MarshalPINProtected returns shareable web and internal URLs, encrypted with a key derived from a numeric PIN.
+//
+// The PIN is meant to be transmitted through another channel (e.g., told verbally), it must contain at least
+// MinLinkPINLength digits. See MinLinkPINLength for the brute-force risks.
+//
+// The encrypted link is the one generated by Marshal with opts, without the fields they remove (i.e., with
+// WithoutGroupSecret), and the web link is generated with the web prefix and encoding of opts (see WithWebPrefix and
+// WithWebEncoding); the options of the internal payload format (i.e., WithScanChecksum) and WithAnalyticsTag don't
+// apply to the encrypted blob, and are rejected with ErrInvalidInput.
+// The link can only be decoded with UnmarshalPINProtected, UnmarshalLink returns ErrLinkEncrypted.
+func (link *BertyLink) MarshalPINProtected(pin string, opts ...MarshalOption) (internal string, web string, err error) {
+	if err := checkLinkPIN(pin); err != nil {
+		return "", "", err
+	}
+	return link.marshalProtected(pin, pinLinkVersion, pinLinkKDFParams, pinLinkInternalMarker, pinLinkWebKind, opts)
+}
+
+// UnmarshalPINProtected decodes a link generated by BertyLink.MarshalPINProtected.
+//
+// The decrypted link is checked like the ones of UnmarshalLink, with opts.
+// It returns ErrLinkWrongPassphrase if the PIN is not the one used to generate the link.
+// Rate-limiting the attempts is the responsibility of the app.
+func UnmarshalPINProtected(uri string, pin string, opts ...UnmarshalOption) (*BertyLink, error) {
+	return unmarshalLinkWith(uri, opts, func(uri string, options UnmarshalOptions) (*BertyLink, error) {
+		blob, err := protectedLinkBlob(uri, pinLinkInternalMarker, pinLinkWebKind, options)
+		if err != nil {
+			return nil, err
+		}
+		if version := blob[0]; version != pinLinkVersion {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported PIN-protected link version: %d", version))
+		}
+		return openProtectedLink(blob, pin, pinLinkKDFParams)
+	})
+}
+
+// MarshalEncrypted returns shareable web and internal URLs, encrypted with a key derived from a passphrase,
+// i.e., to share a link on a semi-public channel without exposing its content to everyone who sees it.
+//
+// The passphrase is meant to be transmitted through another channel, the strength of the encryption
+// only depends on its entropy.
+//
+// The options are the ones of MarshalPINProtected.
+// The link can only be decoded with UnmarshalLinkEncrypted, UnmarshalLink returns ErrLinkEncrypted.
+func (link *BertyLink) MarshalEncrypted(passphrase string, opts ...MarshalOption) (internal string, web string, err error) {
+	if passphrase == "" {
+		return "", "", errcode.ErrMissingInput.Wrap(fmt.Errorf("missing passphrase"))
+	}
+	params := passphraseLinkKDFParams[passphraseLinkVersion]
+	return link.marshalProtected(passphrase, passphraseLinkVersion, params, passphraseLinkInternalMarker, passphraseLinkWebKind, opts)
+}
+
+// UnmarshalLinkEncrypted decodes a link generated by BertyLink.MarshalEncrypted.
+//
+// The decrypted link is checked like the ones of UnmarshalLink, with opts.
+// It returns ErrLinkWrongPassphrase if the passphrase is not the one used to generate the link.
+func UnmarshalLinkEncrypted(uri string, passphrase string, opts ...UnmarshalOption) (*BertyLink, error) {
+	return unmarshalLinkWith(uri, opts, func(uri string, options UnmarshalOptions) (*BertyLink, error) {
+		blob, err := protectedLinkBlob(uri, passphraseLinkInternalMarker, passphraseLinkWebKind, options)
+		if err != nil {
+			return nil, err
+		}
+		params, ok := passphraseLinkKDFParams[blob[0]]
+		if !ok {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported encrypted link version: %d", blob[0]))
+		}
+		return openProtectedLink(blob, passphrase, params)
+	})
+}
+
+// marshalProtected returns the URLs of the link encrypted with a key derived from secret, with the given version,
+// KDF parameters, internal marker and web kind.
+func (link *BertyLink) marshalProtected(secret string, version byte, params linkKDFParams, internalMarker string, webKind string, opts []MarshalOption) (internal string, web string, err error) {
+	options, err := link.marshalOptions(opts)
+	if err != nil {
+		return "", "", err
+	}
+	if options.AnalyticsTag != "" || options.GroupedPayloadLen > 0 || options.ScanChecksum || options.CompressPayload {
+		return "", "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("the analytics tag and the internal payload options don't apply to a protected link"))
+	}
+
+	// the encrypted link is the one of Marshal, the stripped fields must not end up in the ciphertext
+	blob, err := link.strippedCopy(options).sealProtected(secret, version, params)
+	if err != nil {
+		return "", "", err
+	}
+
+	internal, web = protectedLinkURLs(blob, internalMarker, webKind, options)
+	if len(web) > options.URLLengthLimit {
+		return "", "", errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("web link is %d chars long, the limit is %d chars, use the internal link instead", len(web), options.URLLengthLimit))
+	}
+	return internal, web, nil
+}
+
+// sealProtected encrypts the link with a key derived from secret, in the format:
+// version (1 byte) | salt | nonce | secretbox.
+//
+// The link must have been checked by marshalOptions.
+func (link *BertyLink) sealProtected(secret string, version byte, params linkKDFParams) ([]byte, error) {
+	bin, err := proto.Marshal(link)
+	if err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+
+	salt := make([]byte, protectedLinkSaltSize)
+	if _, err := crand.Read(salt); err != nil {
+		return nil, errcode.ErrCryptoRandomGeneration.Wrap(err)
+	}
+	key, err := protectedLinkKey(secret, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := cryptoutil.GenerateNonce()
+	if err != nil {
+		return nil, errcode.ErrCryptoNonceGeneration.Wrap(err)
+	}
+
+	blob := make([]byte, 0, 1+protectedLinkSaltSize+cryptoutil.NonceSize+secretbox.Overhead+len(bin))
+	blob = append(blob, version)
+	blob = append(blob, salt...)
+	blob = append(blob, nonce[:]...)
+	blob = secretbox.Seal(blob, bin, nonce, key)
+	return blob, nil
+}
+
+// protectedLinkURLs returns the internal and web URLs of a protected blob, the web one with the prefix and encoding
+// of options.
+func protectedLinkURLs(blob []byte, internalMarker string, webKind string, options MarshalOptions) (internal string, web string) {
+	internal = CurrentLinkInternalPrefix() + internalMarker + "/" + qrBaseEncoder.Encode(blob)
+	web = options.WebPrefix + webKind + "/" + encodeWebBlob(blob, options.WebEncoding)
+	return internal, web
+}
+
+// protectedLinkBlob decodes the blob of a protected link, with the given internal marker and web kind, the internal
+// links being the ones of the schemes accepted by options.
+//
+// The returned blob is long enough to contain the version, the salt, the nonce and the secretbox overhead.
+func protectedLinkBlob(uri string, internalMarker string, webKind string, options UnmarshalOptions) ([]byte, error) {
+	var (
+		blob []byte
+		err  error
+	)
+	if start, ok := webLinkFragmentStart(uri); ok && strings.HasPrefix(strings.ToLower(uri[start:]), webKind+"/") {
+		blob, err = decodeWebBlob(uri[start+len(webKind+"/"):])
+	} else {
+		right, ok := internalLinkPayloadOf(uri, options.internalSchemes())
+		if !ok || !strings.HasPrefix(right, internalMarker+"/") {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("not a %q protected link", webKind))
+		}
+		blob, err = qrBaseEncoder.Decode(right[len(internalMarker+"/"):])
+	}
+	if err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+
+	if len(blob) < 1+protectedLinkSaltSize+cryptoutil.NonceSize+secretbox.Overhead {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("protected blob too small: %d bytes", len(blob)))
+	}
+	return blob, nil
+}
+
+// openProtectedLink decrypts a blob generated by sealProtected, and returns the link if it is valid.
+//
+// The other checks of the decoded links, i.e., of their validity period and signature, are done by unmarshalLinkWith.
+func openProtectedLink(blob []byte, secret string, params linkKDFParams) (*BertyLink, error) {
+	salt := blob[1 : 1+protectedLinkSaltSize]
+	nonce, err := cryptoutil.NonceSliceToArray(blob[1+protectedLinkSaltSize : 1+protectedLinkSaltSize+cryptoutil.NonceSize])
+	if err != nil {
+		return nil, err
+	}
+	key, err := protectedLinkKey(secret, salt, params)
+	if err != nil {
+		return nil, err
+	}
+
+	bin, ok := secretbox.Open(nil, blob[1+protectedLinkSaltSize+cryptoutil.NonceSize:], nonce, key)
+	if !ok {
+		return nil, errcode.ErrLinkWrongPassphrase.Wrap(errcode.ErrCryptoDecrypt)
+	}
+
+	var link BertyLink
+	if err := proto.Unmarshal(bin, &link); err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+	// the groups of any type are accepted, as in the internal links (see WithAllowNonMultiMember)
+	if err := link.isValid(true); err != nil {
+		if errcode.Is(err, errcode.ErrMissingInput) {
+			return nil, errcode.ErrInvalidInput.Wrap(err)
+		}
+		return nil, err
+	}
+	return &link, nil
+}
+
+// protectedLinkError returns the ErrLinkEncrypted error of a protected link web kind or internal marker,
+// and nil if token is not the one of a protected link.
+func protectedLinkError(token string) error {
+	switch token {
+	case pinLinkWebKind, pinLinkInternalMarker:
+		return errcode.ErrLinkEncrypted.Wrap(fmt.Errorf("PIN-protected link, use UnmarshalPINProtected"))
+	case passphraseLinkWebKind, passphraseLinkInternalMarker:
+		return errcode.ErrLinkEncrypted.Wrap(fmt.Errorf("encrypted link, use UnmarshalLinkEncrypted"))
+	}
+	return nil
+}
+
+func checkLinkPIN(pin string) error {
+	if len(pin) < MinLinkPINLength {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("PIN should have at least %d digits", MinLinkPINLength))
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("PIN should only contain digits"))
+		}
+	}
+	return nil
+}
+
+func protectedLinkKey(secret string, salt []byte, params linkKDFParams) (*[cryptoutil.KeySize]byte, error) {
+	derived, err := scrypt.Key([]byte(secret), salt, params.N, params.r, params.p, cryptoutil.KeySize)
+	if err != nil {
+		return nil, errcode.ErrCryptoKeyDerivation.Wrap(err)
+	}
+	return cryptoutil.KeySliceToArray(derived)
+}
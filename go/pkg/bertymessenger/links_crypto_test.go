@@ -0,0 +1,245 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkPINProtected(t *testing.T) {
+	const pin = "12345678"
+
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			internal, web, err := link.MarshalPINProtected(pin)
+			require.NoError(t, err)
+
+			for _, uri := range []string{internal, web} {
+				// correct PIN
+				parsed, err := bertymessenger.UnmarshalPINProtected(uri, pin)
+				require.NoError(t, err)
+				require.Equal(t, link, parsed)
+
+				// wrong PIN
+				parsed, err = bertymessenger.UnmarshalPINProtected(uri, "87654321")
+				require.True(t, errcode.Is(err, errcode.ErrLinkWrongPassphrase))
+				require.Nil(t, parsed)
+
+				// the regular parsing functions don't leak the content of the link
+				_, err = bertymessenger.UnmarshalLink(uri)
+				require.True(t, errcode.Is(err, errcode.ErrLinkEncrypted))
+				_, err = bertymessenger.LinkKindOf(uri)
+				require.True(t, errcode.Is(err, errcode.ErrLinkEncrypted))
+			}
+		})
+	}
+
+	t.Run("salted", func(t *testing.T) {
+		first, _, err := testContactLink().MarshalPINProtected(pin)
+		require.NoError(t, err)
+		second, _, err := testContactLink().MarshalPINProtected(pin)
+		require.NoError(t, err)
+		require.NotEqual(t, first, second)
+	})
+
+	t.Run("web-prefix", func(t *testing.T) {
+		const prefix = "https://chat.example.org/id#"
+		_, web, err := testContactLink().MarshalPINProtected(pin, bertymessenger.WithWebPrefix(prefix), bertymessenger.WithWebEncoding(bertymessenger.WebEncodingBase64URL))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(web, prefix+"pin/0"), web)
+		parsed, err := bertymessenger.UnmarshalPINProtected(web, pin)
+		require.NoError(t, err)
+		require.Equal(t, testContactLink(), parsed)
+	})
+
+	t.Run("strip-options", func(t *testing.T) {
+		internal, web, err := testGroupLink().MarshalPINProtected(pin, bertymessenger.WithoutGroupSecret(), bertymessenger.WithoutDisplayName())
+		require.NoError(t, err)
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalPINProtected(uri, pin)
+			require.NoError(t, err)
+			require.False(t, parsed.HasGroupSecret())
+			require.Empty(t, parsed.BertyGroup.Group.SecretSig)
+			require.Empty(t, parsed.BertyGroup.DisplayName)
+			require.Equal(t, testGroupLink().BertyGroup.Group.PublicKey, parsed.BertyGroup.Group.PublicKey)
+		}
+
+		// the options of the internal payload format don't apply
+		for _, opt := range []bertymessenger.MarshalOption{bertymessenger.WithScanChecksum(), bertymessenger.WithCompressedPayload(), bertymessenger.WithGroupedPayload(4)} {
+			_, _, err := testGroupLink().MarshalPINProtected(pin, opt)
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		}
+	})
+
+	t.Run("unmarshal-options", func(t *testing.T) {
+		expired := testContactLink()
+		expired.ExpiresAtUnix = time.Now().Add(-time.Hour).Unix()
+		internal, _, err := expired.MarshalPINProtected(pin)
+		require.NoError(t, err)
+		_, err = bertymessenger.UnmarshalPINProtected(internal, pin)
+		require.True(t, errcode.Is(err, errcode.ErrLinkExpired))
+		parsed, err := bertymessenger.UnmarshalPINProtected(internal, pin, bertymessenger.WithAllowExpired())
+		require.NoError(t, err)
+		require.Equal(t, expired, parsed)
+
+		// the internal links of the other accepted schemes
+		myChat := "MYCHAT://" + strings.TrimPrefix(internal, "BERTY://")
+		_, err = bertymessenger.UnmarshalPINProtected(myChat, pin, bertymessenger.WithAllowExpired())
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		parsed, err = bertymessenger.UnmarshalPINProtected(myChat, pin, bertymessenger.WithAllowExpired(), bertymessenger.WithInternalSchemes("MYCHAT"))
+		require.NoError(t, err)
+		require.Equal(t, expired, parsed)
+
+		// the size limit
+		oversized := "BERTY://PIN/" + strings.Repeat("A", bertymessenger.DefaultMaxLinkSize)
+		_, err = bertymessenger.UnmarshalPINProtected(oversized, pin)
+		require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+	})
+
+	t.Run("invalid-pin", func(t *testing.T) {
+		for _, invalid := range []string{"", "12345", "abcdefgh", "1234 5678"} {
+			_, _, err := testContactLink().MarshalPINProtected(invalid)
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput), invalid)
+		}
+	})
+
+	t.Run("not-pin-protected", func(t *testing.T) {
+		internal, web, err := testContactLink().Marshal()
+		require.NoError(t, err)
+		for _, uri := range []string{internal, web, "", "invalid"} {
+			_, err := bertymessenger.UnmarshalPINProtected(uri, pin)
+			require.Error(t, err)
+			require.False(t, errcode.Is(err, errcode.ErrLinkWrongPassphrase))
+		}
+	})
+}
+
+func TestLinkEncrypted(t *testing.T) {
+	const passphrase = "correct horse battery staple"
+
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			internal, web, err := link.MarshalEncrypted(passphrase)
+			require.NoError(t, err)
+
+			for _, uri := range []string{internal, web} {
+				// correct passphrase
+				parsed, err := bertymessenger.UnmarshalLinkEncrypted(uri, passphrase)
+				require.NoError(t, err)
+				require.Equal(t, link, parsed)
+
+				// wrong passphrase
+				parsed, err = bertymessenger.UnmarshalLinkEncrypted(uri, "incorrect horse battery staple")
+				require.True(t, errcode.Is(err, errcode.ErrLinkWrongPassphrase))
+				require.Nil(t, parsed)
+
+				// the regular parsing functions don't leak the content of the link
+				_, err = bertymessenger.UnmarshalLink(uri)
+				require.True(t, errcode.Is(err, errcode.ErrLinkEncrypted))
+				_, err = bertymessenger.LinkKindOf(uri)
+				require.True(t, errcode.Is(err, errcode.ErrLinkEncrypted))
+
+				// neither do the PIN-protected ones
+				_, err = bertymessenger.UnmarshalPINProtected(uri, passphrase)
+				require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+			}
+		})
+	}
+
+	t.Run("unchanged-plain-links", func(t *testing.T) {
+		internal, web, err := testContactLink().Marshal()
+		require.NoError(t, err)
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err)
+			require.Equal(t, testContactLink(), parsed)
+
+			_, err = bertymessenger.UnmarshalLinkEncrypted(uri, passphrase)
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		internal, _, err := testContactLink().MarshalEncrypted(passphrase)
+		require.NoError(t, err)
+		marker := bertymessenger.LinkInternalPrefix + "ENC/"
+		require.True(t, strings.HasPrefix(internal, marker))
+		tampered := internal[:len(internal)-1] + "A"
+		if tampered == internal {
+			tampered = internal[:len(internal)-1] + "B"
+		}
+		_, err = bertymessenger.UnmarshalLinkEncrypted(tampered, passphrase)
+		require.Error(t, err)
+	})
+
+	t.Run("web-prefix", func(t *testing.T) {
+		const prefix = "https://chat.example.org/id#"
+		_, web, err := testGroupLink().MarshalEncrypted(passphrase, bertymessenger.WithWebPrefix(prefix))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(web, prefix+"enc/"), web)
+		parsed, err := bertymessenger.UnmarshalLinkEncrypted(web, passphrase)
+		require.NoError(t, err)
+		require.Equal(t, testGroupLink(), parsed)
+	})
+
+	t.Run("without-group-secret", func(t *testing.T) {
+		internal, web, err := testGroupLink().MarshalEncrypted(passphrase, bertymessenger.WithoutGroupSecret())
+		require.NoError(t, err)
+		expected := testGroupLink()
+		expected.BertyGroup.Group.Secret = nil
+		expected.BertyGroup.Group.SecretSig = nil
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLinkEncrypted(uri, passphrase)
+			require.NoError(t, err)
+			require.False(t, parsed.HasGroupSecret())
+			require.Equal(t, expected, parsed)
+		}
+	})
+
+	t.Run("signature", func(t *testing.T) {
+		// a signed link whose signed fields were replaced before being encrypted
+		pk, sk, err := ed25519.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+		link := testContactLink()
+		link.BertyID.AccountPK = pk
+		internal, _, err := link.MarshalSigned(sk)
+		require.NoError(t, err)
+		signed, err := bertymessenger.UnmarshalLink(internal)
+		require.NoError(t, err)
+		signed.BertyID.PublicRendezvousSeed = bytes.Repeat([]byte{7}, 32)
+
+		internal, _, err = signed.MarshalEncrypted(passphrase)
+		require.NoError(t, err)
+		_, err = bertymessenger.UnmarshalLinkEncrypted(internal, passphrase)
+		require.True(t, errcode.Is(err, errcode.ErrCryptoSignatureVerification))
+	})
+
+	t.Run("unmarshal-options", func(t *testing.T) {
+		expired := testGroupLink()
+		expired.ExpiresAtUnix = time.Now().Add(-time.Hour).Unix()
+		internal, _, err := expired.MarshalEncrypted(passphrase)
+		require.NoError(t, err)
+		_, err = bertymessenger.UnmarshalLinkEncrypted(internal, passphrase)
+		require.True(t, errcode.Is(err, errcode.ErrLinkExpired))
+		parsed, err := bertymessenger.UnmarshalLinkEncrypted(internal, passphrase, bertymessenger.WithAllowExpired())
+		require.NoError(t, err)
+		require.Equal(t, expired, parsed)
+
+		oversized := "BERTY://ENC/" + strings.Repeat("A", bertymessenger.DefaultMaxLinkSize)
+		_, err = bertymessenger.UnmarshalLinkEncrypted(oversized, passphrase)
+		require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+	})
+
+	_, _, err := testContactLink().MarshalEncrypted("")
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+	_, _, err = (&bertymessenger.BertyLink{}).MarshalEncrypted(passphrase)
+	require.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package bertymessenger
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mr-tron/base58"
+)
+
+// LinkWebEncoding is the encoding of the binary part of the web links (see WithWebEncoding).
+type LinkWebEncoding int
+
+const (
+	// WebEncodingBase58 is the default encoding, compact and without look-alike chars,
+	// it is decoded by every version of Berty.
+	WebEncodingBase58 LinkWebEncoding = iota
+	// WebEncodingBase64URL is the URL-safe base64 encoding (RFC 4648, without padding), faster to decode and
+	// supported by most tools, i.e., for the integrations embedding the links in other systems.
+	WebEncodingBase64URL
+)
+
+var linkWebEncodingNames = map[LinkWebEncoding]string{
+	WebEncodingBase58:    "base58",
+	WebEncodingBase64URL: "base64url",
+}
+
+func (encoding LinkWebEncoding) String() string {
+	if name, ok := linkWebEncodingNames[encoding]; ok {
+		return name
+	}
+	return fmt.Sprintf("LinkWebEncoding(%d)", int(encoding))
+}
+
+// MarshalWithOptions is Marshal, with legacyWeb, the same web link in the format of the previous versions of Berty
+// when WithEmitLegacy is set, i.e., to keep sharing links the servers that don't decode the base64url web links yet
+// can parse. The web link stays in the current format, in the encoding of WithWebEncoding, and legacyWeb is web if it
+// is already in base58. legacyWeb is empty without WithEmitLegacy.
+//
+// Deprecation timeline: WithEmitLegacy is only meant for the migration to WebEncodingBase64URL. The servers have
+// until 2027-06-30 to decode the base64url web links, legacyWeb will then be removed (MarshalWithOptions will only
+// return the internal and web links), and the links of the older apps will be the only base58 ones to decode.
+func (link *BertyLink) MarshalWithOptions(opts ...MarshalOption) (internal string, web string, legacyWeb string, err error) {
+	options, err := link.marshalOptions(opts)
+	if err != nil {
+		return "", "", "", err
+	}
+	if web, err = link.marshalWeb(options); err != nil {
+		return "", "", "", err
+	}
+	if internal, err = link.marshalInternal(options); err != nil {
+		return "", "", "", err
+	}
+	if !options.EmitLegacy {
+		return internal, web, "", nil
+	}
+	if options.WebEncoding == WebEncodingBase58 {
+		return internal, web, web, nil
+	}
+
+	options.WebEncoding = WebEncodingBase58
+	if legacyWeb, err = link.marshalWeb(options); err != nil {
+		return "", "", "", err
+	}
+	return internal, web, legacyWeb, nil
+}
+
+// base64URLWebBlobPrefix is the prefix of the base64url-encoded web blobs, the digit zero is not in the base58
+// alphabet, so the base58 blobs, i.e., the ones of the links generated before WithWebEncoding, are left unprefixed.
+const base64URLWebBlobPrefix = "0"
+
+// encodeWebBlob returns the encoding of the binary part of a web link.
+func encodeWebBlob(bin []byte, encoding LinkWebEncoding) string {
+	if encoding == WebEncodingBase64URL {
+		return base64URLWebBlobPrefix + base64.RawURLEncoding.EncodeToString(bin)
+	}
+	return base58.Encode(bin)
+}
+
+// decodeWebBlob decodes the binary part of a web link, detecting its encoding from its prefix.
+func decodeWebBlob(blob string) ([]byte, error) {
+	if strings.HasPrefix(blob, base64URLWebBlobPrefix) {
+		return base64.RawURLEncoding.DecodeString(blob[len(base64URLWebBlobPrefix):])
+	}
+	return base58.Decode(blob)
+}
+
+// webBlobErrorOffset returns the byte offset in blob of the first invalid char reported by decodeWebBlob, or -1.
+func webBlobErrorOffset(blob string, err error) int {
+	if !strings.HasPrefix(blob, base64URLWebBlobPrefix) {
+		return invalidCharOffset(blob, base58Alphabet)
+	}
+	var corrupt base64.CorruptInputError
+	if !errors.As(err, &corrupt) {
+		return -1
+	}
+	return len(base64URLWebBlobPrefix) + int(corrupt)
+}
@@ -0,0 +1,117 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestMarshalLinkWithWebEncoding(t *testing.T) {
+	groupSet := &bertymessenger.BertyLink{
+		Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+		BertyGroups: []*bertymessenger.BertyGroup{testGroupLink().BertyGroup},
+	}
+	message := &bertymessenger.BertyLink{
+		Kind:            bertymessenger.BertyLink_MessageV1Kind,
+		BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32), MessageCID: "bafy"},
+	}
+
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink(), groupSet, message} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			internal, base58Web, err := link.Marshal()
+			require.NoError(t, err)
+			_, defaultWeb, err := link.Marshal(bertymessenger.WithWebEncoding(bertymessenger.WebEncodingBase58))
+			require.NoError(t, err)
+			require.Equal(t, base58Web, defaultWeb)
+
+			base64Internal, base64Web, err := link.Marshal(bertymessenger.WithWebEncoding(bertymessenger.WebEncodingBase64URL))
+			require.NoError(t, err)
+			require.Equal(t, internal, base64Internal)
+			require.NotEqual(t, base58Web, base64Web)
+			blob := strings.Split(strings.TrimPrefix(base64Web, bertymessenger.LinkWebPrefix), "/")[1]
+			require.True(t, strings.HasPrefix(blob, "0"), blob)
+			require.NotContains(t, blob, "=")
+			require.NotContains(t, blob, "+")
+
+			// both encodings are detected
+			for _, uri := range []string{base58Web, base64Web} {
+				parsed, err := bertymessenger.UnmarshalLink(uri)
+				require.NoError(t, err, uri)
+				require.Equal(t, link, parsed)
+				parsed, err = bertymessenger.UnmarshalWebLink(uri)
+				require.NoError(t, err, uri)
+				require.Equal(t, link, parsed)
+
+				kind, err := bertymessenger.ValidateURI(uri)
+				require.NoError(t, err, uri)
+				require.Equal(t, link.Kind, kind)
+			}
+		})
+	}
+
+	_, _, err := testContactLink().Marshal(bertymessenger.WithWebEncoding(bertymessenger.LinkWebEncoding(42)))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	require.Equal(t, "base64url", bertymessenger.WebEncodingBase64URL.String())
+
+	// an invalid base64url char is located
+	_, err = bertymessenger.UnmarshalLink("https://berty.tech/id#contact/0ab$cd")
+	var parseErr *bertymessenger.LinkParseError
+	require.True(t, errors.As(err, &parseErr), err)
+	require.Equal(t, bertymessenger.LinkParseDecode, parseErr.Stage)
+	require.Equal(t, 1, parseErr.Segment)
+	require.Equal(t, 3, parseErr.Offset)
+}
+
+func TestMarshalLinkWithOptions(t *testing.T) {
+	link := testContactLink()
+	internal, base58Web, err := link.Marshal()
+	require.NoError(t, err)
+	_, base64Web, err := link.Marshal(bertymessenger.WithWebEncoding(bertymessenger.WebEncodingBase64URL))
+	require.NoError(t, err)
+
+	// the web link stays in the selected encoding, the legacy one is in base58
+	gotInternal, web, legacyWeb, err := link.MarshalWithOptions(bertymessenger.WithWebEncoding(bertymessenger.WebEncodingBase64URL), bertymessenger.WithEmitLegacy())
+	require.NoError(t, err)
+	require.Equal(t, internal, gotInternal)
+	require.Equal(t, base64Web, web)
+	require.Equal(t, base58Web, legacyWeb)
+	for _, uri := range []string{web, legacyWeb} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err, uri)
+		require.Equal(t, link, parsed)
+	}
+
+	// the legacy web link is only returned with WithEmitLegacy
+	gotInternal, web, legacyWeb, err = link.MarshalWithOptions(bertymessenger.WithWebEncoding(bertymessenger.WebEncodingBase64URL))
+	require.NoError(t, err)
+	require.Equal(t, internal, gotInternal)
+	require.Equal(t, base64Web, web)
+	require.Empty(t, legacyWeb)
+
+	// and it is ignored by Marshal
+	_, web, err = link.Marshal(bertymessenger.WithWebEncoding(bertymessenger.WebEncodingBase64URL), bertymessenger.WithEmitLegacy())
+	require.NoError(t, err)
+	require.Equal(t, base64Web, web)
+
+	// the other options apply to both web links
+	const prefix = "https://chat.example.org/id#"
+	_, web, legacyWeb, err = link.MarshalWithOptions(bertymessenger.WithWebEncoding(bertymessenger.WebEncodingBase64URL), bertymessenger.WithWebPrefix(prefix), bertymessenger.WithEmitLegacy())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(web, prefix), web)
+	require.True(t, strings.HasPrefix(legacyWeb, prefix), legacyWeb)
+
+	// a base58 web link is already a legacy one
+	_, web, legacyWeb, err = link.MarshalWithOptions(bertymessenger.WithEmitLegacy())
+	require.NoError(t, err)
+	require.Equal(t, base58Web, web)
+	require.Equal(t, web, legacyWeb)
+
+	_, _, _, err = (&bertymessenger.BertyLink{}).MarshalWithOptions(bertymessenger.WithEmitLegacy())
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+}
@@ -0,0 +1,127 @@
+package bertymessenger
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// LinkParseStage is the decoding stage of a link that failed, see LinkParseError.
+type LinkParseStage int
+
+const (
+	// LinkParsePrefix is the detection of the form of the link, i.e., an unknown scheme or a missing payload.
+	LinkParsePrefix LinkParseStage = iota
+	// LinkParseDecode is the decoding of the payload, base58 for the web links, and base45 for the internal ones.
+	LinkParseDecode
+	// LinkParseProto is the decoding of the binary payload, i.e., a payload altered or cut while being copied.
+	LinkParseProto
+	// LinkParseQuery is the decoding of the human-readable part of the web links.
+	LinkParseQuery
+	// LinkParseKind is the validation of the kind of the link, i.e., an unknown or unsupported kind.
+	LinkParseKind
+)
+
+var linkParseStageNames = map[LinkParseStage]string{
+	LinkParsePrefix: "prefix",
+	LinkParseDecode: "decode",
+	LinkParseProto:  "proto",
+	LinkParseQuery:  "query",
+	LinkParseKind:   "kind",
+}
+
+func (stage LinkParseStage) String() string {
+	if name, ok := linkParseStageNames[stage]; ok {
+		return name
+	}
+	return fmt.Sprintf("LinkParseStage(%d)", int(stage))
+}
+
+// LinkParseError describes why UnmarshalLink failed to decode a link, so the UI can give an actionable feedback
+// instead of a generic "invalid link" message.
+//
+// It is wrapped in an errcode error (ErrInvalidInput, unless the stage has a more specific code, i.e.,
+// ErrLinkUnknownKind), use errors.As to get it.
+type LinkParseError struct {
+	Stage LinkParseStage
+	// Segment is the index of the failing '/'-separated segment of the link, after its prefix:
+	// "https://berty.tech/id#{0}/{1}/{2}" for the web links (kind, payload, human-readable part), and
+	// "BERTY://{0}/{1}" for the internal ones (marker, payload), or -1 if the failure is not specific to a segment.
+	Segment int
+	// Offset is the byte offset in the segment of the first invalid char, or -1 if it is unknown.
+	Offset int
+	// Err is the cause of the failure.
+	Err error
+}
+
+func (e *LinkParseError) Error() string {
+	var where string
+	switch {
+	case e.Segment >= 0 && e.Offset >= 0:
+		where = fmt.Sprintf(" (segment %d, offset %d)", e.Segment, e.Offset)
+	case e.Segment >= 0:
+		where = fmt.Sprintf(" (segment %d)", e.Segment)
+	}
+	return fmt.Sprintf("link %s failed%s: %v", e.Stage, where, e.Err)
+}
+
+// Unwrap returns the cause of the failure (go1.13)
+func (e *LinkParseError) Unwrap() error {
+	return e.Err
+}
+
+// linkParseError returns a LinkParseError wrapped with the code of cause, or with ErrInvalidInput if it has none.
+func linkParseError(stage LinkParseStage, segment int, offset int, cause error) error {
+	return linkErrorCode(cause).Wrap(&LinkParseError{Stage: stage, Segment: segment, Offset: offset, Err: cause})
+}
+
+// linkErrorCode returns the code of err, or ErrInvalidInput if it has none, so an error wrapping err keeps its specific
+// code (i.e., ErrLinkExpired) for errcode.Is.
+func linkErrorCode(err error) errcode.ErrCode {
+	if code := errcode.Code(err); code != -1 {
+		return code
+	}
+	return errcode.ErrInvalidInput
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// invalidCharOffset returns the byte offset of the first char of s that is not in alphabet, or -1.
+func invalidCharOffset(s string, alphabet string) int {
+	return strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune(alphabet, r) })
+}
+
+// escapeErrorOffset returns the byte offset in s of the invalid escape sequence of a url.EscapeError, or -1.
+func escapeErrorOffset(s string, err error) int {
+	var escapeErr url.EscapeError
+	if !errors.As(err, &escapeErr) {
+		return -1
+	}
+	return strings.Index(s, string(escapeErr))
+}
+
+// webLinkURLError returns the LinkParseError of a web link that url.Parse failed to parse.
+//
+// An invalid escape sequence is reported with the stage and the segment of the fragment it is in,
+// i.e., the LinkParseQuery stage if it is in the human-readable part.
+func webLinkURLError(uri string, err error) error {
+	start, _ := webLinkFragmentStart(uri)
+	fragment := uri[start:]
+	offset := escapeErrorOffset(fragment, err)
+	if offset == -1 {
+		return linkParseError(LinkParsePrefix, -1, -1, err)
+	}
+
+	// the segments are split as in splitWebFragment, the human-readable part may contain '/'
+	stages := []LinkParseStage{LinkParseKind, LinkParseDecode, LinkParseQuery}
+	for segment, part := range strings.SplitN(fragment, "/", len(stages)) {
+		if offset < len(part) {
+			return linkParseError(stages[segment], segment, offset, err)
+		}
+		offset -= len(part) + 1
+	}
+	return linkParseError(LinkParsePrefix, -1, -1, err)
+}
@@ -0,0 +1,99 @@
+package bertymessenger_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mr-tron/base58"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestUnmarshalLinkParseError(t *testing.T) {
+	internal, web, err := testContactLink().Marshal()
+	require.NoError(t, err)
+	webBlob := strings.Split(strings.TrimPrefix(web, bertymessenger.LinkWebPrefix), "/")[1]
+	internalPayload := strings.TrimPrefix(internal, "BERTY://PB1/")
+
+	cases := []struct {
+		name            string
+		uri             string
+		expectedErrcode errcode.ErrCode
+		expectedStage   bertymessenger.LinkParseStage
+		expectedSegment int
+		expectedOffset  int
+	}{
+		{"unknown-scheme", "ftp://berty.tech/id#contact/" + webBlob, errcode.ErrInvalidInput, bertymessenger.LinkParsePrefix, -1, -1},
+		{"web-without-payload", "https://berty.tech/id#contact", errcode.ErrInvalidInput, bertymessenger.LinkParsePrefix, -1, -1},
+		{"internal-unknown-marker", "BERTY://XX/" + internalPayload, errcode.ErrInvalidInput, bertymessenger.LinkParsePrefix, 0, -1},
+		{"web-unknown-kind", "https://berty.tech/id#f00bar/" + webBlob, errcode.ErrLinkUnknownKind, bertymessenger.LinkParseKind, 0, -1},
+		{"web-invalid-char", "https://berty.tech/id#contact/" + webBlob[:5] + "0" + webBlob[6:], errcode.ErrInvalidInput, bertymessenger.LinkParseDecode, 1, 5},
+		{"internal-invalid-char", "BERTY://PB1/" + internalPayload[:7] + "!" + internalPayload[8:], errcode.ErrInvalidInput, bertymessenger.LinkParseDecode, 1, 7},
+		{"internal-scan-error", "BERTY://PC/ABC", errcode.ErrLinkInvalidScan, bertymessenger.LinkParseDecode, 1, -1},
+		{"web-incomplete-proto", "https://berty.tech/id#contact/" + base58.Encode([]byte{0x12, 0x05}), errcode.ErrInvalidInput, bertymessenger.LinkParseProto, 1, -1},
+		{"web-invalid-escape", web + "&note=%zz", errcode.ErrInvalidInput, bertymessenger.LinkParseQuery, 2, len("name=Alice&note=")},
+		{"web-invalid-timestamp", web + "&exp=soon", errcode.ErrInvalidInput, bertymessenger.LinkParseQuery, 2, -1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := bertymessenger.UnmarshalLink(tc.uri)
+			require.Error(t, err)
+			require.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
+
+			var parseErr *bertymessenger.LinkParseError
+			require.True(t, errors.As(err, &parseErr), err)
+			require.Equal(t, tc.expectedStage, parseErr.Stage, parseErr)
+			require.Equal(t, tc.expectedSegment, parseErr.Segment, parseErr)
+			require.Equal(t, tc.expectedOffset, parseErr.Offset, parseErr)
+			require.Error(t, parseErr.Err)
+			require.Contains(t, err.Error(), "link "+tc.expectedStage.String()+" failed")
+		})
+	}
+
+	// the valid links and the protected links are not parse errors
+	for _, uri := range []string{internal, web} {
+		_, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err)
+	}
+	_, err = bertymessenger.UnmarshalLink("BERTY://PIN/" + internalPayload)
+	var parseErr *bertymessenger.LinkParseError
+	require.False(t, errors.As(err, &parseErr))
+	require.True(t, errcode.Is(err, errcode.ErrLinkEncrypted))
+}
+
+func TestUnmarshalWebLinkMissingParts(t *testing.T) {
+	cases := []struct {
+		uri             string
+		expectedMessage string
+	}{
+		{"https://berty.tech/id", "web link is missing the '#' fragment"},
+		{"https://berty.tech/id/", "web link is missing the '#' fragment"},
+		{"HTTPS://chat.example.org/ID?utm_source=chat", "web link is missing the '#' fragment"},
+		{"https://berty.tech/id#", "the '#' fragment of the web link is empty"},
+		{"https://berty.tech/id#contact", `web link is missing the payload after its kind: "contact"`},
+	}
+	for _, tc := range cases {
+		for _, unmarshal := range []func(string, ...bertymessenger.UnmarshalOption) (*bertymessenger.BertyLink, error){
+			bertymessenger.UnmarshalLink,
+			bertymessenger.UnmarshalWebLink,
+		} {
+			_, err := unmarshal(tc.uri)
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput), tc.uri)
+			require.Contains(t, err.Error(), tc.expectedMessage, tc.uri)
+
+			var parseErr *bertymessenger.LinkParseError
+			require.True(t, errors.As(err, &parseErr), tc.uri)
+			require.Equal(t, bertymessenger.LinkParsePrefix, parseErr.Stage, tc.uri)
+		}
+	}
+
+	// the other URLs are not web links
+	for _, uri := range []string{"https://berty.tech/", "https://berty.tech/identity", "https://berty.tech/id/contact"} {
+		_, err := bertymessenger.UnmarshalLink(uri)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), uri)
+		require.Contains(t, err.Error(), "unsupported link format", uri)
+	}
+}
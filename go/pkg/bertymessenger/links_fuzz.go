@@ -0,0 +1,99 @@
+// +build gofuzz
+
+package bertymessenger
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+
+	"berty.tech/berty/v2/go/pkg/bertytypes"
+)
+
+// FuzzLinkRoundTrip is a go-fuzz target (go-fuzz-build -func FuzzLinkRoundTrip), checking that any valid link
+// is decoded from its web and internal forms with the same meaningful fields, the display names of the web form
+// being sanitized (see SanitizeDisplayName).
+//
+// The first byte of data selects the kind, the rest is used as the display name (or as the message CID) and
+// as a metadata value. The corpus is seeded with testdata/fuzz/FuzzLinkRoundTrip/corpus.
+func FuzzLinkRoundTrip(data []byte) int {
+	if len(data) == 0 {
+		return -1
+	}
+	link := fuzzLink(data[0], string(data[1:]))
+	if link.IsValid() != nil {
+		return 0
+	}
+
+	internal, web, err := link.Marshal(WithURLLengthLimit(math.MaxInt32))
+	if err != nil {
+		panic(fmt.Errorf("valid link not marshaled: %w", err))
+	}
+	for uri, expected := range map[string]*BertyLink{internal: link, web: sanitizedDisplayNames(link)} {
+		parsed, err := UnmarshalLink(uri)
+		if err != nil {
+			panic(fmt.Errorf("%q not unmarshaled: %w", uri, err))
+		}
+		if !proto.Equal(expected, parsed) {
+			panic(fmt.Errorf("%q round-trip mismatch: %v != %v", uri, parsed, expected))
+		}
+	}
+	return 1
+}
+
+// sanitizedDisplayNames returns a copy of link with sanitized display names, as decoded from its web form.
+func sanitizedDisplayNames(link *BertyLink) *BertyLink {
+	sanitized := proto.Clone(link).(*BertyLink)
+	if sanitized.BertyID != nil {
+		sanitized.BertyID.DisplayName = SanitizeDisplayName(sanitized.BertyID.DisplayName)
+	}
+	for _, group := range append([]*BertyGroup{sanitized.BertyGroup}, sanitized.BertyGroups...) {
+		if group != nil {
+			group.DisplayName = SanitizeDisplayName(group.DisplayName)
+		}
+	}
+	return sanitized
+}
+
+func fuzzLink(selector byte, text string) *BertyLink {
+	text = truncateUTF8(strings.ToValidUTF8(text, ""), MaxDisplayNameLength)
+	group := func(seed byte, name string) *BertyGroup {
+		return &BertyGroup{
+			DisplayName: name,
+			Group: &bertytypes.Group{
+				PublicKey: bytes.Repeat([]byte{seed}, 32),
+				Secret:    bytes.Repeat([]byte{4}, 32),
+				SecretSig: bytes.Repeat([]byte{5}, 64),
+				GroupType: bertytypes.GroupTypeMultiMember,
+				SignPub:   bytes.Repeat([]byte{6}, 32),
+			},
+		}
+	}
+
+	link := &BertyLink{Metadata: map[string]string{"note": text}}
+	switch selector % 5 {
+	case 0:
+		link.Kind = BertyLink_ContactInviteV1Kind
+		link.BertyID = &BertyID{
+			PublicRendezvousSeed: bytes.Repeat([]byte{1}, 32),
+			AccountPK:            bytes.Repeat([]byte{2}, 32),
+			DisplayName:          text,
+		}
+	case 1:
+		link.Kind = BertyLink_GroupV1Kind
+		link.BertyGroup = group(3, text)
+	case 2:
+		link.Kind = BertyLink_GroupSetV1Kind
+		link.BertyGroups = []*BertyGroup{group(3, ""), group(7, text)}
+	case 3:
+		link.Kind = BertyLink_MessageV1Kind
+		link.BertyMessageRef = &BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32), MessageCID: text}
+	case 4:
+		link.Kind = BertyLink_AccountPubV1Kind
+		link.BertyID = &BertyID{AccountPK: bytes.Repeat([]byte{2}, 32)}
+	}
+	return link
+}
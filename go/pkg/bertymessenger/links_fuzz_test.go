@@ -0,0 +1,91 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"moul.io/srand"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+)
+
+// TestLinkRoundTrip runs the checks of the FuzzLinkRoundTrip go-fuzz target on its seed corpus, and on random names.
+//
+// The display names decoded from the web links are sanitized, the other fields are decoded as is.
+func TestLinkRoundTrip(t *testing.T) {
+	names := []string{}
+	corpus, err := filepath.Glob("testdata/fuzz/FuzzLinkRoundTrip/corpus/*")
+	require.NoError(t, err)
+	require.NotEmpty(t, corpus)
+	for _, path := range corpus {
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.NotEmpty(t, data, path)
+		names = append(names, string(data[1:]))
+	}
+
+	rand.Seed(srand.Fast())
+	const tricky = "/#&=?%+ "
+	for i := 0; i < 50; i++ {
+		name := []rune{}
+		for j := rand.Intn(32); j > 0; j-- {
+			if rand.Intn(2) == 0 {
+				name = append(name, rune(tricky[rand.Intn(len(tricky))]))
+			} else {
+				name = append(name, rune(0x20+rand.Intn(0x2000)))
+			}
+		}
+		names = append(names, string(name))
+	}
+
+	for _, name := range names {
+		contact := testContactLink()
+		contact.BertyID.DisplayName = name
+		group := testGroupLink()
+		group.BertyGroup.DisplayName = name
+		unnamed := testGroupLink().BertyGroup
+		unnamed.DisplayName = ""
+		unnamed.Group.PublicKey = bytes.Repeat([]byte{7}, 32)
+		groupSet := &bertymessenger.BertyLink{
+			Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+			BertyGroups: []*bertymessenger.BertyGroup{unnamed, group.BertyGroup},
+		}
+		message := &bertymessenger.BertyLink{
+			Kind:            bertymessenger.BertyLink_MessageV1Kind,
+			BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32), MessageCID: name},
+		}
+		account := &bertymessenger.BertyLink{
+			Kind:    bertymessenger.BertyLink_AccountPubV1Kind,
+			BertyID: &bertymessenger.BertyID{AccountPK: bytes.Repeat([]byte{2}, 32)},
+		}
+
+		for _, link := range []*bertymessenger.BertyLink{contact, group, groupSet, message, account} {
+			link.Metadata = map[string]string{"note": name}
+			require.NoError(t, link.IsValid())
+
+			internal, web, err := link.Marshal(bertymessenger.WithURLLengthLimit(math.MaxInt32))
+			require.NoError(t, err, "%q", name)
+			sanitized := proto.Clone(link).(*bertymessenger.BertyLink)
+			switch {
+			case sanitized.Kind == bertymessenger.BertyLink_ContactInviteV1Kind:
+				sanitized.BertyID.DisplayName = bertymessenger.SanitizeDisplayName(name)
+			case sanitized.BertyGroup != nil:
+				sanitized.BertyGroup.DisplayName = bertymessenger.SanitizeDisplayName(name)
+			case len(sanitized.BertyGroups) > 0:
+				sanitized.BertyGroups[1].DisplayName = bertymessenger.SanitizeDisplayName(name)
+			}
+
+			for uri, expected := range map[string]*bertymessenger.BertyLink{internal: link, web: sanitized} {
+				parsed, err := bertymessenger.UnmarshalLink(uri)
+				require.NoError(t, err, uri)
+				require.True(t, proto.Equal(expected, parsed), "%q: %v != %v", uri, parsed, expected)
+			}
+		}
+	}
+}
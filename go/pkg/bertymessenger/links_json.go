@@ -0,0 +1,42 @@
+package bertymessenger
+
+import (
+	"bytes"
+
+	"github.com/gogo/protobuf/jsonpb"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// LinkToJSON returns the canonical JSON form of link, i.e., to diff two links in the debugging tools.
+//
+// It is the proto3 JSON mapping of the link: the binary fields (keys, secrets, nonces) are base64 strings, the enums
+// are their names (i.e., "ContactInviteV1Kind"), the 64-bit integers are decimal strings, the metadata keys are sorted,
+// and the empty fields are omitted. Unlike the web and internal links, it carries all the fields of the link.
+//
+// It is deliberately not the MarshalJSON method of BertyLink: encoding/json would then use it for every message
+// embedding a link, i.e., the ParseDeepLink reply or the StreamEvent and Contact JSON of types.go, and these replies
+// would no longer be rendered like the jsonpb rendering of the gateway, nor like the JSON the clients already parse
+// (i.e., "kind":1). The canonical JSON is only produced on request, by LinkToJSON.
+func LinkToJSON(link *BertyLink) ([]byte, error) {
+	if link == nil {
+		return nil, errcode.ErrMissingInput
+	}
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, link); err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// LinkFromJSON decodes a link generated by LinkToJSON, it rejects the unknown fields.
+// Like LinkToJSON, it is not the UnmarshalJSON method of BertyLink, so the decoding of the replies is left unchanged.
+//
+// It doesn't check that the link is valid (see IsValid), nor that it is active.
+func LinkFromJSON(data []byte) (*BertyLink, error) {
+	var link BertyLink
+	if err := jsonpb.Unmarshal(bytes.NewReader(data), &link); err != nil {
+		return nil, errcode.ErrDeserialization.Wrap(err)
+	}
+	return &link, nil
+}
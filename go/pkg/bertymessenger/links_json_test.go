@@ -0,0 +1,89 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkJSON(t *testing.T) {
+	contact := testContactLink()
+	contact.BertyID.ReferralCode = "spring-21"
+	contact.Metadata = map[string]string{"zzz": "last", "bio": "hello"}
+	contact.ExpiresAtUnix = 1700000000
+	contact.ExpiryDisplayHint = bertymessenger.BertyLink_ExpiryAbsolute
+
+	group := testGroupLink()
+	group.BertyGroup.RegenerationLog = []*bertymessenger.BertyGroup_RegenerationEntry{
+		{RegeneratedAtUnix: 1600000000, RegeneratorPKHash: bytes.Repeat([]byte{8}, 32)},
+	}
+
+	groupSet := &bertymessenger.BertyLink{
+		Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+		BertyGroups: []*bertymessenger.BertyGroup{testGroupLink().BertyGroup},
+	}
+	message := &bertymessenger.BertyLink{
+		Kind:            bertymessenger.BertyLink_MessageV1Kind,
+		BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32), MessageCID: "bafy"},
+	}
+
+	for _, link := range []*bertymessenger.BertyLink{contact, group, groupSet, message} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			out, err := bertymessenger.LinkToJSON(link)
+			require.NoError(t, err)
+			require.Contains(t, string(out), `"kind":"`+link.Kind.String()+`"`)
+
+			// lossless, and stable
+			decoded, err := bertymessenger.LinkFromJSON(out)
+			require.NoError(t, err)
+			require.Equal(t, link, decoded)
+			again, err := bertymessenger.LinkToJSON(decoded)
+			require.NoError(t, err)
+			require.Equal(t, string(out), string(again))
+
+			// JSON -> struct -> internal URL is the original internal URL
+			internal, err := link.ToInternal()
+			require.NoError(t, err)
+			decodedInternal, err := decoded.ToInternal()
+			require.NoError(t, err)
+			require.Equal(t, internal, decodedInternal)
+		})
+	}
+
+	// the binary fields are base64 strings, and the enums are their names
+	out, err := bertymessenger.LinkToJSON(group)
+	require.NoError(t, err)
+	for _, value := range [][]byte{
+		group.BertyGroup.Group.PublicKey,
+		group.BertyGroup.Group.Secret,
+		group.BertyGroup.Group.SecretSig,
+		group.BertyGroup.RegenerationLog[0].RegeneratorPKHash,
+	} {
+		require.Contains(t, string(out), `"`+base64.StdEncoding.EncodeToString(value)+`"`)
+	}
+	require.Contains(t, string(out), `"groupType":"GroupTypeMultiMember"`)
+
+	out, err = bertymessenger.LinkToJSON(contact)
+	require.NoError(t, err)
+	require.Contains(t, string(out), `"publicRendezvousSeed":"`+base64.StdEncoding.EncodeToString(contact.BertyID.PublicRendezvousSeed)+`"`)
+	require.Contains(t, string(out), `"metadata":{"bio":"hello","zzz":"last"}`)
+	require.Contains(t, string(out), `"expiryDisplayHint":"ExpiryAbsolute"`)
+
+	// the encoding/json form of the proto struct is left unchanged
+	plain, err := json.Marshal(contact)
+	require.NoError(t, err)
+	require.Contains(t, string(plain), `"kind":1`)
+
+	_, err = bertymessenger.LinkToJSON(nil)
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+	for _, invalid := range []string{`{"kind":"FooKind"}`, `{"unknown":1}`, `{"bertyId":{"accountPk":"%%%"}}`, `[]`} {
+		_, err := bertymessenger.LinkFromJSON([]byte(invalid))
+		require.True(t, errcode.Is(err, errcode.ErrDeserialization), invalid)
+	}
+}
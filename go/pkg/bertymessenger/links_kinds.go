@@ -0,0 +1,120 @@
+package bertymessenger
+
+import (
+	"fmt"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// KindInfo describes a link kind and its capabilities.
+type KindInfo struct {
+	Kind BertyLink_Kind
+	// Token is the identifier of the kind in the web links, i.e., "https://berty.tech/id#{token}/...".
+	Token string
+	// Shareable is true if the links of this kind can be generated with BertyLink.Marshal.
+	Shareable bool
+	// HasSecret is true if the links of this kind carry secret material, that should not be shared publicly.
+	HasSecret bool
+	// WebRepresentable is true if the links of this kind have a web form, in addition to the internal one.
+	WebRepresentable bool
+}
+
+// linkKinds is the registry of the supported link kinds.
+var linkKinds = []KindInfo{
+	{
+		Kind:             BertyLink_ContactInviteV1Kind,
+		Token:            "contact",
+		Shareable:        true,
+		HasSecret:        false,
+		WebRepresentable: true,
+	},
+	{
+		Kind:             BertyLink_GroupV1Kind,
+		Token:            "group",
+		Shareable:        true,
+		HasSecret:        true,
+		WebRepresentable: true,
+	},
+	{
+		Kind:             BertyLink_GroupSetV1Kind,
+		Token:            "groups",
+		Shareable:        true,
+		HasSecret:        true,
+		WebRepresentable: true,
+	},
+	{
+		Kind:             BertyLink_MessageV1Kind,
+		Token:            "message",
+		Shareable:        true,
+		HasSecret:        false,
+		WebRepresentable: true,
+	},
+	{
+		Kind:             BertyLink_AccountPubV1Kind,
+		Token:            "account",
+		Shareable:        true,
+		HasSecret:        false,
+		WebRepresentable: true,
+	},
+}
+
+// SupportedKinds returns the link kinds supported by this version of Berty, and their capabilities.
+func SupportedKinds() []KindInfo {
+	kinds := make([]KindInfo, len(linkKinds))
+	copy(kinds, linkKinds)
+	return kinds
+}
+
+func kindInfoOf(kind BertyLink_Kind) (KindInfo, bool) {
+	for _, info := range linkKinds {
+		if info.Kind == kind {
+			return info, true
+		}
+	}
+	return KindInfo{}, false
+}
+
+func kindInfoByToken(token string) (KindInfo, bool) {
+	for _, info := range linkKinds {
+		if info.Token == token {
+			return info, true
+		}
+	}
+	return KindInfo{}, false
+}
+
+// upcomingLinkTokens are the web tokens of the link kinds planned for the next versions of Berty.
+//
+// This version can't decode them, but it can ask the user to update the app instead of reporting an invalid link.
+var upcomingLinkTokens = map[string]bool{
+	"contacts": true,
+}
+
+// WebSlug returns the path segment identifying the kind in the web links, i.e., "contact" in
+// "https://berty.tech/id#contact/...", or an empty string if the kind has no web form.
+func (kind BertyLink_Kind) WebSlug() string {
+	if info, ok := kindInfoOf(kind); ok && info.WebRepresentable {
+		return info.Token
+	}
+	return ""
+}
+
+// ParseWebSlug returns the kind identified by a web link path segment, it is the reverse of BertyLink_Kind.WebSlug.
+//
+// It returns ErrLinkNeedsUpdate for the slugs of the upcoming kinds, and ErrLinkUnknownKind for the other ones,
+// so a slug can be checked before attempting to decode the whole link.
+func ParseWebSlug(slug string) (BertyLink_Kind, error) {
+	if slug == "" {
+		return BertyLink_UnknownKind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("missing link kind"))
+	}
+	if info, ok := kindInfoByToken(slug); ok {
+		if !info.WebRepresentable {
+			return BertyLink_UnknownKind, errcode.ErrInvalidInput.Wrap(fmt.Errorf("%q links have no web form", slug))
+		}
+		return info.Kind, nil
+	}
+	if upcomingLinkTokens[slug] {
+		return BertyLink_UnknownKind, errcode.ErrLinkNeedsUpdate.Wrap(fmt.Errorf("%q links are not supported by this version", slug))
+	}
+	return BertyLink_UnknownKind, errcode.ErrLinkUnknownKind.Wrap(fmt.Errorf("unknown link kind: %q", slug))
+}
@@ -0,0 +1,119 @@
+package bertymessenger_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestSupportedKinds(t *testing.T) {
+	kinds := bertymessenger.SupportedKinds()
+
+	byKind := map[bertymessenger.BertyLink_Kind]bertymessenger.KindInfo{}
+	for _, info := range kinds {
+		require.NotEqual(t, bertymessenger.BertyLink_UnknownKind, info.Kind)
+		_, duplicate := byKind[info.Kind]
+		require.False(t, duplicate, info.Kind)
+		byKind[info.Kind] = info
+	}
+
+	require.Equal(t, bertymessenger.KindInfo{
+		Kind:             bertymessenger.BertyLink_ContactInviteV1Kind,
+		Token:            "contact",
+		Shareable:        true,
+		HasSecret:        false,
+		WebRepresentable: true,
+	}, byKind[bertymessenger.BertyLink_ContactInviteV1Kind])
+	require.Equal(t, bertymessenger.KindInfo{
+		Kind:             bertymessenger.BertyLink_GroupV1Kind,
+		Token:            "group",
+		Shareable:        true,
+		HasSecret:        true,
+		WebRepresentable: true,
+	}, byKind[bertymessenger.BertyLink_GroupV1Kind])
+	require.Equal(t, bertymessenger.KindInfo{
+		Kind:             bertymessenger.BertyLink_GroupSetV1Kind,
+		Token:            "groups",
+		Shareable:        true,
+		HasSecret:        true,
+		WebRepresentable: true,
+	}, byKind[bertymessenger.BertyLink_GroupSetV1Kind])
+	require.Equal(t, bertymessenger.KindInfo{
+		Kind:             bertymessenger.BertyLink_MessageV1Kind,
+		Token:            "message",
+		Shareable:        true,
+		HasSecret:        false,
+		WebRepresentable: true,
+	}, byKind[bertymessenger.BertyLink_MessageV1Kind])
+	require.Equal(t, bertymessenger.KindInfo{
+		Kind:             bertymessenger.BertyLink_AccountPubV1Kind,
+		Token:            "account",
+		Shareable:        true,
+		HasSecret:        false,
+		WebRepresentable: true,
+	}, byKind[bertymessenger.BertyLink_AccountPubV1Kind])
+
+	// the web links use the registered tokens
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		_, web, err := link.Marshal()
+		require.NoError(t, err)
+		require.Contains(t, web, "#"+byKind[link.Kind].Token+"/")
+	}
+
+	// the returned slice is a copy
+	kinds[0].Token = "foobar"
+	require.NotEqual(t, "foobar", bertymessenger.SupportedKinds()[0].Token)
+}
+
+func TestUnmarshalLinkUnsupportedKinds(t *testing.T) {
+	cases := []struct {
+		name            string
+		input           string
+		expectedErrcode errcode.ErrCode
+	}{
+		{"supported", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice", -1},
+		{"upcoming", "https://berty.tech/id#contacts/" + validContactBlob + "/name=Alice", errcode.ErrLinkNeedsUpdate},
+		{"upcoming-without-blob", "https://berty.tech/id#contacts/", errcode.ErrLinkNeedsUpdate},
+		{"garbage", "https://berty.tech/id#f00bar/" + validContactBlob + "/name=Alice", errcode.ErrLinkUnknownKind},
+		{"wrong-case", "https://berty.tech/id#Contact/" + validContactBlob + "/name=Alice", errcode.ErrLinkUnknownKind},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := bertymessenger.UnmarshalLink(tc.input)
+			assert.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
+		})
+	}
+}
+
+func TestLinkKindWebSlug(t *testing.T) {
+	for _, info := range bertymessenger.SupportedKinds() {
+		require.Equal(t, info.Token, info.Kind.WebSlug())
+		kind, err := bertymessenger.ParseWebSlug(info.Kind.WebSlug())
+		require.NoError(t, err)
+		require.Equal(t, info.Kind, kind)
+	}
+	require.Equal(t, "contact", bertymessenger.BertyLink_ContactInviteV1Kind.WebSlug())
+	require.Equal(t, "group", bertymessenger.BertyLink_GroupV1Kind.WebSlug())
+	require.Empty(t, bertymessenger.BertyLink_UnknownKind.WebSlug())
+	require.Empty(t, bertymessenger.BertyLink_Kind(42).WebSlug())
+
+	cases := []struct {
+		slug            string
+		expectedErrcode errcode.ErrCode
+	}{
+		{"", errcode.ErrInvalidInput},
+		{"contacts", errcode.ErrLinkNeedsUpdate},
+		{"f00bar", errcode.ErrLinkUnknownKind},
+		{"Contact", errcode.ErrLinkUnknownKind},
+	}
+	for _, tc := range cases {
+		kind, err := bertymessenger.ParseWebSlug(tc.slug)
+		require.True(t, errcode.Is(err, tc.expectedErrcode), tc.slug)
+		require.Equal(t, bertymessenger.BertyLink_UnknownKind, kind)
+	}
+}
@@ -0,0 +1,77 @@
+package bertymessenger
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// legacyInternalLinkPrefix is the prefix of the internal v0 links, i.e., "berty://id/#key=...&name=Alice".
+const legacyInternalLinkPrefix = "berty://id/#"
+
+// UnmarshalLegacyLink parses a v0 contact link, the format of the apps predating the BertyLink proto, still found in
+// the chat histories: "https://berty.tech/id#key={BertyID}&name={display name}", or "berty://id/#key=..." for the
+// internal form, the BertyID being a base64url-encoded proto.
+//
+// It returns a ContactInviteV1Kind link, checked like the links parsed by UnmarshalLink, and ErrInvalidInput if uri
+// is not a v0 link. UnmarshalLink can fall back to it (see WithLegacyFallback).
+func UnmarshalLegacyLink(uri string, opts ...UnmarshalOption) (*BertyLink, error) {
+	return unmarshalLinkWith(uri, opts, unmarshalLegacyLink)
+}
+
+// legacyLinkFragment returns the "key=...&name=..." fragment of a v0 link, and false if uri is not a v0 link.
+//
+// The web links of any host are accepted, as for the current web links (see webLinkFragmentStart).
+func legacyLinkFragment(uri string) (string, bool) {
+	var fragment string
+	if start, ok := webLinkFragmentStart(uri); ok {
+		fragment = uri[start:]
+	} else if len(uri) >= len(legacyInternalLinkPrefix) && strings.EqualFold(uri[:len(legacyInternalLinkPrefix)], legacyInternalLinkPrefix) {
+		fragment = uri[len(legacyInternalLinkPrefix):]
+	} else {
+		return "", false
+	}
+	if !strings.HasPrefix(fragment, "key=") {
+		return "", false
+	}
+	return fragment, true
+}
+
+func unmarshalLegacyLink(uri string, _ UnmarshalOptions) (*BertyLink, error) {
+	fragment, ok := legacyLinkFragment(uri)
+	if !ok {
+		return nil, linkParseError(LinkParsePrefix, -1, -1, fmt.Errorf("not a legacy link"))
+	}
+	query, err := url.ParseQuery(fragment)
+	if err != nil {
+		return nil, linkParseError(LinkParseQuery, 0, -1, err)
+	}
+
+	// the older apps generated unpadded keys, the padded ones are accepted too
+	bin, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(query.Get("key"), "="))
+	if err != nil {
+		return nil, linkParseError(LinkParseDecode, 0, -1, err)
+	}
+	var id BertyID
+	if err := proto.Unmarshal(bin, &id); err != nil {
+		return nil, linkParseError(LinkParseProto, 0, -1, err)
+	}
+	id.DisplayName = webDisplayName(query.Get("name"))
+
+	link := &BertyLink{
+		Kind:    BertyLink_ContactInviteV1Kind,
+		BertyID: &id,
+	}
+	if err := link.IsValid(); err != nil {
+		if errcode.Is(err, errcode.ErrMissingInput) {
+			return nil, errcode.ErrInvalidInput.Wrap(err)
+		}
+		return nil, err
+	}
+	return link, nil
+}
@@ -0,0 +1,102 @@
+package bertymessenger_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// legacyAliceKey is the key of a v0 contact link of the older apps.
+const legacyAliceKey = "CiDXcXUOl1rpm2FcbOf3TFtn-FYkl_sOwA5run1LGXHOPRIg4xCLGP-BWzgIWRH0Vz9D8aGAq1kyno5Oqv6ysAljZmA"
+
+func TestUnmarshalLegacyLink(t *testing.T) {
+	contact := func(seed, accountPK, name string) *bertymessenger.BertyLink {
+		seedBin, err := hex.DecodeString(seed)
+		require.NoError(t, err)
+		accountPKBin, err := hex.DecodeString(accountPK)
+		require.NoError(t, err)
+		return &bertymessenger.BertyLink{
+			Kind: bertymessenger.BertyLink_ContactInviteV1Kind,
+			BertyID: &bertymessenger.BertyID{
+				PublicRendezvousSeed: seedBin,
+				AccountPK:            accountPKBin,
+				DisplayName:          name,
+			},
+		}
+	}
+	const (
+		aliceSeed = "d771750e975ae99b615c6ce7f74c5b67f8562497fb0ec00e6bba7d4b1971ce3d"
+		alicePK   = "e3108b18ff815b38085911f4573f43f1a180ab59329e8e4eaafeb2b009636660"
+	)
+
+	// real links of the older apps
+	fixtures := []struct {
+		uri      string
+		expected *bertymessenger.BertyLink
+	}{
+		{"berty://id/#key=" + legacyAliceKey + "&name=Alice", contact(aliceSeed, alicePK, "Alice")},
+		{"https://berty.tech/id#key=" + legacyAliceKey + "&name=Alice", contact(aliceSeed, alicePK, "Alice")},
+		{"berty://id/#key=" + legacyAliceKey, contact(aliceSeed, alicePK, "")},
+		{"https://berty.tech/id#key=" + legacyAliceKey, contact(aliceSeed, alicePK, "")},
+		{
+			"https://berty.tech/id#key=CiDnVU4YlFPkjTbSggoZAWbFdAIsnuv5qoruQDyN_NB8rBIgfrT2x0wzMjiK4kBXnPYStGxW2Hssk8UyYfW8ITJbEFg&name=Example",
+			contact("e7554e189453e48d36d2820a190166c574022c9eebf9aa8aee403c8dfcd07cac", "7eb4f6c74c3332388ae240579cf612b46c56d87b2c93c53261f5bc21325b1058", "Example"),
+		},
+		{
+			"https://berty.tech/id#key=CiBYAkJkmvcCZOl2hWuSK34arbzSpcpQGLowIvi7ZsEdyRIgMmKs-zHKksC74gjOfSj5puOAQQGWNhsC8o9gEtQ8zrQ&name=BetaBot",
+			contact("580242649af70264e976856b922b7e1aadbcd2a5ca5018ba3022f8bb66c11dc9", "3262acfb31ca92c0bbe208ce7d28f9a6e380410196361b02f28f6012d43cceb4", "BetaBot"),
+		},
+		{"BERTY://ID/#key=" + legacyAliceKey + "&name=Alice%20Liddell", contact(aliceSeed, alicePK, "Alice Liddell")},
+	}
+	for _, fixture := range fixtures {
+		parsed, err := bertymessenger.UnmarshalLegacyLink(fixture.uri)
+		require.NoError(t, err, fixture.uri)
+		require.Equal(t, fixture.expected, parsed, fixture.uri)
+
+		// UnmarshalLink only decodes them with the fallback
+		_, err = bertymessenger.UnmarshalLink(fixture.uri)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), fixture.uri)
+		parsed, err = bertymessenger.UnmarshalLink(fixture.uri, bertymessenger.WithLegacyFallback())
+		require.NoError(t, err, fixture.uri)
+		require.Equal(t, fixture.expected, parsed, fixture.uri)
+
+		// and they can be upgraded to the current format
+		internal, _, err := parsed.Marshal()
+		require.NoError(t, err)
+		upgraded, err := bertymessenger.UnmarshalLink(internal)
+		require.NoError(t, err)
+		require.Equal(t, fixture.expected, upgraded)
+	}
+
+	// the current links are still decoded with the fallback, but not by UnmarshalLegacyLink
+	internal, web, err := testContactLink().Marshal()
+	require.NoError(t, err)
+	for _, uri := range []string{internal, web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri, bertymessenger.WithLegacyFallback())
+		require.NoError(t, err, uri)
+		require.Equal(t, testContactLink(), parsed)
+		_, err = bertymessenger.UnmarshalLegacyLink(uri)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), uri)
+	}
+
+	for _, invalid := range []string{
+		"invalid",
+		"https://berty.tech/id#key=blah&name=blih",
+		"berty://id/#key=%%%",
+		"berty://id/#name=Alice",
+		// a BertyID without its rendezvous seed
+		"berty://id/#key=EiDjEIsY_4FbOAhZEfRXP0PxoYCrWTKejk6q_rKwCWNmYA",
+		"https://berty.tech@invalid.domain/id#key=" + legacyAliceKey,
+	} {
+		_, err := bertymessenger.UnmarshalLegacyLink(invalid)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), invalid)
+		_, err = bertymessenger.UnmarshalLink(invalid, bertymessenger.WithLegacyFallback())
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), invalid)
+	}
+	_, err = bertymessenger.UnmarshalLegacyLink("")
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+}
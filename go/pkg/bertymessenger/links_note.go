@@ -0,0 +1,100 @@
+package bertymessenger
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/gogo/protobuf/proto"
+	"golang.org/x/crypto/nacl/box"
+
+	"berty.tech/berty/v2/go/internal/cryptoutil"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// MaxSealedNoteLength is the maximum length in bytes of a note sealed with MarshalWithNoteFor.
+//
+// The sealed note is carried by the internal link, so each char makes the QR code larger.
+const MaxSealedNoteLength = 256
+
+// sealedNoteVersion is the first byte of a sealed note, it identifies the cipher.
+const sealedNoteVersion byte = 1
+
+// MarshalWithNoteFor is like Marshal, with a note that only the owner of recipientPK can read (see DecryptNote),
+// i.e., for a personalized invite.
+//
+// recipientPK is a Curve25519 public key. The note is sealed with NaCl box using an ephemeral key pair,
+// so the recipient can't authenticate the sender from the note alone.
+//
+// The sealed note is only carried by the internal link, the web link is the same as the one returned by Marshal.
+func (link *BertyLink) MarshalWithNoteFor(recipientPK []byte, note string, opts ...MarshalOption) (internal string, web string, err error) {
+	if link == nil || link.Kind == BertyLink_UnknownKind || len(recipientPK) == 0 || note == "" {
+		return "", "", errcode.ErrMissingInput
+	}
+	if len(note) > MaxSealedNoteLength {
+		return "", "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("note should have at most %d bytes", MaxSealedNoteLength))
+	}
+	if !utf8.ValidString(note) {
+		return "", "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("note should be valid UTF-8"))
+	}
+	peerPK, err := cryptoutil.KeySliceToArray(recipientPK)
+	if err != nil {
+		return "", "", errcode.ErrInvalidInput.Wrap(err)
+	}
+
+	ephemeralPK, ephemeralSK, err := box.GenerateKey(crand.Reader)
+	if err != nil {
+		return "", "", errcode.ErrCryptoKeyGeneration.Wrap(err)
+	}
+	nonce, err := cryptoutil.GenerateNonce()
+	if err != nil {
+		return "", "", errcode.ErrCryptoNonceGeneration.Wrap(err)
+	}
+
+	// sealed note format: version (1 byte) | ephemeral public key | nonce | box
+	sealed := make([]byte, 0, 1+cryptoutil.KeySize+cryptoutil.NonceSize+box.Overhead+len(note))
+	sealed = append(sealed, sealedNoteVersion)
+	sealed = append(sealed, ephemeralPK[:]...)
+	sealed = append(sealed, nonce[:]...)
+	sealed = box.Seal(sealed, []byte(note), nonce, peerPK, ephemeralSK)
+
+	noted := proto.Clone(link).(*BertyLink)
+	noted.SealedNote = sealed
+	return noted.Marshal(opts...)
+}
+
+// DecryptNote returns the note sealed with MarshalWithNoteFor, recipientPriv being the Curve25519 private key
+// matching the public key the note was sealed for.
+//
+// It returns ErrMissingInput if the link has no sealed note, and ErrCryptoDecrypt if recipientPriv is not
+// the right key.
+func (link *BertyLink) DecryptNote(recipientPriv []byte) (string, error) {
+	sealed := link.GetSealedNote()
+	if len(sealed) == 0 {
+		return "", errcode.ErrMissingInput.Wrap(fmt.Errorf("link has no sealed note"))
+	}
+	if len(sealed) < 1+cryptoutil.KeySize+cryptoutil.NonceSize+box.Overhead {
+		return "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("sealed note too small: %d bytes", len(sealed)))
+	}
+	if version := sealed[0]; version != sealedNoteVersion {
+		return "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported sealed note version: %d", version))
+	}
+	privateKey, err := cryptoutil.KeySliceToArray(recipientPriv)
+	if err != nil {
+		return "", errcode.ErrInvalidInput.Wrap(err)
+	}
+	ephemeralPK, err := cryptoutil.KeySliceToArray(sealed[1 : 1+cryptoutil.KeySize])
+	if err != nil {
+		return "", err
+	}
+	nonce, err := cryptoutil.NonceSliceToArray(sealed[1+cryptoutil.KeySize : 1+cryptoutil.KeySize+cryptoutil.NonceSize])
+	if err != nil {
+		return "", err
+	}
+
+	note, ok := box.Open(nil, sealed[1+cryptoutil.KeySize+cryptoutil.NonceSize:], nonce, ephemeralPK, privateKey)
+	if !ok {
+		return "", errcode.ErrCryptoDecrypt
+	}
+	return string(note), nil
+}
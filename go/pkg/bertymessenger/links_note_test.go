@@ -0,0 +1,69 @@
+package bertymessenger_test
+
+import (
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/box"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkMarshalWithNoteFor(t *testing.T) {
+	const note = "Hi Alice, it's Bob from the conference"
+
+	recipientPK, recipientSK, err := box.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	_, otherSK, err := box.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+
+	link := testContactLink()
+	internal, web, err := link.MarshalWithNoteFor(recipientPK[:], note)
+	require.NoError(t, err)
+
+	// the input link is left untouched
+	require.Empty(t, link.SealedNote)
+
+	parsed, err := bertymessenger.UnmarshalLink(internal)
+	require.NoError(t, err)
+	require.NotEmpty(t, parsed.SealedNote)
+	require.NotContains(t, string(parsed.SealedNote), note)
+
+	decrypted, err := parsed.DecryptNote(recipientSK[:])
+	require.NoError(t, err)
+	require.Equal(t, note, decrypted)
+
+	// wrong key
+	decrypted, err = parsed.DecryptNote(otherSK[:])
+	require.True(t, errcode.Is(err, errcode.ErrCryptoDecrypt))
+	require.Empty(t, decrypted)
+
+	// the web link doesn't carry the note
+	parsed, err = bertymessenger.UnmarshalLink(web)
+	require.NoError(t, err)
+	require.Empty(t, parsed.SealedNote)
+	_, err = parsed.DecryptNote(recipientSK[:])
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+}
+
+func TestLinkMarshalWithNoteForInvalid(t *testing.T) {
+	recipientPK, _, err := box.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	link := testContactLink()
+
+	_, _, err = link.MarshalWithNoteFor(recipientPK[:], "")
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+	_, _, err = link.MarshalWithNoteFor(nil, "hello")
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+	_, _, err = link.MarshalWithNoteFor(recipientPK[:16], "hello")
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	tooLong := make([]byte, bertymessenger.MaxSealedNoteLength+1)
+	for i := range tooLong {
+		tooLong[i] = 'a'
+	}
+	_, _, err = link.MarshalWithNoteFor(recipientPK[:], string(tooLong))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
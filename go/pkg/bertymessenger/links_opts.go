@@ -0,0 +1,375 @@
+package bertymessenger
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// DefaultURLLengthLimit is the default maximum length of a generated web link.
+//
+// Some browsers and OS intent handlers silently truncate longer URLs.
+const DefaultURLLengthLimit = 2000
+
+// MarshalOptions configures how BertyLink.Marshal generates the shareable URLs.
+type MarshalOptions struct {
+	// URLLengthLimit is the maximum length of the generated web link.
+	URLLengthLimit int
+	// WithoutGroupSecret removes the secret of the group from the generated links (see WithoutGroupSecret).
+	WithoutGroupSecret bool
+	// AnalyticsTag is added to the web link only (see WithAnalyticsTag).
+	AnalyticsTag string
+	// GroupedPayloadLen is the length of the groups of the internal link payload (see WithGroupedPayload).
+	GroupedPayloadLen int
+	// ScanChecksum appends a checksum to the internal link payload (see WithScanChecksum).
+	ScanChecksum bool
+	// CompressPayload deflates the large internal link payloads (see WithCompressedPayload).
+	CompressPayload bool
+	// StripDisplayName removes the display names from the generated links (see WithoutDisplayName).
+	StripDisplayName bool
+	// WebPrefix is the prefix of the generated web link, LinkWebPrefix by default (see WithWebPrefix).
+	WebPrefix string
+	// AllowNonMultiMember allows the groups that are not multi-member groups (see WithAllowNonMultiMember).
+	AllowNonMultiMember bool
+	// WebEncoding is the encoding of the binary part of the web link, base58 by default (see WithWebEncoding).
+	WebEncoding LinkWebEncoding
+	// EmitLegacy makes MarshalWithOptions also return the web link in the base58 encoding (see WithEmitLegacy).
+	EmitLegacy bool
+
+	// kindOptions are the options that were set and only apply to a single kind.
+	kindOptions []kindOption
+}
+
+// kindOption is a marshal option that only applies to a single kind, i.e., WithoutGroupSecret.
+type kindOption struct {
+	name string
+	kind BertyLink_Kind
+}
+
+// MarshalOption is a functional option for BertyLink.Marshal.
+type MarshalOption func(*MarshalOptions) error
+
+func (opts *MarshalOptions) applyDefaults() {
+	if opts.URLLengthLimit == 0 {
+		opts.URLLengthLimit = DefaultURLLengthLimit
+	}
+	if opts.WebPrefix == "" {
+		opts.WebPrefix = LinkWebPrefix
+	}
+}
+
+// requireKind registers that the option called name only applies to the links of the given kind.
+func (opts *MarshalOptions) requireKind(name string, kind BertyLink_Kind) {
+	opts.kindOptions = append(opts.kindOptions, kindOption{name: name, kind: kind})
+}
+
+// checkKind returns an error if one of the options doesn't apply to the links of the given kind,
+// so the mistakes of the callers are caught instead of silently ignored.
+func (opts *MarshalOptions) checkKind(kind BertyLink_Kind) error {
+	for _, opt := range opts.kindOptions {
+		if opt.kind != kind {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("%s only applies to %q links, not to %q links", opt.name, opt.kind, kind))
+		}
+	}
+	return nil
+}
+
+func newMarshalOptions(opts []MarshalOption) (MarshalOptions, error) {
+	ret := MarshalOptions{}
+	for _, opt := range opts {
+		if err := opt(&ret); err != nil {
+			return ret, err
+		}
+	}
+	if ret.ScanChecksum && ret.CompressPayload {
+		return ret, errcode.ErrInvalidInput.Wrap(fmt.Errorf("WithScanChecksum and WithCompressedPayload can't be combined"))
+	}
+	ret.applyDefaults()
+	return ret, nil
+}
+
+// WithURLLengthLimit makes Marshal fail with ErrLinkTooLarge if the web link is longer than n chars.
+func WithURLLengthLimit(n int) MarshalOption {
+	return func(opts *MarshalOptions) error {
+		if n <= 0 {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid URL length limit: %d", n))
+		}
+		opts.URLLengthLimit = n
+		return nil
+	}
+}
+
+// WithWebPrefix makes Marshal generate the web link with prefix instead of LinkWebPrefix, i.e., for the self-hosted
+// deployments whose links point to their own domain ("https://chat.example.org/id#").
+//
+// The prefix must be an HTTPS URL with the "/id" path, ending with the '#' fragment separator, since UnmarshalLink
+// only decodes the web links in the "https://{host}/id#..." form. The internal link is not affected.
+func WithWebPrefix(prefix string) MarshalOption {
+	return func(opts *MarshalOptions) error {
+		if !strings.HasSuffix(prefix, "#") {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("web prefix should end with '#': %q", prefix))
+		}
+		parsed, err := url.Parse(prefix)
+		if err != nil {
+			return errcode.ErrInvalidInput.Wrap(err)
+		}
+		if start, ok := webLinkFragmentStart(prefix); !ok || start != len(prefix) || parsed.Host == "" {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("web prefix should be in the \"https://{host}/id#\" form: %q", prefix))
+		}
+		opts.WebPrefix = prefix
+		return nil
+	}
+}
+
+// WithWebEncoding makes Marshal encode the binary part of the web link with encoding instead of base58,
+// i.e., WebEncodingBase64URL for the integrations embedding the links in other systems.
+//
+// The encoding is described by the first char of the binary part, so UnmarshalLink detects it. The base58 links stay
+// unprefixed, but the older versions of Berty can't decode the other encodings. The internal link is not affected.
+func WithWebEncoding(encoding LinkWebEncoding) MarshalOption {
+	return func(opts *MarshalOptions) error {
+		if _, ok := linkWebEncodingNames[encoding]; !ok {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("unsupported web encoding: %s", encoding))
+		}
+		opts.WebEncoding = encoding
+		return nil
+	}
+}
+
+// WithEmitLegacy makes MarshalWithOptions also return the web link in the format of the previous versions of Berty,
+// i.e., in base58 when WithWebEncoding selects another encoding. Marshal ignores it.
+//
+// It is deprecated from the start, see MarshalWithOptions for its removal date.
+func WithEmitLegacy() MarshalOption {
+	return func(opts *MarshalOptions) error {
+		opts.EmitLegacy = true
+		return nil
+	}
+}
+
+// WithoutGroupSecret makes Marshal remove the secret and the secret signature of the group from the links,
+// i.e., to advertise a group without allowing to join it.
+//
+// It only applies to the group links, Marshal returns ErrInvalidInput if it is used with any other kind.
+func WithoutGroupSecret() MarshalOption {
+	return func(opts *MarshalOptions) error {
+		opts.WithoutGroupSecret = true
+		opts.requireKind("WithoutGroupSecret", BertyLink_GroupV1Kind)
+		return nil
+	}
+}
+
+// WithGroupedPayload makes Marshal split the payload of the internal link into groups of groupLen chars,
+// separated by '+', like a product key (i.e., "BERTY://PB1/XXXX+XXXX+XX"), to ease its manual typing.
+//
+// UnmarshalLink ignores the delimiters, the QR codes of the links should still be generated without this option,
+// since the delimiters make them larger.
+func WithGroupedPayload(groupLen int) MarshalOption {
+	return func(opts *MarshalOptions) error {
+		if groupLen <= 0 {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid payload group length: %d", groupLen))
+		}
+		opts.GroupedPayloadLen = groupLen
+		return nil
+	}
+}
+
+// WithoutDisplayName makes Marshal remove the display name of the contact or of the groups from both links,
+// i.e., for a QR code shown on a shared screen.
+func WithoutDisplayName() MarshalOption {
+	return func(opts *MarshalOptions) error {
+		opts.StripDisplayName = true
+		return nil
+	}
+}
+
+// WithScanChecksum makes Marshal append a CRC32 checksum of the payload to the internal link, in the "BERTY://PC/..."
+// form, so the chars dropped or altered by a QR scan are detected instead of being decoded as garbage.
+//
+// UnmarshalLink checks the checksum of these links and returns ErrLinkInvalidScan on mismatch, the links without
+// checksum ("BERTY://PB1/...") are still decoded as before. The older versions of Berty can't decode these links.
+func WithScanChecksum() MarshalOption {
+	return func(opts *MarshalOptions) error {
+		opts.ScanChecksum = true
+		return nil
+	}
+}
+
+// WithCompressedPayload makes Marshal deflate the binary payload of the internal link before encoding it,
+// in the "BERTY://PBZ/..." form, if it is larger than CompressedPayloadThreshold and if the deflated payload
+// is actually smaller, i.e., for the group links with long names or metadata. The other links are generated as usual.
+//
+// It can't be combined with WithScanChecksum. The older versions of Berty can't decode these links.
+func WithCompressedPayload() MarshalOption {
+	return func(opts *MarshalOptions) error {
+		opts.CompressPayload = true
+		return nil
+	}
+}
+
+// WithAllowNonMultiMember makes Marshal accept the group and group set links whose groups are not multi-member
+// groups, i.e., to serialize an account group for the admin, debug, or backup flows.
+//
+// These links are NOT meant to be shared with contacts: the recipients can't join such a group, and IsValid still
+// rejects them. The account and contact groups can carry their secret, so a stored group can be serialized as is,
+// but the groups of an undefined type can't. The actual group type is kept in the links, and restored by UnmarshalLink.
+func WithAllowNonMultiMember() MarshalOption {
+	return func(opts *MarshalOptions) error {
+		opts.AllowNonMultiMember = true
+		return nil
+	}
+}
+
+// MaxAnalyticsTagLength is the maximum length of the tag of WithAnalyticsTag.
+const MaxAnalyticsTagLength = 64
+
+// WithAnalyticsTag makes Marshal add tag to the web link, as the "a" query value, i.e., to measure
+// the audience of the landing page.
+//
+// The tag is web-only, it is never added to the internal link, and it is not decoded by UnmarshalLink.
+// It is meant to be non-sensitive, since it is readable by anyone, and it can be removed with CleanLink.
+func WithAnalyticsTag(tag string) MarshalOption {
+	return func(opts *MarshalOptions) error {
+		if tag == "" || len(tag) > MaxAnalyticsTagLength {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("analytics tag should have from 1 to %d chars", MaxAnalyticsTagLength))
+		}
+		opts.AnalyticsTag = tag
+		return nil
+	}
+}
+
+// UnmarshalOptions configures how UnmarshalLink parses and checks the links.
+type UnmarshalOptions struct {
+	// AllowExpired disables the validity period check (see BertyLink.IsActive).
+	AllowExpired bool
+	// DoubleDecodeFallback enables the decoding of the web links with a double base58-encoded blob.
+	DoubleDecodeFallback bool
+	// LegacyFallback enables the decoding of the v0 contact links (see WithLegacyFallback).
+	LegacyFallback bool
+	// HTMLUnwrap enables the extraction of the links wrapped in an HTML anchor or a markdown link.
+	HTMLUnwrap bool
+	// NestedLinkUnwrap enables the extraction of the links wrapped in the query of another URL (see WithNestedLinkUnwrap).
+	NestedLinkUnwrap bool
+	// MinPoW is the minimal difficulty of the proof-of-work stamp of the links (see WithMinPoW).
+	MinPoW int
+	// InternalSchemes are the accepted schemes of the internal links (see WithInternalSchemes).
+	InternalSchemes []string
+	// MaxLinkSize is the maximum size in bytes of the links and of their decoded payload, DefaultMaxLinkSize if zero
+	// (see UnmarshalLinkWithLimits).
+	MaxLinkSize int
+}
+
+// UnmarshalOption is a functional option for UnmarshalLink.
+type UnmarshalOption func(*UnmarshalOptions) error
+
+func newUnmarshalOptions(opts []UnmarshalOption) (UnmarshalOptions, error) {
+	ret := UnmarshalOptions{}
+	for _, opt := range opts {
+		if err := opt(&ret); err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// internalSchemes returns the accepted schemes of the internal links, the configured one by default.
+func (opts UnmarshalOptions) internalSchemes() []string {
+	if len(opts.InternalSchemes) > 0 {
+		return opts.InternalSchemes
+	}
+	return []string{strings.TrimSuffix(CurrentLinkInternalPrefix(), "://")}
+}
+
+// maxLinkSize returns the maximum size in bytes of the links and of their decoded payload.
+func (opts UnmarshalOptions) maxLinkSize() int {
+	if opts.MaxLinkSize > 0 {
+		return opts.MaxLinkSize
+	}
+	return DefaultMaxLinkSize
+}
+
+// WithAllowExpired makes UnmarshalLink return links that are not yet valid or already expired instead of failing.
+func WithAllowExpired() UnmarshalOption {
+	return func(opts *UnmarshalOptions) error {
+		opts.AllowExpired = true
+		return nil
+	}
+}
+
+// WithDoubleDecodeFallback makes UnmarshalLink try to decode the blob of a web link a second time,
+// if it can't be parsed after the first base58 decoding.
+//
+// This is a compatibility shim for the buggy third-party tools that double-encode the blob,
+// it should only be enabled when importing links generated by such tools.
+func WithDoubleDecodeFallback() UnmarshalOption {
+	return func(opts *UnmarshalOptions) error {
+		opts.DoubleDecodeFallback = true
+		return nil
+	}
+}
+
+// WithLegacyFallback makes UnmarshalLink decode the v0 contact links with UnmarshalLegacyLink, i.e., for the links
+// found in the chat histories of the older apps.
+func WithLegacyFallback() UnmarshalOption {
+	return func(opts *UnmarshalOptions) error {
+		opts.LegacyFallback = true
+		return nil
+	}
+}
+
+// WithHTMLUnwrap makes UnmarshalLink extract the target of an HTML anchor (`<a href="...">text</a>`)
+// or of a markdown link (`[text](...)`) before parsing it, i.e., for the links copied from a rendered chat.
+//
+// The other inputs are parsed as usual, without their surrounding whitespaces.
+func WithHTMLUnwrap() UnmarshalOption {
+	return func(opts *UnmarshalOptions) error {
+		opts.HTMLUnwrap = true
+		return nil
+	}
+}
+
+// WithNestedLinkUnwrap makes UnmarshalLink extract the link wrapped, percent-encoded, in a query value of another URL
+// before parsing it, i.e., for the share-intent and redirection URLs ("https://share.example.org/?u=https%3A%2F%2F...").
+//
+// The URLs wrapping a link in their query are unwrapped too, up to 3 levels, ErrInvalidInput is returned for the
+// deeper ones. The other inputs are parsed as usual.
+func WithNestedLinkUnwrap() UnmarshalOption {
+	return func(opts *UnmarshalOptions) error {
+		opts.NestedLinkUnwrap = true
+		return nil
+	}
+}
+
+// WithMinPoW makes UnmarshalLink reject the links without a proof-of-work stamp of at least the given difficulty,
+// i.e., to ignore mass-generated invites (see BertyLink.MarshalWithPoW).
+func WithMinPoW(difficulty int) UnmarshalOption {
+	return func(opts *UnmarshalOptions) error {
+		if difficulty < 1 || difficulty > MaxPoWDifficulty {
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("PoW difficulty should be between 1 and %d", MaxPoWDifficulty))
+		}
+		opts.MinPoW = difficulty
+		return nil
+	}
+}
+
+// WithInternalSchemes makes UnmarshalLink accept the internal links of the given schemes (without "://",
+// i.e., "MYCHAT" and "BERTY"), instead of the one configured with SetLinkInternalPrefix,
+// i.e., for a white-label build also accepting the links of the official app.
+func WithInternalSchemes(schemes ...string) UnmarshalOption {
+	return func(opts *UnmarshalOptions) error {
+		if len(schemes) == 0 {
+			return errcode.ErrMissingInput.Wrap(fmt.Errorf("missing internal link schemes"))
+		}
+		opts.InternalSchemes = make([]string, len(schemes))
+		for i, scheme := range schemes {
+			valid, err := internalLinkScheme(scheme)
+			if err != nil {
+				return err
+			}
+			opts.InternalSchemes[i] = valid
+		}
+		return nil
+	}
+}
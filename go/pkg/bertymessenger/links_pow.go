@@ -0,0 +1,84 @@
+package bertymessenger
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/gogo/protobuf/proto"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// MaxPoWDifficulty is the maximum difficulty of a proof-of-work stamp (see MarshalWithPoW).
+//
+// Each additional bit doubles the average generation cost, a 32 bits stamp already needs about 4 billion hashes.
+const MaxPoWDifficulty = 32
+
+// MarshalWithPoW is like Marshal, with a proof-of-work stamp of the given difficulty, i.e., to make
+// mass-generating invites costly.
+//
+// Finding the stamp takes 2^difficulty hashes on average, while verifying it only takes one, so the difficulty is
+// a tradeoff: each additional bit doubles the cost for spammers, but also for the legitimate users on slow devices.
+// The recipients can require a minimal difficulty with WithMinPoW.
+//
+// The stamp only depends on the identity of the link (see CanonicalSigningBytes), so editing the metadata
+// doesn't invalidate it.
+func (link *BertyLink) MarshalWithPoW(difficulty int, opts ...MarshalOption) (internal string, web string, err error) {
+	if difficulty < 1 || difficulty > MaxPoWDifficulty {
+		return "", "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("PoW difficulty should be between 1 and %d", MaxPoWDifficulty))
+	}
+	canonical, err := link.CanonicalSigningBytes()
+	if err != nil {
+		return "", "", err
+	}
+
+	stamped := proto.Clone(link).(*BertyLink)
+	nonce := make([]byte, 8)
+	for counter := uint64(0); ; counter++ {
+		binary.BigEndian.PutUint64(nonce, counter)
+		if powLeadingZeros(canonical, nonce) >= difficulty {
+			break
+		}
+	}
+	stamped.PoWNonce = nonce
+	return stamped.Marshal(opts...)
+}
+
+// PoWDifficulty returns the difficulty of the proof-of-work stamp of the link, 0 meaning that it has no stamp.
+func (link *BertyLink) PoWDifficulty() int {
+	if len(link.GetPoWNonce()) == 0 {
+		return 0
+	}
+	canonical, err := link.CanonicalSigningBytes()
+	if err != nil {
+		return 0
+	}
+	return powLeadingZeros(canonical, link.PoWNonce)
+}
+
+// powLeadingZeros returns the number of leading zero bits of sha256(canonical || nonce).
+func powLeadingZeros(canonical, nonce []byte) int {
+	h := sha256.New()
+	_, _ = h.Write(canonical)
+	_, _ = h.Write(nonce)
+	sum := h.Sum(nil)
+
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// checkPoW returns an error if the proof-of-work stamp of the link is below minDifficulty.
+func (link *BertyLink) checkPoW(minDifficulty int) error {
+	if difficulty := link.PoWDifficulty(); difficulty < minDifficulty {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("link PoW difficulty is %d, at least %d is required", difficulty, minDifficulty))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package bertymessenger_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkMarshalWithPoW(t *testing.T) {
+	link := testContactLink()
+	internal, web, err := link.MarshalWithPoW(12)
+	require.NoError(t, err)
+
+	// the input link is left untouched
+	require.Empty(t, link.PoWNonce)
+
+	for _, uri := range []string{internal, web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri, bertymessenger.WithMinPoW(12))
+		require.NoError(t, err)
+		require.NotEmpty(t, parsed.PoWNonce)
+		require.GreaterOrEqual(t, parsed.PoWDifficulty(), 12)
+
+		// the stamp doesn't depend on the metadata
+		parsed.BertyID.DisplayName = "Bob"
+		require.GreaterOrEqual(t, parsed.PoWDifficulty(), 12)
+
+		// but it does on the identity
+		parsed.BertyID.AccountPK = append([]byte{}, parsed.BertyID.AccountPK...)
+		parsed.BertyID.AccountPK[0]++
+		require.Less(t, parsed.PoWDifficulty(), 12)
+	}
+
+	for _, difficulty := range []int{0, bertymessenger.MaxPoWDifficulty + 1} {
+		_, _, err := link.MarshalWithPoW(difficulty)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	}
+	_, _, err = (&bertymessenger.BertyLink{}).MarshalWithPoW(8)
+	require.Error(t, err)
+}
+
+func TestUnmarshalLinkWithMinPoW(t *testing.T) {
+	link := testGroupLink()
+	stamped, _, err := link.MarshalWithPoW(8)
+	require.NoError(t, err)
+	unstamped, _, err := link.Marshal()
+	require.NoError(t, err)
+
+	parsed, err := bertymessenger.UnmarshalLink(unstamped)
+	require.NoError(t, err)
+	require.Zero(t, parsed.PoWDifficulty())
+	_, err = bertymessenger.UnmarshalLink(unstamped, bertymessenger.WithMinPoW(1))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	// under-difficulty link
+	parsed, err = bertymessenger.UnmarshalLink(stamped)
+	require.NoError(t, err)
+	difficulty := parsed.PoWDifficulty()
+	require.GreaterOrEqual(t, difficulty, 8)
+	_, err = bertymessenger.UnmarshalLink(stamped, bertymessenger.WithMinPoW(difficulty))
+	require.NoError(t, err)
+	_, err = bertymessenger.UnmarshalLink(stamped, bertymessenger.WithMinPoW(difficulty+1))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	_, err = bertymessenger.UnmarshalLink(stamped, bertymessenger.WithMinPoW(0))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
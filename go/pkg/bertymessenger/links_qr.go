@@ -0,0 +1,397 @@
+package bertymessenger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// qrRecoveryLevel is the error correction level used for the link QR codes.
+//
+// Medium (~15% of recoverable data) is a good trade-off between the QR size and the resistance to scratches and glares.
+const qrRecoveryLevel = qrcode.Medium
+
+// QRVersion returns the version (from 1 to 40) of the QR code encoding the internal link.
+//
+// The side of a QR code is 17 + 4*version modules, excluding the quiet zone.
+func (link *BertyLink) QRVersion() (int, error) {
+	qr, err := link.qrCode()
+	if err != nil {
+		return 0, err
+	}
+	return qr.VersionNumber, nil
+}
+
+// qrDataCodewords are the numbers of data codewords of the QR codes of each version, from 1 to 40,
+// at the qrRecoveryLevel error correction level (Medium).
+var qrDataCodewords = [MaxQRVersion]int{
+	16, 28, 44, 64, 86, 108, 124, 154, 182, 216,
+	254, 290, 334, 365, 415, 453, 507, 563, 627, 669,
+	714, 782, 860, 914, 1000, 1062, 1128, 1193, 1267, 1373,
+	1455, 1541, 1631, 1725, 1812, 1914, 1992, 2102, 2216, 2334,
+}
+
+// qrAlphanumericBits returns the number of bits of a QR alphanumeric segment of chars chars, header included,
+// in a QR code of the given version.
+func qrAlphanumericBits(chars int, version int) int {
+	countBits := 9
+	switch {
+	case version >= 27:
+		countBits = 13
+	case version >= 10:
+		countBits = 11
+	}
+	return 4 + countBits + 11*(chars/2) + 6*(chars%2)
+}
+
+// EstimateQRSize returns the number of chars of the internal link, and the minimal version of a QR code holding it
+// at the default error correction level, without generating the QR code, i.e., for the UIs warning that
+// the code will be too dense to be scanned by the low-resolution cameras before rendering it.
+//
+// The internal link is encoded as a single alphanumeric segment, so the version is an upper bound of the one
+// of QRVersion, which may split the link into several segments. It returns ErrLinkTooLarge, with the number of
+// chars, if the link doesn't fit in a QR code.
+func (link *BertyLink) EstimateQRSize() (chars int, version int, err error) {
+	internal, err := link.ToInternal()
+	if err != nil {
+		return 0, 0, err
+	}
+	chars = len(internal)
+	for i, codewords := range qrDataCodewords {
+		if qrAlphanumericBits(chars, i+1) <= codewords*8 {
+			return chars, i + 1, nil
+		}
+	}
+	return chars, 0, errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("the link is %d chars long, it doesn't fit in a QR code", chars))
+}
+
+// QRMatrix returns the modules of the QR code encoding the internal link, with the given error correction level,
+// for the apps rendering the code with their own graphics stack.
+//
+// The matrix is indexed by row then column, true being a dark module, and excludes the quiet zone,
+// so its side is 17 + 4*version modules.
+func (link *BertyLink) QRMatrix(level qrcode.RecoveryLevel) (matrix [][]bool, version int, err error) {
+	if level < qrcode.Low || level > qrcode.Highest {
+		return nil, 0, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid QR recovery level: %d", level))
+	}
+
+	qr, err := link.qrCodeWithLevel(level)
+	if err != nil {
+		return nil, 0, err
+	}
+	qr.DisableBorder = true
+	return qr.Bitmap(), qr.VersionNumber, nil
+}
+
+// qrDataLevels are the error correction levels recommended by QRData, by maximum payload length,
+// the short payloads can afford more redundancy without making the QR code hard to scan.
+var qrDataLevels = []struct {
+	maxLength int
+	level     qrcode.RecoveryLevel
+}{
+	{maxLength: 128, level: qrcode.High},
+	{maxLength: 512, level: qrcode.Medium},
+}
+
+// QRData returns the payload of the internal link, without the "BERTY://PB1/" prefix, and the recommended
+// error correction level of its QR code, for the apps generating the QR code with their own library.
+//
+// The payload only contains chars of the QR alphanumeric mode. A scanned payload must be prefixed with
+// "BERTY://PB1/" to be decoded by UnmarshalLink.
+// The recommended level only depends on the payload length, so it is deterministic.
+func (link *BertyLink) QRData() (data string, level qrcode.RecoveryLevel, err error) {
+	// the payload is only meant for a QR code, a web link too long to be generated doesn't matter
+	internal, err := link.MarshalInternalOnly()
+	if err != nil {
+		return "", 0, err
+	}
+	data = strings.TrimPrefix(internal, CurrentLinkInternalPrefix()+internalLinkMarker+"/")
+	return data, qrDataLevel(len(data)), nil
+}
+
+// qrDataLevel returns the recommended error correction level of a QR code, given the length of its payload.
+func qrDataLevel(length int) qrcode.RecoveryLevel {
+	for _, candidate := range qrDataLevels {
+		if length <= candidate.maxLength {
+			return candidate.level
+		}
+	}
+	return qrcode.Low
+}
+
+// QRCodePNG returns the QR code of the internal link, rendered as a PNG image of size x size pixels,
+// including the quiet zone, so the app and the CLI generate the same codes.
+//
+// The error correction level is the one recommended by QRData. The internal link only contains chars of
+// the QR alphanumeric mode, so the code is as small as possible.
+// If size is too small to draw each module with at least one pixel, a larger image is returned.
+func (link *BertyLink) QRCodePNG(size int) ([]byte, error) {
+	if size <= 0 {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid QR image size: %dpx", size))
+	}
+
+	qr, err := link.qrCodeForImage()
+	if err != nil {
+		return nil, err
+	}
+	img, err := qr.PNG(size)
+	if err != nil {
+		return nil, errcode.ErrInternal.Wrap(err)
+	}
+	return img, nil
+}
+
+// QRCodeSVG is QRCodePNG for the web contexts, it returns the QR code of the internal link as a SVG document
+// of size x size pixels, including the quiet zone.
+//
+// The dark modules are drawn as a single path, one sub-path per horizontal run of modules.
+func (link *BertyLink) QRCodeSVG(size int) (string, error) {
+	if size <= 0 {
+		return "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid QR image size: %dpx", size))
+	}
+
+	qr, err := link.qrCodeForImage()
+	if err != nil {
+		return "", err
+	}
+	bitmap := qr.Bitmap()
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", x, y, run, run)
+			x += run
+		}
+	}
+
+	modules := len(bitmap)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		size, size, modules, modules, modules, modules, path.String()), nil
+}
+
+// qrCodeForImage returns the QR code of the internal link, with the error correction level recommended by QRData.
+func (link *BertyLink) qrCodeForImage() (*qrcode.QRCode, error) {
+	if err := link.IsValid(); err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+
+	// the web link isn't needed, nor limited by the web link length
+	internal, err := link.MarshalInternalOnly()
+	if err != nil {
+		return nil, err
+	}
+	data := strings.TrimPrefix(internal, CurrentLinkInternalPrefix()+internalLinkMarker+"/")
+	qr, err := qrcode.New(internal, qrDataLevel(len(data)))
+	if err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+	return qr, nil
+}
+
+func (link *BertyLink) qrCode() (*qrcode.QRCode, error) {
+	return link.qrCodeWithLevel(qrRecoveryLevel)
+}
+
+// qrCodeWithLevel returns the QR code of the internal link, whatever the length of its web link.
+func (link *BertyLink) qrCodeWithLevel(level qrcode.RecoveryLevel) (*qrcode.QRCode, error) {
+	internal, err := link.MarshalInternalOnly()
+	if err != nil {
+		return nil, err
+	}
+	qr, err := qrcode.New(internal, level)
+	if err != nil {
+		return nil, errcode.ErrInvalidInput.Wrap(err)
+	}
+	return qr, nil
+}
+
+// MaxQRVersion is the version of the biggest QR codes.
+const MaxQRVersion = 40
+
+// linkStrippingSteps are the fields that MarshalInternalCapped may remove to fit a QR version,
+// from the least to the most useful for the recipient.
+var linkStrippingSteps = []struct {
+	field string
+	strip func(link *BertyLink) bool
+}{
+	{field: "metadata", strip: func(link *BertyLink) bool {
+		stripped := len(link.Metadata) > 0
+		link.Metadata = nil
+		return stripped
+	}},
+	{field: "regeneration_log", strip: func(link *BertyLink) bool {
+		if link.BertyGroup == nil || len(link.BertyGroup.RegenerationLog) == 0 {
+			return false
+		}
+		link.BertyGroup.RegenerationLog = nil
+		return true
+	}},
+	{field: "referral_code", strip: func(link *BertyLink) bool {
+		if link.BertyID == nil || link.BertyID.ReferralCode == "" {
+			return false
+		}
+		link.BertyID.ReferralCode = ""
+		return true
+	}},
+	{field: "display_name", strip: func(link *BertyLink) bool {
+		stripped := false
+		switch {
+		case link.BertyID != nil && link.BertyID.DisplayName != "":
+			link.BertyID.DisplayName = ""
+			stripped = true
+		case link.BertyGroup != nil && link.BertyGroup.DisplayName != "":
+			link.BertyGroup.DisplayName = ""
+			stripped = true
+		}
+		// the names of the groups of a group set go all at once, the recipient still gets every group
+		for _, group := range link.BertyGroups {
+			if group.GetDisplayName() != "" {
+				group.DisplayName = ""
+				stripped = true
+			}
+		}
+		return stripped
+	}},
+}
+
+// MarshalInternalCapped returns the internal link, in a form whose QR code has a version lower or equal to maxVersion,
+// i.e., for the printers that only support small QR codes.
+//
+// If the link is too large, its payload is compressed first (see WithCompressedPayload), so nothing is lost, but
+// the older versions of Berty can't decode it. If it still doesn't fit, the optional fields are removed one by one,
+// starting with the least useful ones, until the link fits, compressed or not;
+// stripped contains the names of the removed fields, in order.
+// The identity and the validity period of the link are always kept,
+// so ErrLinkTooLarge is returned if even the minimal form doesn't fit.
+func (link *BertyLink) MarshalInternalCapped(maxVersion int) (internal string, stripped []string, err error) {
+	if maxVersion < 1 || maxVersion > MaxQRVersion {
+		return "", nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid QR version: %d", maxVersion))
+	}
+	if link == nil {
+		return "", nil, errcode.ErrMissingInput
+	}
+
+	candidate := proto.Clone(link).(*BertyLink)
+	stripped = []string{}
+	for i := -1; i < len(linkStrippingSteps); i++ {
+		if i >= 0 {
+			if !linkStrippingSteps[i].strip(candidate) {
+				continue
+			}
+			stripped = append(stripped, linkStrippingSteps[i].field)
+		}
+
+		// the uncompressed form is preferred, any version of Berty can decode it
+		for _, opts := range [][]MarshalOption{nil, {WithCompressedPayload()}} {
+			internal, err := candidate.MarshalInternalOnly(opts...)
+			if err != nil {
+				return "", nil, err
+			}
+			// qrcode.New fails if the content doesn't even fit in a version 40 QR code
+			if qr, err := qrcode.New(internal, qrRecoveryLevel); err == nil && qr.VersionNumber <= maxVersion {
+				return internal, stripped, nil
+			}
+		}
+	}
+	return "", nil, errcode.ErrLinkTooLarge.Wrap(fmt.Errorf("the link doesn't fit in a version %d QR code", maxVersion))
+}
+
+// qrModulesPerScanDistance is the number of modules that can be read per unit of scan distance.
+//
+// It comes from the common "10:1" rule (a code can be scanned from 10 times its width),
+// given for a 25-modules code (version 2).
+const qrModulesPerScanDistance = 10 * 25
+
+// RecommendedPrintSizeMM returns the minimum width, in millimeters and excluding the quiet zone,
+// of the printed QR code of the link, so it can be scanned from scanDistanceCM centimeters.
+//
+// The bigger the link, the more modules in the QR code, and the bigger the printed code should be.
+func (link *BertyLink) RecommendedPrintSizeMM(scanDistanceCM float64) (float64, error) {
+	if scanDistanceCM <= 0 {
+		return 0, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid scan distance: %vcm", scanDistanceCM))
+	}
+
+	version, err := link.QRVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	modules := float64(17 + 4*version)
+	moduleSizeMM := scanDistanceCM * 10 / qrModulesPerScanDistance
+	return modules * moduleSizeMM, nil
+}
+
+// QRDimensions returns the width, in pixels, of the QR code of the link, including the quiet zone.
+//
+// The QR code spec requires a quiet zone of at least 4 modules around the code,
+// when quietZoneModules is 0, this default value is used.
+func (link *BertyLink) QRDimensions(moduleSizePx, quietZoneModules int) (int, error) {
+	if moduleSizePx <= 0 {
+		return 0, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid module size: %dpx", moduleSizePx))
+	}
+	if quietZoneModules == 0 {
+		quietZoneModules = qrQuietZone
+	}
+	if quietZoneModules < 0 {
+		return 0, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid quiet zone: %d modules", quietZoneModules))
+	}
+
+	version, err := link.QRVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	modules := 17 + 4*version + 2*quietZoneModules
+	return modules * moduleSizePx, nil
+}
+
+const (
+	// ansiQRColors draws the light modules in white (foreground) and the dark ones in black (background),
+	// so the code is scannable on dark and light terminal themes.
+	ansiQRColors = "\033[97;40m"
+	ansiReset    = "\033[0m"
+)
+
+// MarshalQRTerminal returns the QR code of the internal link, rendered for terminals, i.e., for CLI tools.
+//
+// Each char represents two vertical modules, using the unicode half-block chars and ANSI colors.
+func (link *BertyLink) MarshalQRTerminal() (string, error) {
+	qr, err := link.qrCode()
+	if err != nil {
+		return "", err
+	}
+	bitmap := qr.Bitmap()
+
+	var out strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		out.WriteString(ansiQRColors)
+		for x := range bitmap[y] {
+			topLight := !bitmap[y][x]
+			bottomLight := y+1 < len(bitmap) && !bitmap[y+1][x]
+			switch {
+			case topLight && bottomLight:
+				out.WriteString("█")
+			case topLight:
+				out.WriteString("▀")
+			case bottomLight:
+				out.WriteString("▄")
+			default:
+				out.WriteString(" ")
+			}
+		}
+		out.WriteString(ansiReset + "\n")
+	}
+	return out.String(), nil
+}
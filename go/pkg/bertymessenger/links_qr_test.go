@@ -0,0 +1,576 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkRecommendedPrintSizeMM(t *testing.T) {
+	small := testContactLink()
+	large := testGroupLink()
+	large.BertyGroup.DisplayName = strings.Repeat("The Group ", 20)
+
+	smallVersion, err := small.QRVersion()
+	require.NoError(t, err)
+	largeVersion, err := large.QRVersion()
+	require.NoError(t, err)
+	require.Greater(t, largeVersion, smallVersion)
+
+	for _, distance := range []float64{10, 30, 100} {
+		smallSize, err := small.RecommendedPrintSizeMM(distance)
+		require.NoError(t, err)
+		largeSize, err := large.RecommendedPrintSizeMM(distance)
+		require.NoError(t, err)
+		require.Greater(t, largeSize, smallSize)
+	}
+
+	// the size grows with the distance
+	near, err := small.RecommendedPrintSizeMM(30)
+	require.NoError(t, err)
+	far, err := small.RecommendedPrintSizeMM(60)
+	require.NoError(t, err)
+	require.InDelta(t, 2*near, far, 0.001)
+
+	_, err = small.RecommendedPrintSizeMM(0)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	_, err = (&bertymessenger.BertyLink{}).RecommendedPrintSizeMM(30)
+	require.Error(t, err)
+}
+
+func TestLinkMarshalInternalCapped(t *testing.T) {
+	t.Run("fits", func(t *testing.T) {
+		link := testContactLink()
+		version, err := link.QRVersion()
+		require.NoError(t, err)
+		expected, _, err := link.Marshal()
+		require.NoError(t, err)
+
+		internal, stripped, err := link.MarshalInternalCapped(version)
+		require.NoError(t, err)
+		require.Empty(t, stripped)
+		require.Equal(t, expected, internal)
+	})
+
+	t.Run("fits-compressed", func(t *testing.T) {
+		link := testGroupLink()
+		link.Metadata = map[string]string{"description": strings.Repeat("A long group description. ", 10)}
+		fullVersion, err := link.QRVersion()
+		require.NoError(t, err)
+		compressed, err := link.MarshalInternalOnly(bertymessenger.WithCompressedPayload())
+		require.NoError(t, err)
+		qr, err := qrcode.New(compressed, qrcode.Medium)
+		require.NoError(t, err)
+		require.Greater(t, fullVersion, qr.VersionNumber)
+
+		// nothing is stripped, the compression is enough
+		internal, stripped, err := link.MarshalInternalCapped(qr.VersionNumber)
+		require.NoError(t, err)
+		require.Empty(t, stripped)
+		require.True(t, strings.HasPrefix(internal, "BERTY://PBZ/"), internal)
+		parsed, err := bertymessenger.UnmarshalLink(internal)
+		require.NoError(t, err)
+		require.Equal(t, link, parsed)
+	})
+
+	t.Run("fits-without-metadata", func(t *testing.T) {
+		// random metadata, so the compression doesn't help
+		description := make([]byte, 150)
+		_, err := crand.Read(description)
+		require.NoError(t, err)
+		link := testGroupLink()
+		link.Metadata = map[string]string{"description": base64.StdEncoding.EncodeToString(description)}
+		withoutMetadata := testGroupLink()
+		minVersion, err := withoutMetadata.QRVersion()
+		require.NoError(t, err)
+		fullVersion, err := link.QRVersion()
+		require.NoError(t, err)
+		require.Greater(t, fullVersion, minVersion)
+
+		internal, stripped, err := link.MarshalInternalCapped(minVersion)
+		require.NoError(t, err)
+		require.Equal(t, []string{"metadata"}, stripped)
+		parsed, err := bertymessenger.UnmarshalLink(internal)
+		require.NoError(t, err)
+		require.Equal(t, withoutMetadata, parsed)
+
+		// the input link is left untouched
+		require.NotEmpty(t, link.Metadata)
+	})
+
+	t.Run("group-set-without-names", func(t *testing.T) {
+		groups := make([]*bertymessenger.BertyGroup, 3)
+		for i := range groups {
+			groups[i] = testGroupLink().BertyGroup
+			g := groups[i].Group
+			// random keys and names, so the compression doesn't help
+			for _, key := range [][]byte{g.PublicKey, g.Secret, g.SecretSig, g.SignPub} {
+				_, err := crand.Read(key)
+				require.NoError(t, err)
+			}
+			name := make([]byte, 30)
+			_, err := crand.Read(name)
+			require.NoError(t, err)
+			groups[i].DisplayName = base64.StdEncoding.EncodeToString(name)
+		}
+		link := &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_GroupSetV1Kind, BertyGroups: groups}
+		withoutNames := proto.Clone(link).(*bertymessenger.BertyLink)
+		for _, group := range withoutNames.BertyGroups {
+			group.DisplayName = ""
+		}
+		minVersion, err := withoutNames.QRVersion()
+		require.NoError(t, err)
+		fullVersion, err := link.QRVersion()
+		require.NoError(t, err)
+		require.Greater(t, fullVersion, minVersion)
+
+		internal, stripped, err := link.MarshalInternalCapped(minVersion)
+		require.NoError(t, err)
+		require.Equal(t, []string{"display_name"}, stripped)
+		parsed, err := bertymessenger.UnmarshalLink(internal)
+		require.NoError(t, err)
+		require.Equal(t, withoutNames, parsed)
+	})
+
+	t.Run("too-large", func(t *testing.T) {
+		link := testGroupLink()
+		link.Metadata = map[string]string{"description": "A group"}
+		_, _, err := link.MarshalInternalCapped(1)
+		require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+	})
+
+	for _, version := range []int{0, bertymessenger.MaxQRVersion + 1} {
+		_, _, err := testContactLink().MarshalInternalCapped(version)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	}
+	_, _, err := (&bertymessenger.BertyLink{}).MarshalInternalCapped(10)
+	require.Error(t, err)
+}
+
+func TestLinkQRDimensions(t *testing.T) {
+	link := testContactLink()
+	version, err := link.QRVersion()
+	require.NoError(t, err)
+	modules := 17 + 4*version
+
+	size, err := link.QRDimensions(10, 0)
+	require.NoError(t, err)
+	require.Equal(t, (modules+2*4)*10, size, "default quiet zone is 4 modules")
+
+	size, err = link.QRDimensions(3, 2)
+	require.NoError(t, err)
+	require.Equal(t, (modules+2*2)*3, size)
+
+	// the dimensions match the bitmap generated by the QR library
+	internal, _, err := link.Marshal()
+	require.NoError(t, err)
+	qr, err := qrcode.New(internal, qrcode.Medium)
+	require.NoError(t, err)
+	size, err = link.QRDimensions(1, 0)
+	require.NoError(t, err)
+	require.Len(t, qr.Bitmap(), size)
+
+	_, err = link.QRDimensions(0, 4)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = link.QRDimensions(10, -1)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestLinkMarshalQRCard(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			const moduleSize = 4
+			card, err := link.MarshalQRCard(bertymessenger.QRCardOptions{
+				ModuleSize: moduleSize,
+				Foreground: color.RGBA{R: 0x10, G: 0x20, B: 0x60, A: 0xff},
+			})
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(card))
+			require.NoError(t, err)
+
+			// decode the QR region of the card, and compare it with the expected QR code
+			internal, _, err := link.Marshal()
+			require.NoError(t, err)
+			qr, err := qrcode.New(internal, qrcode.Medium)
+			require.NoError(t, err)
+			expected := qr.Bitmap()
+			bounds := img.Bounds()
+			qrSize := len(expected) * moduleSize
+			require.Greater(t, bounds.Dy(), qrSize, "the caption should be below the QR code")
+			offset := (bounds.Dx() - qrSize) / 2
+			for y, row := range expected {
+				for x, black := range row {
+					r, g, b, _ := img.At(offset+x*moduleSize+moduleSize/2, y*moduleSize+moduleSize/2).RGBA()
+					dark := r+g+b < 3*0x7fff
+					require.Equal(t, black, dark, "module x=%d y=%d", x, y)
+				}
+			}
+
+			// the matched QR code contains the internal link
+			parsed, err := bertymessenger.UnmarshalLink(internal)
+			require.NoError(t, err)
+			require.Equal(t, link, parsed)
+		})
+	}
+
+	t.Run("low-contrast", func(t *testing.T) {
+		_, err := testContactLink().MarshalQRCard(bertymessenger.QRCardOptions{Foreground: color.Gray{Y: 0xcc}})
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	})
+
+	t.Run("inverted-colors", func(t *testing.T) {
+		_, err := testContactLink().MarshalQRCard(bertymessenger.QRCardOptions{Foreground: color.White, Background: color.Black})
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	})
+}
+
+func TestMarshalQRSpriteSheet(t *testing.T) {
+	links := []*bertymessenger.BertyLink{testContactLink(), testGroupLink(), testContactLink()}
+	links[2].BertyID.DisplayName = "Bob"
+	const (
+		cols     = 2
+		cellSize = 200
+	)
+
+	sheet, err := bertymessenger.MarshalQRSpriteSheet(links, cols, cellSize)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(sheet))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, cols*cellSize, 2*cellSize), img.Bounds())
+
+	// decode every cell, and compare it with the QR code of its source link
+	for i, link := range links {
+		internal, _, err := link.Marshal()
+		require.NoError(t, err)
+		qr, err := qrcode.New(internal, qrcode.Medium)
+		require.NoError(t, err)
+		expected := qr.Bitmap()
+		moduleSize := cellSize / len(expected)
+		padding := (cellSize - len(expected)*moduleSize) / 2
+		originX, originY := (i%cols)*cellSize+padding, (i/cols)*cellSize+padding
+		for y, row := range expected {
+			for x, black := range row {
+				r, g, b, _ := img.At(originX+x*moduleSize+moduleSize/2, originY+y*moduleSize+moduleSize/2).RGBA()
+				dark := r+g+b < 3*0x7fff
+				require.Equal(t, black, dark, "link %d, module x=%d y=%d", i, x, y)
+			}
+		}
+
+		parsed, err := bertymessenger.UnmarshalLink(internal)
+		require.NoError(t, err)
+		require.Equal(t, link, parsed)
+	}
+
+	// the empty cells are blank
+	r, g, b, _ := img.At(cols*cellSize-1, 2*cellSize-1).RGBA()
+	require.Equal(t, uint32(3*0xffff), r+g+b)
+
+	t.Run("invalid-link", func(t *testing.T) {
+		invalid := []*bertymessenger.BertyLink{testContactLink(), {Kind: bertymessenger.BertyLink_GroupV1Kind}}
+		_, err := bertymessenger.MarshalQRSpriteSheet(invalid, cols, cellSize)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		require.Contains(t, err.Error(), "link 1")
+	})
+
+	t.Run("cell-too-small", func(t *testing.T) {
+		_, err := bertymessenger.MarshalQRSpriteSheet(links, cols, 20)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		require.Contains(t, err.Error(), "link 0")
+	})
+
+	t.Run("invalid-layout", func(t *testing.T) {
+		_, err := bertymessenger.MarshalQRSpriteSheet(links, 0, cellSize)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		_, err = bertymessenger.MarshalQRSpriteSheet(links, cols, 0)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		_, err = bertymessenger.MarshalQRSpriteSheet(nil, cols, cellSize)
+		require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+	})
+}
+
+func TestLinkMarshalQRTerminal(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			out, err := link.MarshalQRTerminal()
+			require.NoError(t, err)
+			require.NotEmpty(t, out)
+
+			// decode the rendered modules, and compare them with the expected QR code
+			internal, _, err := link.Marshal()
+			require.NoError(t, err)
+			qr, err := qrcode.New(internal, qrcode.Medium)
+			require.NoError(t, err)
+			expected := qr.Bitmap()
+
+			ansi := regexp.MustCompile("\x1b\\[[0-9;]*m")
+			lines := strings.Split(strings.TrimSuffix(ansi.ReplaceAllString(out, ""), "\n"), "\n")
+			require.Len(t, lines, (len(expected)+1)/2)
+			decoded := [][]bool{}
+			for _, line := range lines {
+				top, bottom := []bool{}, []bool{}
+				for _, r := range line {
+					switch r {
+					case '█':
+						top, bottom = append(top, false), append(bottom, false)
+					case '▀':
+						top, bottom = append(top, false), append(bottom, true)
+					case '▄':
+						top, bottom = append(top, true), append(bottom, false)
+					case ' ':
+						top, bottom = append(top, true), append(bottom, true)
+					default:
+						t.Fatalf("unexpected char: %q", r)
+					}
+				}
+				decoded = append(decoded, top, bottom)
+			}
+			require.Equal(t, expected, decoded[:len(expected)])
+
+			parsed, err := bertymessenger.UnmarshalLink(internal)
+			require.NoError(t, err)
+			require.Equal(t, link, parsed)
+		})
+	}
+
+	_, err := (&bertymessenger.BertyLink{}).MarshalQRTerminal()
+	require.Error(t, err)
+}
+
+func TestLinkQRMatrix(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			for _, level := range []qrcode.RecoveryLevel{qrcode.Low, qrcode.Medium, qrcode.High, qrcode.Highest} {
+				matrix, version, err := link.QRMatrix(level)
+				require.NoError(t, err)
+				require.Len(t, matrix, 17+4*version)
+				for _, row := range matrix {
+					require.Len(t, row, 17+4*version)
+				}
+
+				// render the matrix with a 4-modules quiet zone, and compare it with the reference QR code
+				internal, _, err := link.Marshal()
+				require.NoError(t, err)
+				qr, err := qrcode.New(internal, level)
+				require.NoError(t, err)
+				require.Equal(t, qr.VersionNumber, version)
+				expected := qr.Bitmap()
+				rendered := make([][]bool, len(matrix)+8)
+				for y := range rendered {
+					rendered[y] = make([]bool, len(matrix)+8)
+					if y >= 4 && y < len(matrix)+4 {
+						copy(rendered[y][4:], matrix[y-4])
+					}
+				}
+				require.Equal(t, expected, rendered)
+			}
+
+			// the default level is the one of the other QR variants
+			_, version, err := link.QRMatrix(qrcode.Medium)
+			require.NoError(t, err)
+			expectedVersion, err := link.QRVersion()
+			require.NoError(t, err)
+			require.Equal(t, expectedVersion, version)
+		})
+	}
+
+	_, _, err := testContactLink().QRMatrix(qrcode.RecoveryLevel(42))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, _, err = (&bertymessenger.BertyLink{}).QRMatrix(qrcode.Medium)
+	require.Error(t, err)
+}
+
+func TestLinkQRData(t *testing.T) {
+	message := &bertymessenger.BertyLink{
+		Kind:            bertymessenger.BertyLink_MessageV1Kind,
+		BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32)},
+	}
+	large := testGroupLink()
+	large.Metadata = map[string]string{"description": strings.Repeat("A group about everything. ", 20)}
+
+	cases := []struct {
+		name          string
+		link          *bertymessenger.BertyLink
+		expectedLevel qrcode.RecoveryLevel
+	}{
+		{"small", message, qrcode.High},
+		{"medium", testGroupLink(), qrcode.Medium},
+		{"large", large, qrcode.Low},
+		{"long-web-link", testLongWebLink(t), qrcode.Low},
+	}
+	alphanumeric := regexp.MustCompile(`^[0-9A-Z $%*+\-./:]+$`)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, level, err := tc.link.QRData()
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedLevel, level)
+			require.Regexp(t, alphanumeric, data)
+
+			internal, err := tc.link.MarshalInternalOnly()
+			require.NoError(t, err)
+			require.Equal(t, bertymessenger.LinkInternalPrefix+"PB1/"+data, internal)
+
+			parsed, err := bertymessenger.UnmarshalLink(bertymessenger.LinkInternalPrefix + "PB1/" + data)
+			require.NoError(t, err)
+			require.Equal(t, tc.link, parsed)
+		})
+	}
+
+	_, _, err := (&bertymessenger.BertyLink{}).QRData()
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+	invalid := testContactLink()
+	invalid.BertyID.AccountPK = nil
+	_, _, err = invalid.QRData()
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+}
+
+// testLongWebLink returns a link whose web link is too long to be generated, while its internal link fits in a QR code.
+func testLongWebLink(t *testing.T) *bertymessenger.BertyLink {
+	link := testGroupLink()
+	// each char is percent-encoded in the web link
+	link.Metadata = map[string]string{"description": strings.Repeat("\u00e9", 400)}
+	_, _, err := link.Marshal()
+	require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+	return link
+}
+
+func TestLinkQRCodeImage(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			internal, _, err := link.Marshal()
+			require.NoError(t, err)
+			_, level, err := link.QRData()
+			require.NoError(t, err)
+			qr, err := qrcode.New(internal, level)
+			require.NoError(t, err)
+			expected := qr.Bitmap()
+
+			const size = 512
+			out, err := link.QRCodePNG(size)
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(out))
+			require.NoError(t, err)
+			require.Equal(t, image.Rect(0, 0, size, size), img.Bounds())
+
+			moduleSize := size / len(expected)
+			offset := (size - moduleSize*len(expected)) / 2
+			for y, row := range expected {
+				for x, black := range row {
+					r, g, b, _ := img.At(offset+x*moduleSize+moduleSize/2, offset+y*moduleSize+moduleSize/2).RGBA()
+					dark := r+g+b < 3*0x7fff
+					require.Equal(t, black, dark, "module x=%d y=%d", x, y)
+				}
+			}
+
+			svg, err := link.QRCodeSVG(size)
+			require.NoError(t, err)
+			require.NoError(t, xml.Unmarshal([]byte(svg), new(interface{})))
+			require.Contains(t, svg, fmt.Sprintf(`width="%d" height="%d" viewBox="0 0 %d %d"`, size, size, len(expected), len(expected)))
+			darkModules := 0
+			for _, row := range expected {
+				for _, black := range row {
+					if black {
+						darkModules++
+					}
+				}
+			}
+			svgModules := 0
+			for _, run := range regexp.MustCompile(`M\d+ \d+h(\d+)v1`).FindAllStringSubmatch(svg, -1) {
+				n, err := strconv.Atoi(run[1])
+				require.NoError(t, err)
+				svgModules += n
+			}
+			require.Equal(t, darkModules, svgModules)
+		})
+	}
+
+	// the length of the web link doesn't matter
+	long := testLongWebLink(t)
+	_, err := long.QRCodePNG(512)
+	require.NoError(t, err)
+	_, err = long.QRCodeSVG(512)
+	require.NoError(t, err)
+
+	_, err = testContactLink().QRCodePNG(0)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = testContactLink().QRCodeSVG(-1)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	invalid := testContactLink()
+	invalid.BertyID.AccountPK = nil
+	_, err = invalid.QRCodePNG(256)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = invalid.QRCodeSVG(256)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestLinkQRLongWebLink(t *testing.T) {
+	// the QR codes are the ones of the internal link, the web link doesn't matter
+	link := testLongWebLink(t)
+	_, estimated, err := link.EstimateQRSize()
+	require.NoError(t, err)
+	version, err := link.QRVersion()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, estimated, version)
+
+	_, matrixVersion, err := link.QRMatrix(qrcode.Medium)
+	require.NoError(t, err)
+	require.Equal(t, version, matrixVersion)
+	_, err = link.RecommendedPrintSizeMM(30)
+	require.NoError(t, err)
+	_, err = link.QRDimensions(4, 4)
+	require.NoError(t, err)
+	_, err = link.MarshalQRTerminal()
+	require.NoError(t, err)
+}
+
+func TestLinkEstimateQRSize(t *testing.T) {
+	large := testGroupLink()
+	large.BertyGroup.DisplayName = strings.Repeat("The Group ", 20)
+	larger := testGroupLink()
+	larger.Metadata = map[string]string{"description": strings.Repeat("A group about everything. ", 40)}
+
+	previous := 0
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink(), large, larger} {
+		internal, err := link.ToInternal()
+		require.NoError(t, err)
+		chars, version, err := link.EstimateQRSize()
+		require.NoError(t, err)
+		require.Equal(t, len(internal), chars)
+
+		// the estimate is an upper bound of the actual version, and close to it
+		actual, err := link.QRVersion()
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, version, actual, link.Kind)
+		require.LessOrEqual(t, version, actual+1, link.Kind)
+
+		require.GreaterOrEqual(t, version, previous)
+		previous = version
+	}
+
+	tooLarge := testGroupLink()
+	tooLarge.Metadata = map[string]string{"description": strings.Repeat("A group about everything. ", 120)}
+	chars, version, err := tooLarge.EstimateQRSize()
+	require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+	require.Greater(t, chars, 3000)
+	require.Zero(t, version)
+
+	_, _, err = (&bertymessenger.BertyLink{}).EstimateQRSize()
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+}
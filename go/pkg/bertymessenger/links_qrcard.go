@@ -0,0 +1,377 @@
+package bertymessenger
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+const (
+	// DefaultContactQRCardTagline is the default tagline of the contact QR cards.
+	DefaultContactQRCardTagline = "Scan to add me on Berty"
+	// DefaultGroupQRCardTagline is the default tagline of the group QR cards.
+	DefaultGroupQRCardTagline = "Scan to join the group on Berty"
+
+	defaultQRCardModuleSize = 8
+
+	// qrCardMinContrast is the minimal contrast ratio between the QR foreground and background colors.
+	//
+	// see https://www.w3.org/TR/WCAG20/#contrast-ratiodef
+	qrCardMinContrast = 4.5
+
+	// qrQuietZone is the width, in modules, of the quiet zone around a QR code.
+	qrQuietZone = 4
+)
+
+// QRCardFont renders the caption lines of a QR card.
+//
+// It can be implemented on top of any font rendering library, i.e., golang.org/x/image/font.
+type QRCardFont interface {
+	// Measure returns the size in pixels of the rendered text.
+	Measure(text string) image.Point
+	// Draw renders text in dst, with its top-left corner at pt.
+	Draw(dst draw.Image, pt image.Point, text string, c color.Color)
+}
+
+// QRCardOptions configures BertyLink.MarshalQRCard.
+type QRCardOptions struct {
+	// Caption is the first caption line, it defaults to the display name of the link.
+	Caption string
+	// Tagline is the second caption line, it defaults to DefaultContactQRCardTagline or DefaultGroupQRCardTagline.
+	Tagline string
+	// ModuleSize is the size in pixels of a QR module, it defaults to 8.
+	ModuleSize int
+	// Foreground is the color of the QR modules, it defaults to black.
+	Foreground color.Color
+	// Background is the color of the card, it defaults to white.
+	Background color.Color
+	// CaptionColor is the color of the caption lines, it defaults to Foreground.
+	CaptionColor color.Color
+	// Font is used to render the caption lines, it defaults to a builtin bitmap font.
+	Font QRCardFont
+}
+
+func (opts *QRCardOptions) applyDefaults(link *BertyLink) {
+	if opts.Caption == "" {
+		switch link.GetKind() {
+		case BertyLink_ContactInviteV1Kind:
+			opts.Caption = link.GetBertyID().GetDisplayName()
+		case BertyLink_GroupV1Kind:
+			opts.Caption = link.GetBertyGroup().GetDisplayName()
+		}
+	}
+	if opts.Tagline == "" {
+		switch link.GetKind() {
+		case BertyLink_ContactInviteV1Kind:
+			opts.Tagline = DefaultContactQRCardTagline
+		case BertyLink_GroupV1Kind:
+			opts.Tagline = DefaultGroupQRCardTagline
+		}
+	}
+	if opts.ModuleSize == 0 {
+		opts.ModuleSize = defaultQRCardModuleSize
+	}
+	if opts.Foreground == nil {
+		opts.Foreground = color.Black
+	}
+	if opts.Background == nil {
+		opts.Background = color.White
+	}
+	if opts.CaptionColor == nil {
+		opts.CaptionColor = opts.Foreground
+	}
+	if opts.Font == nil {
+		scale := opts.ModuleSize / 4
+		if scale < 1 {
+			scale = 1
+		}
+		opts.Font = bitmapFont{scale: scale}
+	}
+}
+
+// MarshalQRCard renders a PNG share image, with the QR code of the internal link on top
+// and a caption (the display name and a tagline) below.
+//
+// The QR code keeps its quiet zone, and MarshalQRCard returns an ErrInvalidInput error if the
+// foreground and background colors don't have enough contrast for the QR code to be scanned.
+func (link *BertyLink) MarshalQRCard(opts QRCardOptions) ([]byte, error) {
+	opts.applyDefaults(link)
+	if opts.ModuleSize < 0 {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid module size: %d", opts.ModuleSize))
+	}
+	if ratio := contrastRatio(opts.Foreground, opts.Background); ratio < qrCardMinContrast || luminance(opts.Foreground) > luminance(opts.Background) {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("QR colors should be dark on light with a contrast ratio of at least %v:1, got %.2f:1", qrCardMinContrast, ratio))
+	}
+
+	qr, err := link.qrCode()
+	if err != nil {
+		return nil, err
+	}
+	bitmap := qr.Bitmap()
+	qrSize := len(bitmap) * opts.ModuleSize
+
+	// compute the layout
+	lines := []string{}
+	for _, line := range []string{opts.Caption, opts.Tagline} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	margin := qrQuietZone * opts.ModuleSize
+	width, height := qrSize, qrSize
+	sizes := make([]image.Point, len(lines))
+	for i, line := range lines {
+		sizes[i] = opts.Font.Measure(line)
+		if w := sizes[i].X + 2*margin; w > width {
+			width = w
+		}
+		height += sizes[i].Y + sizes[i].Y/2
+	}
+	if len(lines) > 0 {
+		height += margin
+	}
+
+	// draw the card
+	card := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(card, card.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+	drawQRModules(card, bitmap, image.Pt((width-qrSize)/2, 0), opts.ModuleSize, opts.Foreground)
+	y := qrSize
+	for i, line := range lines {
+		opts.Font.Draw(card, image.Pt((width-sizes[i].X)/2, y), line, opts.CaptionColor)
+		y += sizes[i].Y + sizes[i].Y/2
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, card); err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalQRSpriteSheet renders a PNG grid of the QR codes of the internal links, with cols columns
+// of square cells of cellSize pixels, in the order of links.
+//
+// Each QR code keeps its quiet zone and is centered in its cell, with the biggest integer module size that fits,
+// so every cell can be scanned independently.
+// If a link is invalid or does not fit in a cell, the returned error contains its index.
+func MarshalQRSpriteSheet(links []*BertyLink, cols, cellSize int) ([]byte, error) {
+	if len(links) == 0 {
+		return nil, errcode.ErrMissingInput
+	}
+	if cols <= 0 {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid number of columns: %d", cols))
+	}
+	if cellSize <= 0 {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid cell size: %dpx", cellSize))
+	}
+
+	bitmaps := make([][][]bool, len(links))
+	for i, link := range links {
+		qr, err := link.qrCode()
+		if err != nil {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("link %d: %w", i, err))
+		}
+		bitmaps[i] = qr.Bitmap()
+		if len(bitmaps[i]) > cellSize {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("link %d: a %d modules QR code does not fit in a %dpx cell", i, len(bitmaps[i]), cellSize))
+		}
+	}
+
+	if cols > len(links) {
+		cols = len(links)
+	}
+	rows := (len(links) + cols - 1) / cols
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*cellSize, rows*cellSize))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	for i, bitmap := range bitmaps {
+		moduleSize := cellSize / len(bitmap)
+		padding := (cellSize - len(bitmap)*moduleSize) / 2
+		origin := image.Pt((i%cols)*cellSize+padding, (i/cols)*cellSize+padding)
+		drawQRModules(sheet, bitmap, origin, moduleSize, color.Black)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sheet); err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// drawQRModules draws the dark modules of a QR bitmap in dst, with its top-left corner at origin.
+func drawQRModules(dst draw.Image, bitmap [][]bool, origin image.Point, moduleSize int, c color.Color) {
+	fg := image.NewUniform(c)
+	for y, row := range bitmap {
+		for x, black := range row {
+			if !black {
+				continue
+			}
+			module := image.Rect(x*moduleSize, y*moduleSize, (x+1)*moduleSize, (y+1)*moduleSize).Add(origin)
+			draw.Draw(dst, module, fg, image.Point{}, draw.Src)
+		}
+	}
+}
+
+// luminance returns the relative luminance of c, as defined by the WCAG.
+func luminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	linear := func(v uint32) float64 {
+		s := float64(v) / 0xffff
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(r) + 0.7152*linear(g) + 0.0722*linear(b)
+}
+
+func contrastRatio(a, b color.Color) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// bitmapFont is a minimal 5x7 ASCII font, non-ASCII chars are rendered as '?'.
+type bitmapFont struct {
+	scale int
+}
+
+const (
+	bitmapFontWidth   = 5
+	bitmapFontHeight  = 7
+	bitmapFontSpacing = 1
+)
+
+func (f bitmapFont) Measure(text string) image.Point {
+	n := len([]rune(text))
+	if n == 0 {
+		return image.Point{}
+	}
+	return image.Pt((n*(bitmapFontWidth+bitmapFontSpacing)-bitmapFontSpacing)*f.scale, bitmapFontHeight*f.scale)
+}
+
+func (f bitmapFont) Draw(dst draw.Image, pt image.Point, text string, c color.Color) {
+	src := image.NewUniform(c)
+	x := pt.X
+	for _, r := range text {
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		for row, bits := range bitmapFontGlyphs[r-' '] {
+			for col := 0; col < bitmapFontWidth; col++ {
+				if bits&(1<<uint(bitmapFontWidth-1-col)) == 0 {
+					continue
+				}
+				px := image.Rect(x+col*f.scale, pt.Y+row*f.scale, x+(col+1)*f.scale, pt.Y+(row+1)*f.scale)
+				draw.Draw(dst, px, src, image.Point{}, draw.Src)
+			}
+		}
+		x += (bitmapFontWidth + bitmapFontSpacing) * f.scale
+	}
+}
+
+// bitmapFontGlyphs contains the printable ASCII chars, from ' ' to '~', one byte per row.
+var bitmapFontGlyphs = [...][bitmapFontHeight]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, // ' '
+	{0x04, 0x04, 0x04, 0x04, 0x04, 0x00, 0x04}, // '!'
+	{0x0a, 0x0a, 0x0a, 0x00, 0x00, 0x00, 0x00}, // '"'
+	{0x0a, 0x0a, 0x1f, 0x0a, 0x1f, 0x0a, 0x0a}, // '#'
+	{0x04, 0x0f, 0x14, 0x0e, 0x05, 0x1e, 0x04}, // '$'
+	{0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03}, // '%'
+	{0x0c, 0x12, 0x14, 0x08, 0x15, 0x12, 0x0d}, // '&'
+	{0x04, 0x04, 0x04, 0x00, 0x00, 0x00, 0x00}, // '\''
+	{0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02}, // '('
+	{0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08}, // ')'
+	{0x00, 0x04, 0x15, 0x0e, 0x15, 0x04, 0x00}, // '*'
+	{0x00, 0x04, 0x04, 0x1f, 0x04, 0x04, 0x00}, // '+'
+	{0x00, 0x00, 0x00, 0x00, 0x0c, 0x04, 0x08}, // ','
+	{0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00}, // '-'
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c}, // '.'
+	{0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00}, // '/'
+	{0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e}, // '0'
+	{0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e}, // '1'
+	{0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f}, // '2'
+	{0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e}, // '3'
+	{0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02}, // '4'
+	{0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e}, // '5'
+	{0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e}, // '6'
+	{0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08}, // '7'
+	{0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e}, // '8'
+	{0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c}, // '9'
+	{0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00}, // ':'
+	{0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x04, 0x08}, // ';'
+	{0x02, 0x04, 0x08, 0x10, 0x08, 0x04, 0x02}, // '<'
+	{0x00, 0x00, 0x1f, 0x00, 0x1f, 0x00, 0x00}, // '='
+	{0x08, 0x04, 0x02, 0x01, 0x02, 0x04, 0x08}, // '>'
+	{0x0e, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04}, // '?'
+	{0x0e, 0x11, 0x01, 0x0d, 0x15, 0x15, 0x0e}, // '@'
+	{0x0e, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11}, // 'A'
+	{0x1e, 0x11, 0x11, 0x1e, 0x11, 0x11, 0x1e}, // 'B'
+	{0x0e, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0e}, // 'C'
+	{0x1c, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1c}, // 'D'
+	{0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x1f}, // 'E'
+	{0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x10}, // 'F'
+	{0x0e, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0f}, // 'G'
+	{0x11, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11}, // 'H'
+	{0x0e, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e}, // 'I'
+	{0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0c}, // 'J'
+	{0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11}, // 'K'
+	{0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1f}, // 'L'
+	{0x11, 0x1b, 0x15, 0x15, 0x11, 0x11, 0x11}, // 'M'
+	{0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11}, // 'N'
+	{0x0e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e}, // 'O'
+	{0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10}, // 'P'
+	{0x0e, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0d}, // 'Q'
+	{0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11}, // 'R'
+	{0x0f, 0x10, 0x10, 0x0e, 0x01, 0x01, 0x1e}, // 'S'
+	{0x1f, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04}, // 'T'
+	{0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e}, // 'U'
+	{0x11, 0x11, 0x11, 0x11, 0x11, 0x0a, 0x04}, // 'V'
+	{0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0a}, // 'W'
+	{0x11, 0x11, 0x0a, 0x04, 0x0a, 0x11, 0x11}, // 'X'
+	{0x11, 0x11, 0x11, 0x0a, 0x04, 0x04, 0x04}, // 'Y'
+	{0x1f, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1f}, // 'Z'
+	{0x0e, 0x08, 0x08, 0x08, 0x08, 0x08, 0x0e}, // '['
+	{0x00, 0x10, 0x08, 0x04, 0x02, 0x01, 0x00}, // '\\'
+	{0x0e, 0x02, 0x02, 0x02, 0x02, 0x02, 0x0e}, // ']'
+	{0x04, 0x0a, 0x11, 0x00, 0x00, 0x00, 0x00}, // '^'
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1f}, // '_'
+	{0x08, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00}, // '`'
+	{0x00, 0x00, 0x0e, 0x01, 0x0f, 0x11, 0x0f}, // 'a'
+	{0x10, 0x10, 0x16, 0x19, 0x11, 0x11, 0x1e}, // 'b'
+	{0x00, 0x00, 0x0e, 0x10, 0x10, 0x11, 0x0e}, // 'c'
+	{0x01, 0x01, 0x0d, 0x13, 0x11, 0x11, 0x0f}, // 'd'
+	{0x00, 0x00, 0x0e, 0x11, 0x1f, 0x10, 0x0e}, // 'e'
+	{0x06, 0x09, 0x08, 0x1c, 0x08, 0x08, 0x08}, // 'f'
+	{0x00, 0x0f, 0x11, 0x11, 0x0f, 0x01, 0x0e}, // 'g'
+	{0x10, 0x10, 0x16, 0x19, 0x11, 0x11, 0x11}, // 'h'
+	{0x04, 0x00, 0x0c, 0x04, 0x04, 0x04, 0x0e}, // 'i'
+	{0x02, 0x00, 0x06, 0x02, 0x02, 0x12, 0x0c}, // 'j'
+	{0x10, 0x10, 0x12, 0x14, 0x18, 0x14, 0x12}, // 'k'
+	{0x0c, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e}, // 'l'
+	{0x00, 0x00, 0x1a, 0x15, 0x15, 0x11, 0x11}, // 'm'
+	{0x00, 0x00, 0x16, 0x19, 0x11, 0x11, 0x11}, // 'n'
+	{0x00, 0x00, 0x0e, 0x11, 0x11, 0x11, 0x0e}, // 'o'
+	{0x00, 0x00, 0x1e, 0x11, 0x1e, 0x10, 0x10}, // 'p'
+	{0x00, 0x00, 0x0d, 0x13, 0x0f, 0x01, 0x01}, // 'q'
+	{0x00, 0x00, 0x16, 0x19, 0x10, 0x10, 0x10}, // 'r'
+	{0x00, 0x00, 0x0e, 0x10, 0x0e, 0x01, 0x1e}, // 's'
+	{0x08, 0x08, 0x1c, 0x08, 0x08, 0x09, 0x06}, // 't'
+	{0x00, 0x00, 0x11, 0x11, 0x11, 0x13, 0x0d}, // 'u'
+	{0x00, 0x00, 0x11, 0x11, 0x11, 0x0a, 0x04}, // 'v'
+	{0x00, 0x00, 0x11, 0x11, 0x15, 0x15, 0x0a}, // 'w'
+	{0x00, 0x00, 0x11, 0x0a, 0x04, 0x0a, 0x11}, // 'x'
+	{0x00, 0x00, 0x11, 0x11, 0x0f, 0x01, 0x0e}, // 'y'
+	{0x00, 0x00, 0x1f, 0x02, 0x04, 0x08, 0x1f}, // 'z'
+	{0x02, 0x04, 0x04, 0x08, 0x04, 0x04, 0x02}, // '{'
+	{0x04, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04}, // '|'
+	{0x08, 0x04, 0x04, 0x02, 0x04, 0x04, 0x08}, // '}'
+	{0x00, 0x00, 0x08, 0x15, 0x02, 0x00, 0x00}, // '~'
+}
@@ -0,0 +1,82 @@
+package bertymessenger
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// MaxRegenerationLogEntries is the maximum number of entries in the regeneration log of a group link,
+// so the internal link stays small enough to be scanned.
+const MaxRegenerationLogEntries = 8
+
+// AppendRegeneration records a regeneration of the group invite by the member owning regeneratorPK.
+//
+// Only the hash of the public key is stored. The log is append-only, so at can't be older than the last entry,
+// nor before the Unix epoch; when it is full, the oldest entries are dropped.
+func (link *BertyLink) AppendRegeneration(regeneratorPK []byte, at time.Time) error {
+	if link.GetKind() != BertyLink_GroupV1Kind || link.BertyGroup == nil {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("only group links have a regeneration log"))
+	}
+	if len(regeneratorPK) == 0 {
+		return errcode.ErrMissingInput
+	}
+	if at.Unix() <= 0 {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("invalid regeneration time: %s", at.UTC()))
+	}
+
+	// the log is copied, so the links sharing its backing array (i.e., shallow copies) are left untouched
+	previous := link.BertyGroup.RegenerationLog
+	log := make([]*BertyGroup_RegenerationEntry, len(previous), len(previous)+1)
+	copy(log, previous)
+	if n := len(log); n > 0 && at.Unix() < log[n-1].GetRegeneratedAtUnix() {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("the regeneration log is append-only"))
+	}
+	hash := sha256.Sum256(regeneratorPK)
+	log = append(log, &BertyGroup_RegenerationEntry{
+		RegeneratedAtUnix: at.Unix(),
+		RegeneratorPKHash: hash[:],
+	})
+	if len(log) > MaxRegenerationLogEntries {
+		log = log[len(log)-MaxRegenerationLogEntries:]
+	}
+	link.BertyGroup.RegenerationLog = log
+	return nil
+}
+
+// RegenerationHistory returns the entries of the regeneration log of a group link, from the oldest to the most recent.
+//
+// The log is only carried by the internal links, and RegenerationHistory returns an error if one of its entries
+// is invalid.
+func (link *BertyLink) RegenerationHistory() ([]*BertyGroup_RegenerationEntry, error) {
+	if link.GetKind() != BertyLink_GroupV1Kind {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("only group links have a regeneration log"))
+	}
+
+	log := link.GetBertyGroup().GetRegenerationLog()
+	if err := checkRegenerationLog(log); err != nil {
+		return nil, err
+	}
+	history := make([]*BertyGroup_RegenerationEntry, len(log))
+	copy(history, log)
+	return history, nil
+}
+
+func checkRegenerationLog(log []*BertyGroup_RegenerationEntry) error {
+	if len(log) > MaxRegenerationLogEntries {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("the regeneration log should have at most %d entries", MaxRegenerationLogEntries))
+	}
+	for i, entry := range log {
+		switch {
+		case entry.GetRegeneratedAtUnix() <= 0:
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("regeneration %d: invalid timestamp", i))
+		case len(entry.GetRegeneratorPKHash()) != sha256.Size:
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("regeneration %d: invalid regenerator hash", i))
+		case i > 0 && entry.GetRegeneratedAtUnix() < log[i-1].GetRegeneratedAtUnix():
+			return errcode.ErrInvalidInput.Wrap(fmt.Errorf("regeneration %d: the log should be in chronological order", i))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkRegenerationHistory(t *testing.T) {
+	link := testGroupLink()
+	history, err := link.RegenerationHistory()
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	alice, bob := bytes.Repeat([]byte{7}, 32), bytes.Repeat([]byte{8}, 32)
+	start := time.Unix(1600000000, 0)
+	require.NoError(t, link.AppendRegeneration(alice, start))
+	require.NoError(t, link.AppendRegeneration(bob, start.Add(time.Hour)))
+	require.NoError(t, link.AppendRegeneration(alice, start.Add(2*time.Hour)))
+
+	// the log is carried by the internal link only
+	internal, web, err := link.Marshal()
+	require.NoError(t, err)
+	parsed, err := bertymessenger.UnmarshalLink(internal)
+	require.NoError(t, err)
+	require.Equal(t, link, parsed)
+	history, err = parsed.RegenerationHistory()
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	aliceHash, bobHash := sha256.Sum256(alice), sha256.Sum256(bob)
+	require.Equal(t, aliceHash[:], history[0].RegeneratorPKHash)
+	require.Equal(t, bobHash[:], history[1].RegeneratorPKHash)
+	require.Equal(t, start.Add(2*time.Hour).Unix(), history[2].RegeneratedAtUnix)
+
+	parsed, err = bertymessenger.UnmarshalLink(web)
+	require.NoError(t, err)
+	require.Empty(t, parsed.BertyGroup.RegenerationLog)
+
+	t.Run("append-only", func(t *testing.T) {
+		err := link.AppendRegeneration(bob, start)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		require.Len(t, link.BertyGroup.RegenerationLog, 3)
+	})
+
+	t.Run("invalid-time", func(t *testing.T) {
+		for _, at := range []time.Time{{}, time.Unix(0, 0), time.Unix(-1, 0)} {
+			fresh := testGroupLink()
+			require.True(t, errcode.Is(fresh.AppendRegeneration(alice, at), errcode.ErrInvalidInput), at)
+			require.Empty(t, fresh.BertyGroup.RegenerationLog)
+		}
+	})
+
+	t.Run("shared-log", func(t *testing.T) {
+		// two shallow copies sharing the backing array of their log, with room to grow
+		log := make([]*bertymessenger.BertyGroup_RegenerationEntry, 0, 4)
+		first, second := testGroupLink(), testGroupLink()
+		first.BertyGroup.RegenerationLog = log
+		second.BertyGroup.RegenerationLog = log
+
+		require.NoError(t, first.AppendRegeneration(alice, start))
+		require.NoError(t, second.AppendRegeneration(bob, start))
+		aliceHash, bobHash := sha256.Sum256(alice), sha256.Sum256(bob)
+		require.Equal(t, aliceHash[:], first.BertyGroup.RegenerationLog[0].RegeneratorPKHash)
+		require.Equal(t, bobHash[:], second.BertyGroup.RegenerationLog[0].RegeneratorPKHash)
+	})
+
+	t.Run("not-a-group", func(t *testing.T) {
+		contact := testContactLink()
+		require.True(t, errcode.Is(contact.AppendRegeneration(alice, start), errcode.ErrInvalidInput))
+		_, err := contact.RegenerationHistory()
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	})
+}
+
+func TestLinkRegenerationLogCap(t *testing.T) {
+	link := testGroupLink()
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < bertymessenger.MaxRegenerationLogEntries+3; i++ {
+		require.NoError(t, link.AppendRegeneration([]byte{byte(i)}, start.Add(time.Duration(i)*time.Minute)))
+	}
+
+	// the oldest entries are dropped
+	history, err := link.RegenerationHistory()
+	require.NoError(t, err)
+	require.Len(t, history, bertymessenger.MaxRegenerationLogEntries)
+	require.Equal(t, start.Add(3*time.Minute).Unix(), history[0].RegeneratedAtUnix)
+	require.NoError(t, link.IsValid())
+
+	// a crafted link with a too long log is rejected
+	entry := history[0]
+	link.BertyGroup.RegenerationLog = append(link.BertyGroup.RegenerationLog, entry)
+	require.True(t, errcode.Is(link.IsValid(), errcode.ErrInvalidInput))
+	_, err = link.RegenerationHistory()
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, _, err = link.Marshal()
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestLinkRegenerationLogEntries(t *testing.T) {
+	validHash := bytes.Repeat([]byte{1}, sha256.Size)
+	cases := []struct {
+		name            string
+		log             []*bertymessenger.BertyGroup_RegenerationEntry
+		expectedErrcode errcode.ErrCode
+	}{
+		{"valid", []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 1, RegeneratorPKHash: validHash}, {RegeneratedAtUnix: 1, RegeneratorPKHash: validHash}}, -1},
+		{"missing-timestamp", []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratorPKHash: validHash}}, errcode.ErrInvalidInput},
+		{"negative-timestamp", []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: -1, RegeneratorPKHash: validHash}}, errcode.ErrInvalidInput},
+		{"missing-hash", []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 1}}, errcode.ErrInvalidInput},
+		{"short-hash", []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 1, RegeneratorPKHash: validHash[1:]}}, errcode.ErrInvalidInput},
+		{"nil-entry", []*bertymessenger.BertyGroup_RegenerationEntry{nil}, errcode.ErrInvalidInput},
+		{"unordered", []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 2, RegeneratorPKHash: validHash}, {RegeneratedAtUnix: 1, RegeneratorPKHash: validHash}}, errcode.ErrInvalidInput},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link := testGroupLink()
+			link.BertyGroup.RegenerationLog = tc.log
+			_, err := link.RegenerationHistory()
+			require.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
+			require.Equal(t, tc.expectedErrcode.Error(), errcode.Code(link.IsValid()).Error())
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package bertymessenger
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// MarshalSigned is like Marshal, with a signature of the contact link by its account key, so the recipients
+// can check that the link was generated by the owner of the account (see Verified).
+//
+// The signature covers the identity of the link (see CanonicalSigningBytes), so editing the metadata
+// doesn't invalidate it, but replacing the rendezvous seed does.
+// It returns ErrInvalidInput if link is not a contact link or if signer is not the key of its account,
+// and ErrCryptoSignature if signer fails.
+func (link *BertyLink) MarshalSigned(signer crypto.Signer, opts ...MarshalOption) (internal string, web string, err error) {
+	if link.GetKind() != BertyLink_ContactInviteV1Kind {
+		return "", "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("only a contact link can be signed, not a %q link", link.GetKind()))
+	}
+	if signer == nil {
+		return "", "", errcode.ErrMissingInput
+	}
+	canonical, err := link.CanonicalSigningBytes()
+	if err != nil {
+		return "", "", err
+	}
+	if pk, ok := signer.Public().(ed25519.PublicKey); !ok || !bytes.Equal(pk, link.BertyID.AccountPK) {
+		return "", "", errcode.ErrInvalidInput.Wrap(fmt.Errorf("the signer is not the account key of the link"))
+	}
+
+	// ed25519 signs the message itself, without pre-hashing
+	sig, err := signer.Sign(rand.Reader, canonical, crypto.Hash(0))
+	if err != nil {
+		return "", "", errcode.ErrCryptoSignature.Wrap(err)
+	}
+
+	signed := proto.Clone(link).(*BertyLink)
+	signed.Signature = sig
+	return signed.Marshal(opts...)
+}
+
+// Verified returns true if the link carries a valid signature by its account key (see MarshalSigned).
+//
+// UnmarshalLink rejects the links with an invalid signature, so a parsed link is either verified,
+// or unsigned.
+func (link *BertyLink) Verified() bool {
+	if len(link.GetSignature()) == 0 || link.GetKind() != BertyLink_ContactInviteV1Kind {
+		return false
+	}
+	canonical, err := link.CanonicalSigningBytes()
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(link.BertyID.AccountPK, canonical, link.Signature)
+}
+
+// checkSignature returns an error if the link carries a signature that is not valid.
+func (link *BertyLink) checkSignature() error {
+	if len(link.GetSignature()) == 0 || link.Verified() {
+		return nil
+	}
+	return errcode.ErrCryptoSignatureVerification.Wrap(fmt.Errorf("the signature of the link doesn't match its account key"))
+}
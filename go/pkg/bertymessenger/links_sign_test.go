@@ -0,0 +1,106 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkMarshalSigned(t *testing.T) {
+	pk, sk, err := ed25519.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	link := testContactLink()
+	link.BertyID.AccountPK = pk
+
+	internal, web, err := link.MarshalSigned(sk)
+	require.NoError(t, err)
+
+	// the input link is left untouched
+	require.Empty(t, link.Signature)
+	require.False(t, link.Verified())
+
+	var signed *bertymessenger.BertyLink
+	for _, uri := range []string{internal, web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err, uri)
+		require.Len(t, parsed.Signature, ed25519.SignatureSize)
+		require.True(t, parsed.Verified(), uri)
+		signed = parsed
+	}
+
+	// the metadata are not signed
+	edited := proto.Clone(signed).(*bertymessenger.BertyLink)
+	edited.BertyID.DisplayName = "Bob"
+	require.True(t, edited.Verified())
+
+	// the unsigned links are still accepted
+	internal, web, err = link.Marshal()
+	require.NoError(t, err)
+	for _, uri := range []string{internal, web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err, uri)
+		require.False(t, parsed.Verified())
+	}
+
+	// a seed rotation drops the signature
+	rotated, err := signed.WithRendezvousSeed(bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+	require.Empty(t, rotated.Signature)
+}
+
+func TestUnmarshalLinkSignature(t *testing.T) {
+	pk, sk, err := ed25519.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	link := testContactLink()
+	link.BertyID.AccountPK = pk
+	internal, _, err := link.MarshalSigned(sk)
+	require.NoError(t, err)
+	signed, err := bertymessenger.UnmarshalLink(internal)
+	require.NoError(t, err)
+
+	// tampered payload
+	tampered := proto.Clone(signed).(*bertymessenger.BertyLink)
+	tampered.BertyID.PublicRendezvousSeed = bytes.Repeat([]byte{7}, 32)
+	require.False(t, tampered.Verified())
+	internal, web, err := tampered.Marshal()
+	require.NoError(t, err)
+	for _, uri := range []string{internal, web} {
+		_, err := bertymessenger.UnmarshalLink(uri)
+		require.True(t, errcode.Is(err, errcode.ErrCryptoSignatureVerification), uri)
+	}
+
+	// mismatched key
+	otherPK, otherSK, err := ed25519.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	_, _, err = link.MarshalSigned(otherSK)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	impostor := proto.Clone(link).(*bertymessenger.BertyLink)
+	impostor.BertyID.AccountPK = otherPK
+	internal, _, err = impostor.MarshalSigned(otherSK)
+	require.NoError(t, err)
+	impostor, err = bertymessenger.UnmarshalLink(internal)
+	require.NoError(t, err)
+	impostor.BertyID.AccountPK = pk
+	require.False(t, impostor.Verified())
+	internal, web, err = impostor.Marshal()
+	require.NoError(t, err)
+	for _, uri := range []string{internal, web} {
+		_, err := bertymessenger.UnmarshalLink(uri)
+		require.True(t, errcode.Is(err, errcode.ErrCryptoSignatureVerification), uri)
+	}
+
+	// only the contact links can be signed
+	_, _, err = testGroupLink().MarshalSigned(sk)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	group := testGroupLink()
+	group.Signature = signed.Signature
+	require.Error(t, group.IsValid())
+}
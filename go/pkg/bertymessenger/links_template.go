@@ -0,0 +1,27 @@
+package bertymessenger
+
+import (
+	"github.com/gogo/protobuf/proto"
+)
+
+// LinkTemplate holds the default metadata shared by several links, i.e., the bio of a company
+// or a return URL common to all the employee contact links.
+type LinkTemplate struct {
+	// Metadata is merged into each link generated by Apply.
+	Metadata map[string]string
+}
+
+// Apply returns a contact link for id, with the metadata of the template.
+//
+// The returned link owns its copy of id and its metadata map: the values set on the link override
+// the template ones, and editing them does not alter the template nor id.
+func (tmpl *LinkTemplate) Apply(id *BertyID) *BertyLink {
+	link := proto.Clone(id).(*BertyID).GetBertyLink()
+	if len(tmpl.Metadata) > 0 {
+		link.Metadata = make(map[string]string, len(tmpl.Metadata))
+		for key, value := range tmpl.Metadata {
+			link.Metadata[key] = value
+		}
+	}
+	return link
+}
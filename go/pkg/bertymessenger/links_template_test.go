@@ -0,0 +1,67 @@
+package bertymessenger_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+)
+
+func TestLinkTemplate(t *testing.T) {
+	tmpl := bertymessenger.LinkTemplate{
+		Metadata: map[string]string{
+			"bio":    "Berty Technologies, a non-profit organization",
+			"color":  "#3845E0",
+			"return": "https://berty.tech/",
+		},
+	}
+
+	alice := tmpl.Apply(testContactLink().BertyID)
+	bob := tmpl.Apply(&bertymessenger.BertyID{
+		PublicRendezvousSeed: alice.BertyID.PublicRendezvousSeed,
+		AccountPK:            alice.BertyID.AccountPK,
+		DisplayName:          "Bob",
+	})
+
+	// the links don't alias the IDs
+	source := testContactLink().BertyID
+	carol := tmpl.Apply(source)
+	carol.BertyID.DisplayName = "Carol"
+	source.AccountPK[0] = 42
+	require.Equal(t, "Alice", source.DisplayName)
+	require.Equal(t, testContactLink().BertyID.AccountPK, carol.BertyID.AccountPK)
+
+	// per-link overrides
+	bob.Metadata["bio"] = "Bob, from Berty Technologies"
+	bob.Metadata["role"] = "developer"
+	require.Equal(t, "Berty Technologies, a non-profit organization", tmpl.Metadata["bio"])
+	require.Equal(t, "Berty Technologies, a non-profit organization", alice.Metadata["bio"])
+
+	for _, tc := range []struct {
+		link     *bertymessenger.BertyLink
+		expected map[string]string
+	}{
+		{alice, tmpl.Metadata},
+		{bob, map[string]string{
+			"bio":    "Bob, from Berty Technologies",
+			"color":  "#3845E0",
+			"return": "https://berty.tech/",
+			"role":   "developer",
+		}},
+	} {
+		require.True(t, tc.link.IsContact())
+		internal, web, err := tc.link.Marshal()
+		require.NoError(t, err)
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, parsed.Metadata)
+			require.Equal(t, tc.link.BertyID.DisplayName, parsed.BertyID.DisplayName)
+		}
+	}
+
+	// a template without metadata produces plain links
+	empty := (&bertymessenger.LinkTemplate{}).Apply(testContactLink().BertyID)
+	require.Equal(t, testContactLink(), empty)
+}
@@ -2,12 +2,22 @@ package bertymessenger_test
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"math/rand"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/eknkc/basex"
+	"github.com/gogo/protobuf/proto"
 	"github.com/mdp/qrterminal"
+	"github.com/mr-tron/base58"
+	qrcode "github.com/skip2/go-qrcode"
 	"github.com/stretchr/testify/require"
 	"github.com/tj/assert"
 	"moul.io/srand"
@@ -31,13 +41,13 @@ func TestMarshalLink(t *testing.T) {
 				Kind: bertymessenger.BertyLink_ContactInviteV1Kind,
 				BertyID: &bertymessenger.BertyID{
 					DisplayName:          "Hello World!",
-					PublicRendezvousSeed: []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
-					AccountPK:            []byte{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+					PublicRendezvousSeed: bytes.Repeat([]byte{1}, 32),
+					AccountPK:            bytes.Repeat([]byte{2}, 32),
 				},
 			},
 			false,
-			"https://berty.tech/id#contact/3geQXHmsW9rxRfQFJdu8CEuPtWkfTWgJH13NzAoGatcnh4brusu3/name=Hello+World%21",
-			"BERTY://PB/CAS8232WNWU-1HTSMNYD.USC3T4F.P.J.AFKOXTKI:-N4P9IJTERR3CTFD.:N$*$3RQZLIFMT3-$IN..",
+			"https://berty.tech/id#contact/oZBLEgXAWxht4cjwTH3TmFSmFJJnz4zhs8sXaUdX8MZp2jdEQDzt5Tb47YUBA3PX9TLtfdvK4U5w2FXimvVB9DF5NniiUXK/name=Hello+World%21",
+			"BERTY://PB1/L$PY5Q7/XL-ULUK0CJ5AMESSZMI12*WH-BK:FMUQ22T0EX10GQ0F7A*YP68/CYNJX:$0AKIY::F:FJ:OD$G-MZKE3L5FK3*4O83W9BVT*04T-M3LVUP-PHXEL*7J17T",
 		}, {
 			"simple-group",
 			&bertymessenger.BertyLink{
@@ -45,30 +55,30 @@ func TestMarshalLink(t *testing.T) {
 				BertyGroup: &bertymessenger.BertyGroup{
 					DisplayName: "The Group Name!",
 					Group: &bertytypes.Group{
-						PublicKey: []byte{3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3},
-						Secret:    []byte{4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4},
-						SecretSig: []byte{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+						PublicKey: bytes.Repeat([]byte{3}, 32),
+						Secret:    bytes.Repeat([]byte{4}, 32),
+						SecretSig: bytes.Repeat([]byte{5}, 64),
 						GroupType: bertytypes.GroupTypeMultiMember,
-						SignPub:   []byte{6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6},
+						SignPub:   bytes.Repeat([]byte{6}, 32),
 					},
 				},
 			},
 			false,
-			"https://berty.tech/id#group/rUwVHzzEiMxGhM7iY4wW5yZFH3ZcjiWAhxva6tXUcfniDsoT3rmF3WdshR8955KAgeCTvirdfppTAMehPqmBV1YYjAiXYUQm98J992TuPT/name=The+Group+Name%21",
-			"BERTY://PB/.H:8XWGCG68:21MATDM7JR8Y6JMNJEVPISAXL274Y3VVDOPPUGK0LUYZ9X$FPFN*T93E08Y3$RYFIQFHJ3FY*79I75LU.5SJAKCS1PRLRYVLO.4-502DA4KL*E8WCGKEE1WGET$-0G7O1S7",
+			"https://berty.tech/id#group/SdnfmQ9KUAQEHWWq1PdGo6g8qzaRn3pAZnkDDpL91dCaNC6p9ZwkeRjSWyReWbXB38q7QH7bp53TZRpWesMRrtcuaWEPSJCD2Zsq3oM4C6LanxViS2z3mXvKjPXqNM5h4ezysc1z2d6XYRgYAEFtQn269qkDLJrFkst1nvrG3UTGZZHda9ipkwJHcpbbeAop6WZLWG4FVZYss5DQzDdVminEZ8gqwciLcZ979rRWy26LCazq/name=The+Group+Name%21",
+			"BERTY://PB1/EB1FDQS/QAA-9/K-*T/BDRVZ4H:$$NLY:E2UPK1D12Y$D5MLEQ2SRIB*I./YV.KQ8B88O7EE-IU/3UU7O..*B1PAZUE4*E.W4LIS1R5ZECAX1K22-ZU4V:Z9LONMFHEKWHE-2PSECD4*W.2C2TOIUPBV*X2HI:SB*O:/EL0R5:OBZP-XA1.C71ADXB/5:J/IK2SO-8I2N49AYDJJ58V3YNCU-92G0KEZVIW3CN.JX4N7GUELE76S8.SF/2/96L02C$XWHF.::GQOOOCZ4QZWLH$84/E$3ILH5",
 		}, {
 			"contact-with-unicode",
 			&bertymessenger.BertyLink{
 				Kind: bertymessenger.BertyLink_ContactInviteV1Kind,
 				BertyID: &bertymessenger.BertyID{
 					DisplayName:          `!@#$%^&*()_+ ://` + string(rune(0x1F600)),
-					PublicRendezvousSeed: []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
-					AccountPK:            []byte{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+					PublicRendezvousSeed: bytes.Repeat([]byte{1}, 32),
+					AccountPK:            bytes.Repeat([]byte{2}, 32),
 				},
 			},
 			false,
-			"https://berty.tech/id#contact/3geQXHmsW9rxRfQFJdu8CEuPtWkfTWgJH13NzAoGatcnh4brusu3/name=%21%40%23%24%25%5E%26%2A%28%29_%2B+%3A%2F%2F%F0%9F%98%80",
-			"BERTY://PB/BJ3W5ETGJU6$15FIE8U4:R300KUENPKC0J8YS6V02MXW9LDGPD6SVS/LU2TWQ8PGWF39R.ELP:-K:-4E30/.JNDU25WI",
+			"https://berty.tech/id#contact/oZBLEgXAWxht4cjwTH3TmFSmFJJnz4zhs8sXaUdX8MZp2jdEQDzt5Tb47YUBA3PX9TLtfdvK4U5w2FXimvVB9DF5NniiUXK/name=%21%40%23%24%25%5E%26%2A%28%29_%2B+%3A%2F%2F%F0%9F%98%80",
+			"BERTY://PB1/HLFO3GSDSQUJEA1X4A34.S$JD*REOE*GI3X-9C$1U055E2-4IPU*E3YYENJ0$D9*/J5O/082OIVPK6SA:7W9-Y$FTM4ZHVUEZ2:YCWGRPMXYC26BAL3FQ1MC-NP7U/XHZV2PUFT3NCA",
 		}, {
 			"group-with-unicode",
 			&bertymessenger.BertyLink{
@@ -76,17 +86,17 @@ func TestMarshalLink(t *testing.T) {
 				BertyGroup: &bertymessenger.BertyGroup{
 					DisplayName: `!@#$%^&*()_=+ ://` + string(rune(0x1F600)),
 					Group: &bertytypes.Group{
-						PublicKey: []byte{3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3},
-						Secret:    []byte{4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4},
-						SecretSig: []byte{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+						PublicKey: bytes.Repeat([]byte{3}, 32),
+						Secret:    bytes.Repeat([]byte{4}, 32),
+						SecretSig: bytes.Repeat([]byte{5}, 64),
 						GroupType: bertytypes.GroupTypeMultiMember,
-						SignPub:   []byte{6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6},
+						SignPub:   bytes.Repeat([]byte{6}, 32),
 					},
 				},
 			},
 			false,
-			"https://berty.tech/id#group/rUwVHzzEiMxGhM7iY4wW5yZFH3ZcjiWAhxva6tXUcfniDsoT3rmF3WdshR8955KAgeCTvirdfppTAMehPqmBV1YYjAiXYUQm98J992TuPT/name=%21%40%23%24%25%5E%26%2A%28%29_%3D%2B+%3A%2F%2F%F0%9F%98%80",
-			"BERTY://PB/1FKTFXAW7RN$NCK6*$DSJREWJGK9IBQPJE:FZA4ZVM9DMH55U85P7IMU7OCQ.QE:9/98RB45ENQ61/X23FSZXH/U-XZJ.$E$4JNKK9L7-9F/8Z8DP78US/-6BZXX.$BJ6$NELVC$UREEQ8E8T//0NFE2",
+			"https://berty.tech/id#group/SdnfmQ9KUAQEHWWq1PdGo6g8qzaRn3pAZnkDDpL91dCaNC6p9ZwkeRjSWyReWbXB38q7QH7bp53TZRpWesMRrtcuaWEPSJCD2Zsq3oM4C6LanxViS2z3mXvKjPXqNM5h4ezysc1z2d6XYRgYAEFtQn269qkDLJrFkst1nvrG3UTGZZHda9ipkwJHcpbbeAop6WZLWG4FVZYss5DQzDdVminEZ8gqwciLcZ979rRWy26LCazq/name=%21%40%23%24%25%5E%26%2A%28%29_%3D%2B+%3A%2F%2F%F0%9F%98%80",
+			"BERTY://PB1/C7RZUOIE$FTHM3HT*L$:YZ8UOHR4K8UW5JGNA9JRZ/JKSIG-BKQ:YNUTEFK*6TNR*66ZYIE7:YGUQM9S760K-7-10/K-9JGLW$.96Y:X/AG9MK6YACQI$OP4K5/KZ94JO-8$6KX-14MBFN/PMF3:B6JWZO:KQE$OTYY$F3*.QL8JB:ETQDQBEVMEYXUS-UJ2/UR8UL:WSO3*6ZMROZG.V0ZS$Q-5037JE$OIN6FETSZXTLA50CU2YN97LN22:36I9*FVSVMR2FP5YX-*$30$ZWV$UDNHKC8M/23K0.Z*86",
 		},
 		// FIXME: invalid kind
 		// FIXME: incomplete link
@@ -130,70 +140,1717 @@ func TestMarshalLink(t *testing.T) {
 	}
 }
 
+func TestMarshalLinkURLLengthLimit(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		_, web, err := testContactLink().Marshal()
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(web), bertymessenger.DefaultURLLengthLimit)
+	})
+
+	t.Run("too-large", func(t *testing.T) {
+		link := testContactLink()
+		link.BertyID.DisplayName = strings.Repeat("Alice ", 20)
+		_, _, err := link.Marshal(bertymessenger.WithURLLengthLimit(150))
+		require.Error(t, err)
+		require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+
+		_, web, err := link.Marshal()
+		require.NoError(t, err)
+		require.Greater(t, len(web), 150)
+	})
+
+	t.Run("invalid-limit", func(t *testing.T) {
+		_, _, err := testContactLink().Marshal(bertymessenger.WithURLLengthLimit(0))
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	})
+}
+
+func TestMarshalLinkWithoutGroupSecret(t *testing.T) {
+	t.Run("group", func(t *testing.T) {
+		link := testGroupLink()
+		internal, web, err := link.Marshal(bertymessenger.WithoutGroupSecret())
+		require.NoError(t, err)
+
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err)
+			assert.Empty(t, parsed.BertyGroup.Group.Secret)
+			assert.Empty(t, parsed.BertyGroup.Group.SecretSig)
+			assert.Equal(t, link.BertyGroup.Group.PublicKey, parsed.BertyGroup.Group.PublicKey)
+			assert.Equal(t, link.BertyGroup.DisplayName, parsed.BertyGroup.DisplayName)
+		}
+
+		// the input link is left untouched
+		assert.Equal(t, testGroupLink(), link)
+	})
+
+	t.Run("contact", func(t *testing.T) {
+		_, _, err := testContactLink().Marshal(bertymessenger.WithoutGroupSecret())
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		assert.Contains(t, err.Error(), "WithoutGroupSecret only applies to \"GroupV1Kind\" links")
+	})
+}
+
+func TestMarshalLinkWithoutDisplayName(t *testing.T) {
+	groupSet := &bertymessenger.BertyLink{
+		Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+		BertyGroups: []*bertymessenger.BertyGroup{testGroupLink().BertyGroup},
+	}
+
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink(), groupSet} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			expected := proto.Clone(link).(*bertymessenger.BertyLink)
+			internal, web, err := link.Marshal(bertymessenger.WithoutDisplayName())
+			require.NoError(t, err)
+			require.NotContains(t, web, "name=")
+
+			for _, uri := range []string{internal, web} {
+				parsed, err := bertymessenger.UnmarshalLink(uri)
+				require.NoError(t, err)
+				for _, name := range []string{
+					parsed.GetBertyID().GetDisplayName(),
+					parsed.GetBertyGroup().GetDisplayName(),
+				} {
+					assert.Empty(t, name)
+				}
+				for _, group := range parsed.BertyGroups {
+					assert.Empty(t, group.DisplayName)
+				}
+				assert.NoError(t, parsed.IsValid())
+			}
+
+			// the input link is left untouched, and the default behavior is unchanged
+			assert.Equal(t, expected, link)
+			_, web, err = link.Marshal()
+			require.NoError(t, err)
+			require.Contains(t, web, "name=")
+		})
+	}
+}
+
+func TestMarshalLinkWithWebPrefix(t *testing.T) {
+	link := testContactLink()
+	defaultInternal, defaultWeb, err := link.Marshal()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(defaultWeb, bertymessenger.LinkWebPrefix))
+
+	const prefix = "https://chat.example.org/id#"
+	internal, web, err := link.Marshal(bertymessenger.WithWebPrefix(prefix))
+	require.NoError(t, err)
+	require.Equal(t, defaultInternal, internal)
+	require.Equal(t, prefix+strings.TrimPrefix(defaultWeb, bertymessenger.LinkWebPrefix), web)
+
+	parsed, err := bertymessenger.UnmarshalLink(web)
+	require.NoError(t, err)
+	require.Equal(t, link, parsed)
+	kind, err := bertymessenger.LinkKindOf(web)
+	require.NoError(t, err)
+	require.Equal(t, bertymessenger.BertyLink_ContactInviteV1Kind, kind)
+	consistent, err := bertymessenger.LinksConsistent(internal, web)
+	require.NoError(t, err)
+	require.True(t, consistent)
+
+	// a port is part of the host
+	_, web, err = link.Marshal(bertymessenger.WithWebPrefix("https://localhost:8443/id#"))
+	require.NoError(t, err)
+	_, err = bertymessenger.UnmarshalLink(web)
+	require.NoError(t, err)
+
+	for _, invalid := range []string{
+		"https://chat.example.org/id",
+		"https://chat.example.org/id/",
+		"https://chat.example.org/#",
+		"https://chat.example.org/invite#",
+		"http://chat.example.org/id#",
+		"https:///id#",
+		"https://user@chat.example.org/id#",
+		"chat.example.org/id#",
+		"",
+	} {
+		_, _, err := link.Marshal(bertymessenger.WithWebPrefix(invalid))
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), invalid)
+	}
+}
+
+func TestMarshalLinkWithAnalyticsTag(t *testing.T) {
+	link := testContactLink()
+	internal, web, err := link.Marshal(bertymessenger.WithAnalyticsTag("poster-2021"))
+	require.NoError(t, err)
+	assert.Contains(t, web, "a=poster-2021")
+
+	// the internal link is untouched
+	expectedInternal, _, err := link.Marshal()
+	require.NoError(t, err)
+	assert.Equal(t, expectedInternal, internal)
+
+	// the tag is not decoded
+	parsed, err := bertymessenger.UnmarshalLink(web)
+	require.NoError(t, err)
+	assert.Equal(t, link, parsed)
+
+	for _, tag := range []string{"", strings.Repeat("a", bertymessenger.MaxAnalyticsTagLength+1)} {
+		_, _, err := link.Marshal(bertymessenger.WithAnalyticsTag(tag))
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	}
+}
+
+func TestMarshalLinkDoesNotMutateInput(t *testing.T) {
+	cases := []struct {
+		link *bertymessenger.BertyLink
+		opts []bertymessenger.MarshalOption
+	}{
+		{testContactLink(), []bertymessenger.MarshalOption{bertymessenger.WithAnalyticsTag("tag")}},
+		{testGroupLink(), []bertymessenger.MarshalOption{bertymessenger.WithoutGroupSecret(), bertymessenger.WithAnalyticsTag("tag")}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.link.Kind.String(), func(t *testing.T) {
+			tc.link.Metadata = map[string]string{"bio": "hello"}
+			expected := proto.Clone(tc.link).(*bertymessenger.BertyLink)
+			id, group := tc.link.BertyID, tc.link.BertyGroup
+
+			_, _, err := tc.link.Marshal(tc.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, expected, tc.link)
+			assert.True(t, id == tc.link.BertyID)
+			assert.True(t, group == tc.link.BertyGroup)
+		})
+	}
+}
+
+func TestMarshalLinkWithGroupedPayload(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			internal, web, err := link.Marshal()
+			require.NoError(t, err)
+			grouped, groupedWeb, err := link.Marshal(bertymessenger.WithGroupedPayload(4))
+			require.NoError(t, err)
+			require.Equal(t, web, groupedWeb)
+
+			require.True(t, strings.HasPrefix(grouped, "BERTY://PB1/"))
+			groups := strings.Split(strings.TrimPrefix(grouped, "BERTY://PB1/"), "+")
+			require.Greater(t, len(groups), 1)
+			for i, group := range groups {
+				if i < len(groups)-1 {
+					require.Len(t, group, 4)
+				} else {
+					require.True(t, len(group) > 0 && len(group) <= 4)
+				}
+			}
+			require.Equal(t, internal, "BERTY://PB1/"+strings.Join(groups, ""))
+
+			parsed, err := bertymessenger.UnmarshalLink(grouped)
+			require.NoError(t, err)
+			require.Equal(t, link, parsed)
+
+			// the delimiters are ignored, wherever they are, and even turned into spaces
+			for _, uri := range []string{
+				strings.ReplaceAll(grouped, "+", " "),
+				"BERTY://PB1/" + strings.Join(groups, "++"),
+				"BERTY://PB1/+" + strings.Join(groups, ""),
+			} {
+				parsed, err := bertymessenger.UnmarshalLink(uri)
+				require.NoError(t, err)
+				require.Equal(t, link, parsed)
+			}
+		})
+	}
+
+	_, _, err := testContactLink().Marshal(bertymessenger.WithGroupedPayload(0))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestMarshalLinkWithScanChecksum(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			internal, web, err := link.Marshal()
+			require.NoError(t, err)
+			checked, checkedWeb, err := link.Marshal(bertymessenger.WithScanChecksum())
+			require.NoError(t, err)
+			require.Equal(t, web, checkedWeb)
+
+			// the payload is the same, followed by the checksum
+			require.True(t, strings.HasPrefix(checked, "BERTY://PC/"), checked)
+			payload := strings.TrimPrefix(internal, "BERTY://PB1/")
+			require.Equal(t, payload, strings.TrimPrefix(checked, "BERTY://PC/")[:len(payload)])
+			require.Len(t, checked, len(internal)+6)
+
+			for _, uri := range []string{internal, checked, strings.ToLower(checked)} {
+				parsed, err := bertymessenger.UnmarshalLink(uri)
+				require.NoError(t, err)
+				require.Equal(t, link, parsed)
+			}
+
+			grouped, _, err := link.Marshal(bertymessenger.WithScanChecksum(), bertymessenger.WithGroupedPayload(5))
+			require.NoError(t, err)
+			parsed, err := bertymessenger.UnmarshalLink(grouped)
+			require.NoError(t, err)
+			require.Equal(t, link, parsed)
+
+			// every single-char alteration of the payload or the checksum is caught
+			const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$*-.:/"
+			right := strings.TrimPrefix(checked, "BERTY://PC/")
+			for i := range right {
+				replacement := alphabet[(strings.IndexByte(alphabet, right[i])+1+i%(len(alphabet)-1))%len(alphabet)]
+				altered := "BERTY://PC/" + right[:i] + string(replacement) + right[i+1:]
+				_, err := bertymessenger.UnmarshalLink(altered)
+				require.True(t, errcode.Is(err, errcode.ErrLinkInvalidScan), "char %d: %v", i, err)
+			}
+
+			// dropped and transposed chars too
+			for i := 0; i < len(right)-1; i++ {
+				_, err := bertymessenger.UnmarshalLink("BERTY://PC/" + right[:i] + right[i+1:])
+				require.True(t, errcode.Is(err, errcode.ErrLinkInvalidScan), "dropped char %d: %v", i, err)
+				if right[i] != right[i+1] {
+					transposed := right[:i] + string(right[i+1]) + string(right[i]) + right[i+2:]
+					_, err = bertymessenger.UnmarshalLink("BERTY://PC/" + transposed)
+					require.True(t, errcode.Is(err, errcode.ErrLinkInvalidScan), "transposed chars %d: %v", i, err)
+				}
+			}
+		})
+	}
+
+	_, err := bertymessenger.UnmarshalLink("BERTY://PC/ABC")
+	require.True(t, errcode.Is(err, errcode.ErrLinkInvalidScan))
+}
+
+func TestLinkToInternal(t *testing.T) {
+	contact := testContactLink()
+	contact.BertyID.ReferralCode = "spring"
+	contact.Metadata = map[string]string{"bio": "hello"}
+	contact.ExpiresAtUnix = time.Now().Add(time.Hour).Unix()
+	group := testGroupLink()
+
+	for _, link := range []*bertymessenger.BertyLink{contact, group} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			expected, web, err := link.Marshal()
+			require.NoError(t, err)
+
+			received, err := bertymessenger.UnmarshalLink(web)
+			require.NoError(t, err)
+			internal, err := received.ToInternal()
+			require.NoError(t, err)
+			require.Equal(t, expected, internal)
+
+			// the internal link generates smaller QR codes
+			internalQR, err := qrcode.New(internal, qrcode.Medium)
+			require.NoError(t, err)
+			webQR, err := qrcode.New(web, qrcode.Medium)
+			require.NoError(t, err)
+			require.Less(t, internalQR.VersionNumber, webQR.VersionNumber)
+
+			parsed, err := bertymessenger.UnmarshalLink(internal)
+			require.NoError(t, err)
+			require.Equal(t, link, parsed)
+		})
+	}
+
+	// the web link length limit doesn't apply
+	large := testContactLink()
+	large.Metadata = map[string]string{"bio": strings.Repeat("Alice ", 400)}
+	_, _, err := large.Marshal()
+	require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+	_, err = large.ToInternal()
+	require.NoError(t, err)
+
+	_, err = (&bertymessenger.BertyLink{}).ToInternal()
+	require.Error(t, err)
+}
+
+func TestMarshalLinkReservedMetadataKeys(t *testing.T) {
+	for _, key := range []string{"", "name", "ref", "nbf", "exp", "exp_hint", "a"} {
+		link := testContactLink()
+		link.Metadata = map[string]string{key: "value"}
+		_, _, err := link.Marshal()
+		require.Error(t, err, key)
+	}
+}
+
+func TestLinkMetadataRoundTrip(t *testing.T) {
+	link := testContactLink()
+	link.Metadata = map[string]string{
+		"utm_source":   "newsletter",
+		"campaign tag": "spring 2021 / #1",
+		"a&b=c":        "50% off?x=y&z",
+		"plus+sign":    "a+b c",
+		"café":         "Émilie 🦄",
+		"empty":        "",
+	}
+	internal, web, err := link.Marshal()
+	require.NoError(t, err)
+	for _, uri := range []string{internal, web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err, uri)
+		require.Equal(t, link.Metadata, parsed.Metadata, uri)
+		require.Equal(t, "Alice", parsed.BertyID.DisplayName, uri)
+	}
+
+	// the total size of the metadata is capped
+	link.Metadata = map[string]string{"bio": strings.Repeat("x", bertymessenger.MaxLinkMetadataSize-len("bio"))}
+	_, err = link.ToInternal()
+	require.NoError(t, err)
+	link.Metadata["a2"] = "x"
+	_, err = link.ToInternal()
+	require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+}
+
+func TestMarshalLinkValidWebURI(t *testing.T) {
+	names := []string{
+		"Alice",
+		"Alice Bob",
+		"  leading and trailing  ",
+		"Émilie 🦄🚀",
+		"中文 名字",
+		"a#b?c&d=e/f%g+h",
+		"[]{}<>|\\^`\"'",
+		"100% sure; @home: yes!",
+		"line\nbreak\ttab",
+	}
+	for _, name := range names {
+		contact := testContactLink()
+		contact.BertyID.DisplayName = name
+		group := testGroupLink()
+		group.BertyGroup.DisplayName = name
+		group.Metadata = map[string]string{name: name}
+
+		for _, link := range []*bertymessenger.BertyLink{contact, group} {
+			_, web, err := link.Marshal()
+			require.NoError(t, err, name)
+
+			parsed, err := url.Parse(web)
+			require.NoError(t, err, name)
+			assert.Equal(t, "https", parsed.Scheme)
+			assert.Equal(t, "berty.tech", parsed.Host)
+			assert.Equal(t, "/id", parsed.Path)
+			assert.Empty(t, parsed.RawQuery)
+			assert.Equal(t, web[len(bertymessenger.LinkWebPrefix):], parsed.EscapedFragment(), name)
+			assert.NotContains(t, parsed.EscapedFragment(), " ", name)
+			assert.NotContains(t, parsed.EscapedFragment(), "#", name)
+
+			unmarshaled, err := bertymessenger.UnmarshalLink(web)
+			require.NoError(t, err, name)
+			assert.Equal(t, link.GetBertyID().GetDisplayName(), unmarshaled.GetBertyID().GetDisplayName())
+			assert.Equal(t, link.GetBertyGroup().GetDisplayName(), unmarshaled.GetBertyGroup().GetDisplayName())
+			assert.Equal(t, link.Metadata, unmarshaled.Metadata)
+		}
+	}
+}
+
+func TestLinkReferralCode(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		_, web, err := testContactLink().Marshal()
+		require.NoError(t, err)
+		assert.NotContains(t, web, "ref=")
+
+		parsed, err := bertymessenger.UnmarshalLink(web)
+		require.NoError(t, err)
+		assert.Empty(t, parsed.BertyID.ReferralCode)
+	})
+
+	t.Run("round-trip", func(t *testing.T) {
+		link := testContactLink()
+		link.BertyID.ReferralCode = "Friend_2020-abc"
+		internal, web, err := link.Marshal()
+		require.NoError(t, err)
+		assert.Contains(t, web, "ref=Friend_2020-abc")
+
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err)
+			assert.Equal(t, link, parsed)
+		}
+	})
+
+	cases := []struct {
+		code            string
+		expectedErrcode errcode.ErrCode
+	}{
+		{"", -1},
+		{"abc", -1},
+		{strings.Repeat("a", bertymessenger.MaxReferralCodeLength), -1},
+		{strings.Repeat("a", bertymessenger.MaxReferralCodeLength+1), errcode.ErrInvalidInput},
+		{"with space", errcode.ErrInvalidInput},
+		{"a&b=c", errcode.ErrInvalidInput},
+		{"<script>", errcode.ErrInvalidInput},
+		{"\u00e9t\u00e9", errcode.ErrInvalidInput},
+	}
+	for _, tc := range cases {
+		t.Run(tc.code, func(t *testing.T) {
+			link := testContactLink()
+			link.BertyID.ReferralCode = tc.code
+			_, _, err := link.Marshal()
+			assert.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
+
+			// a manually crafted link with an invalid referral code is rejected as well
+			_, web, err := testContactLink().Marshal()
+			require.NoError(t, err)
+			_, err = bertymessenger.UnmarshalLink(web + "&ref=" + url.QueryEscape(tc.code))
+			assert.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
+		})
+	}
+}
+
+func TestSanitizeDisplayName(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain", "Alice", "Alice"},
+		{"empty", "", ""},
+		{"unicode", "Élodie \U0001f469\u200d\U0001f4bb", "Élodie \U0001f469\u200d\U0001f4bb"},
+		{"rtl-override", "Alice\u202egnp.exe", "Alicegnp.exe"},
+		{"bidi-isolates", "\u2067Bob\u2069 \u200fMallory\u200e\u061c", "Bob Mallory"},
+		{"newlines", "line1\nline2\r\nline3", "line1 line2 line3"},
+		{"collapsed-spaces", "  spaced \t out  ", "spaced out"},
+		{"null", "Ali\x00ce", "Alice"},
+		{"c0", "\x07\x1bAlice\x7f", "Alice"},
+		{"c1", "Alice\u0085Bob\u009b", "Alice Bob"},
+		{"only-controls", "\n\u202e\x00", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, bertymessenger.SanitizeDisplayName(tc.input))
+			require.Equal(t, tc.expected, bertymessenger.SanitizeDisplayName(tc.expected), "not idempotent")
+		})
+	}
+
+	// the names of the web links are sanitized
+	for _, tc := range cases {
+		t.Run("web/"+tc.name, func(t *testing.T) {
+			for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+				parsed, err := bertymessenger.UnmarshalLink(webLinkWithName(t, link, tc.input))
+				require.NoError(t, err)
+				name := parsed.GetBertyID().GetDisplayName() + parsed.GetBertyGroup().GetDisplayName()
+				require.Equal(t, tc.expected, name)
+			}
+		})
+	}
+}
+
+// webLinkWithName returns the web link of link, with a hand-crafted name.
+func webLinkWithName(t *testing.T, link *bertymessenger.BertyLink, name string) string {
+	t.Helper()
+	unnamed := proto.Clone(link).(*bertymessenger.BertyLink)
+	_, web, err := unnamed.Marshal(bertymessenger.WithoutDisplayName())
+	require.NoError(t, err)
+	require.NotContains(t, web, "name=")
+	separator := "/"
+	if strings.Count(strings.TrimPrefix(web, bertymessenger.LinkWebPrefix), "/") > 1 {
+		separator = "&"
+	}
+	return web + separator + url.Values{"name": {name}}.Encode()
+}
+
+func TestLinkDisplayNameLength(t *testing.T) {
+	const emoji = "\U0001F600" // 4 bytes
+	maxName := strings.Repeat(emoji, bertymessenger.MaxDisplayNameLength/4)
+	require.Len(t, maxName, bertymessenger.MaxDisplayNameLength)
+
+	cases := []struct {
+		name    string
+		link    func() *bertymessenger.BertyLink
+		setName func(link *bertymessenger.BertyLink, name string)
+		getName func(link *bertymessenger.BertyLink) string
+	}{
+		{
+			"contact", testContactLink,
+			func(link *bertymessenger.BertyLink, name string) { link.BertyID.DisplayName = name },
+			func(link *bertymessenger.BertyLink) string { return link.BertyID.DisplayName },
+		},
+		{
+			"group", testGroupLink,
+			func(link *bertymessenger.BertyLink, name string) { link.BertyGroup.DisplayName = name },
+			func(link *bertymessenger.BertyLink) string { return link.BertyGroup.DisplayName },
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link := tc.link()
+			tc.setName(link, maxName)
+			require.NoError(t, link.IsValid())
+			internal, web, err := link.Marshal()
+			require.NoError(t, err)
+			for _, uri := range []string{internal, web} {
+				parsed, err := bertymessenger.UnmarshalLink(uri)
+				require.NoError(t, err)
+				require.Equal(t, maxName, tc.getName(parsed))
+			}
+
+			tc.setName(link, "a"+maxName)
+			require.True(t, errcode.Is(link.IsValid(), errcode.ErrInvalidInput))
+			_, _, err = link.Marshal()
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+			// a crafted web name is truncated on a rune boundary
+			tc.setName(link, "")
+			_, web, err = link.Marshal()
+			require.NoError(t, err)
+			parsed, err := bertymessenger.UnmarshalLink(web + "/name=" + url.QueryEscape("a"+maxName))
+			require.NoError(t, err)
+			truncated := tc.getName(parsed)
+			require.Equal(t, "a"+strings.Repeat(emoji, bertymessenger.MaxDisplayNameLength/4-1), truncated)
+			require.True(t, utf8.ValidString(truncated))
+			require.NoError(t, parsed.IsValid())
+		})
+	}
+}
+
+func TestLinkIsValidKindMismatch(t *testing.T) {
+	contact, group := testContactLink(), testGroupLink()
+
+	cases := []struct {
+		name            string
+		link            *bertymessenger.BertyLink
+		expectedErrcode errcode.ErrCode
+	}{
+		{"valid-contact", contact, -1},
+		{"valid-group", group, -1},
+		{"group-kind-with-contact-data", &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_GroupV1Kind, BertyID: contact.BertyID}, errcode.ErrInvalidInput},
+		{"contact-kind-with-group-data", &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_ContactInviteV1Kind, BertyGroup: group.BertyGroup}, errcode.ErrInvalidInput},
+		{"group-kind-with-both", &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_GroupV1Kind, BertyID: contact.BertyID, BertyGroup: group.BertyGroup}, errcode.ErrInvalidInput},
+		{"contact-kind-with-both", &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_ContactInviteV1Kind, BertyID: contact.BertyID, BertyGroup: group.BertyGroup}, errcode.ErrInvalidInput},
+		{"group-kind-without-group", &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_GroupV1Kind, BertyGroup: &bertymessenger.BertyGroup{DisplayName: "foo"}}, errcode.ErrMissingInput},
+		{"unknown-kind-with-contact-data", &bertymessenger.BertyLink{BertyID: contact.BertyID}, errcode.ErrInvalidInput},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.link.IsValid()
+			assert.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
+
+			// Marshal never panics, and fails on invalid links
+			_, _, err = tc.link.Marshal()
+			if tc.expectedErrcode == -1 {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestBertyLinkFromGroup(t *testing.T) {
+	group := testGroupLink().BertyGroup.Group
+
+	link, err := bertymessenger.BertyLinkFromGroup(group, "The Group")
+	require.NoError(t, err)
+	assert.Equal(t, testGroupLink(), link)
+	_, _, err = link.Marshal()
+	require.NoError(t, err)
+
+	_, err = bertymessenger.BertyLinkFromGroup(nil, "The Group")
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+
+	for _, groupType := range []bertytypes.GroupType{bertytypes.GroupTypeContact, bertytypes.GroupTypeAccount} {
+		// a stored group always has its secret, the group type is what matters
+		g := *group
+		g.GroupType = groupType
+		require.NotEmpty(t, g.Secret)
+		_, err := bertymessenger.BertyLinkFromGroup(&g, "The Group")
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), groupType)
+		require.Contains(t, err.Error(), "can't share", groupType)
+
+		g.Secret, g.SecretSig = nil, nil
+		_, err = bertymessenger.BertyLinkFromGroup(&g, "The Group")
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), groupType)
+	}
+}
+
+func TestLinkIsValidGroupSecretPolicy(t *testing.T) {
+	cases := []struct {
+		groupType       bertytypes.GroupType
+		withSecret      bool
+		expectedErrcode errcode.ErrCode
+	}{
+		{bertytypes.GroupTypeMultiMember, true, -1},
+		{bertytypes.GroupTypeMultiMember, false, -1},
+		{bertytypes.GroupTypeAccount, true, errcode.ErrInvalidInput},
+		{bertytypes.GroupTypeAccount, false, errcode.ErrInvalidInput},
+		{bertytypes.GroupTypeContact, true, errcode.ErrInvalidInput},
+		{bertytypes.GroupTypeContact, false, errcode.ErrInvalidInput},
+		{bertytypes.GroupTypeUndefined, true, errcode.ErrInvalidInput},
+		{bertytypes.GroupTypeUndefined, false, errcode.ErrInvalidInput},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s/secret=%t", tc.groupType, tc.withSecret), func(t *testing.T) {
+			link := testGroupLink()
+			link.BertyGroup.Group.GroupType = tc.groupType
+			if !tc.withSecret {
+				link.BertyGroup.Group.Secret = nil
+				link.BertyGroup.Group.SecretSig = nil
+			}
+
+			err := link.IsValid()
+			assert.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
+
+			_, _, err = link.Marshal()
+			if tc.expectedErrcode == -1 {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+
+	// the secret policy applies once the group type is accepted, a leftover secret signature is a leak too
+	link := testGroupLink()
+	link.BertyGroup.Group.GroupType = bertytypes.GroupTypeUndefined
+	link.BertyGroup.Group.Secret = nil
+	_, _, err := link.Marshal(bertymessenger.WithAllowNonMultiMember())
+	require.True(t, errcode.Is(err, errcode.ErrLinkGroupSecretMismatch))
+}
+
+func TestMarshalLinkAllowNonMultiMember(t *testing.T) {
+	for _, groupType := range []bertytypes.GroupType{bertytypes.GroupTypeAccount, bertytypes.GroupTypeContact} {
+		link := testGroupLink()
+		link.BertyGroup.Group.GroupType = groupType
+		link.BertyGroup.Group.Secret = nil
+		link.BertyGroup.Group.SecretSig = nil
+
+		// rejected by default
+		require.True(t, errcode.Is(link.IsValid(), errcode.ErrInvalidInput), groupType)
+		_, _, err := link.Marshal()
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), groupType)
+
+		// the group type is restored from both links
+		internal, web, err := link.Marshal(bertymessenger.WithAllowNonMultiMember())
+		require.NoError(t, err, groupType)
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err, uri)
+			require.Equal(t, groupType, parsed.BertyGroup.Group.GroupType)
+			require.Equal(t, link, parsed)
+		}
+
+		// in a group set too
+		set := &bertymessenger.BertyLink{
+			Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+			BertyGroups: []*bertymessenger.BertyGroup{testGroupLink().BertyGroup, link.BertyGroup},
+		}
+		set.BertyGroups[0].Group.PublicKey = bytes.Repeat([]byte{9}, 32)
+		_, _, err = set.Marshal()
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), groupType)
+		_, _, err = set.Marshal(bertymessenger.WithAllowNonMultiMember())
+		require.NoError(t, err, groupType)
+
+		// a stored group, with its secret, is serialized as is
+		link.BertyGroup.Group.Secret = testGroupLink().BertyGroup.Group.Secret
+		link.BertyGroup.Group.SecretSig = testGroupLink().BertyGroup.Group.SecretSig
+		_, _, err = link.Marshal()
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), groupType)
+		internal, web, err = link.Marshal(bertymessenger.WithAllowNonMultiMember())
+		require.NoError(t, err, groupType)
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err, uri)
+			require.Equal(t, link, parsed)
+		}
+	}
+
+	// the secret policy still applies to the other group types
+	link := testGroupLink()
+	link.BertyGroup.Group.GroupType = bertytypes.GroupTypeUndefined
+	_, _, err := link.Marshal(bertymessenger.WithAllowNonMultiMember())
+	require.True(t, errcode.Is(err, errcode.ErrLinkGroupSecretMismatch))
+}
+
+func TestMarshalGroups(t *testing.T) {
+	groups := make([]*bertymessenger.BertyGroup, 3)
+	for i := range groups {
+		groups[i] = testGroupLink().BertyGroup
+		groups[i].Group.PublicKey = bytes.Repeat([]byte{byte(10 + i)}, 32)
+	}
+	groups[0].DisplayName = "Berty Devs"
+	groups[1].DisplayName = ""
+	groups[2].DisplayName = "Berty & Friends"
+
+	internal, web, err := bertymessenger.MarshalGroups(groups)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(web, bertymessenger.LinkWebPrefix+"groups/"), web)
+	require.Contains(t, web, "count=3")
+
+	expected := &bertymessenger.BertyLink{
+		Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+		BertyGroups: groups,
+	}
+	// the count is only a preview, an edited one is ignored
+	edited := strings.Replace(web, "count=3", "count=42", 1)
+	for _, uri := range []string{internal, web, edited} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err)
+		require.Equal(t, expected, parsed, uri)
+		require.NoError(t, parsed.IsValid())
+		require.True(t, parsed.IsGroupSet())
+		require.True(t, parsed.IsGroupBundle())
+		require.Equal(t, bertymessenger.BertyLink_GroupBundleV1Kind, parsed.Kind)
+		require.Equal(t, groups, parsed.Groups())
+
+		kind, err := bertymessenger.LinkKindOf(uri)
+		require.NoError(t, err)
+		require.Equal(t, bertymessenger.BertyLink_GroupSetV1Kind, kind)
+	}
+	require.True(t, expected.HasGroupSecret())
+	require.Equal(t, bertymessenger.RecommendedSecretLinkExpiry, expected.RecommendedExpiry())
+
+	t.Run("without-names", func(t *testing.T) {
+		unnamed := []*bertymessenger.BertyGroup{testGroupLink().BertyGroup, testGroupLink().BertyGroup}
+		unnamed[1].Group.PublicKey = bytes.Repeat([]byte{42}, 32)
+		for _, group := range unnamed {
+			group.DisplayName = ""
+		}
+		_, web, err := bertymessenger.MarshalGroups(unnamed)
+		require.NoError(t, err)
+		require.NotContains(t, web, "name=")
+		parsed, err := bertymessenger.UnmarshalLink(web)
+		require.NoError(t, err)
+		require.Equal(t, unnamed, parsed.BertyGroups)
+	})
+
+	t.Run("non-shareable-group", func(t *testing.T) {
+		invalid := []*bertymessenger.BertyGroup{groups[0], testGroupLink().BertyGroup}
+		invalid[1].Group.GroupType = bertytypes.GroupTypeContact
+		invalid[1].Group.Secret, invalid[1].Group.SecretSig = nil, nil
+		_, _, err := bertymessenger.MarshalGroups(invalid)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	})
+
+	t.Run("invalid-sets", func(t *testing.T) {
+		_, _, err := bertymessenger.MarshalGroups(nil)
+		require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+
+		_, _, err = bertymessenger.MarshalGroups([]*bertymessenger.BertyGroup{groups[0], groups[0]})
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+		tooMany := make([]*bertymessenger.BertyGroup, bertymessenger.MaxLinkGroupSetSize+1)
+		for i := range tooMany {
+			tooMany[i] = testGroupLink().BertyGroup
+			tooMany[i].Group.PublicKey = bytes.Repeat([]byte{byte(i)}, 32)
+		}
+		_, _, err = bertymessenger.MarshalGroups(tooMany)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+		mixed := testGroupLink()
+		mixed.BertyGroups = groups
+		require.True(t, errcode.Is(mixed.IsValid(), errcode.ErrInvalidInput))
+	})
+}
+
+func TestMarshalContactLinkRendezvousSeeds(t *testing.T) {
+	seed := func(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+
+	// the links without additional seeds have a single seed
+	legacy, err := bertymessenger.UnmarshalLink("https://berty.tech/id#contact/" + validContactBlob)
+	require.NoError(t, err)
+	require.Len(t, legacy.BertyID.RendezvousSeeds(), 1)
+	require.Equal(t, legacy.BertyID.PublicRendezvousSeed, legacy.BertyID.RendezvousSeeds()[0])
+	require.Equal(t, [][]byte{seed(1)}, testContactLink().BertyID.RendezvousSeeds())
+	require.Nil(t, (*bertymessenger.BertyID)(nil).RendezvousSeeds())
+
+	link := testContactLink()
+	link.BertyID.AdditionalRendezvousSeeds = [][]byte{seed(7), seed(8), seed(9)}
+	require.NoError(t, link.IsValid())
+	require.Equal(t, [][]byte{seed(1), seed(7), seed(8), seed(9)}, link.BertyID.RendezvousSeeds())
+
+	// the seeds are kept, in order, by both links
+	internal, web, err := link.Marshal()
+	require.NoError(t, err)
+	for _, uri := range []string{internal, web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err)
+		require.Equal(t, link, parsed)
+	}
+	require.Equal(t, []bertymessenger.FieldDiff{
+		{Field: "additional_rendezvous_seeds[2]", Old: base64.StdEncoding.EncodeToString(seed(9)), New: "", Identity: true},
+	}, link.Diff(func() *bertymessenger.BertyLink {
+		other := testContactLink()
+		other.BertyID.AdditionalRendezvousSeeds = [][]byte{seed(7), seed(8)}
+		return other
+	}()))
+
+	cases := []struct {
+		name  string
+		seeds [][]byte
+	}{
+		{"too-many", [][]byte{seed(7), seed(8), seed(9), seed(10)}},
+		{"empty", [][]byte{seed(7), {}}},
+		{"duplicate", [][]byte{seed(7), seed(7)}},
+		{"duplicate-public", [][]byte{seed(1)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			invalid := testContactLink()
+			invalid.BertyID.AdditionalRendezvousSeeds = tc.seeds
+			require.True(t, errcode.Is(invalid.IsValid(), errcode.ErrInvalidInput))
+			_, _, err := invalid.Marshal()
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+			// hand-crafted links are rejected too
+			bin, err := proto.Marshal(invalid)
+			require.NoError(t, err)
+			_, err = bertymessenger.UnmarshalLink("https://berty.tech/id#contact/" + base58.Encode(bin))
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		})
+	}
+}
+
+func TestLinkKeyLengths(t *testing.T) {
+	require.NoError(t, testContactLink().IsValid())
+	require.NoError(t, testGroupLink().IsValid())
+
+	cases := []struct {
+		name     string
+		webKind  string
+		truncate func(link *bertymessenger.BertyLink)
+	}{
+		{"account-pk", "contact", func(link *bertymessenger.BertyLink) {
+			link.BertyID.AccountPK = link.BertyID.AccountPK[:bertymessenger.LinkAccountPKLength-1]
+		}},
+		{"rendezvous-seed", "contact", func(link *bertymessenger.BertyLink) {
+			link.BertyID.PublicRendezvousSeed = link.BertyID.PublicRendezvousSeed[:3]
+		}},
+		{"additional-rendezvous-seed", "contact", func(link *bertymessenger.BertyLink) {
+			link.BertyID.AdditionalRendezvousSeeds = [][]byte{bytes.Repeat([]byte{7}, bertymessenger.LinkRendezvousSeedLength+1)}
+		}},
+		{"group-pk", "group", func(link *bertymessenger.BertyLink) {
+			link.BertyGroup.Group.PublicKey = link.BertyGroup.Group.PublicKey[:16]
+		}},
+		{"group-secret", "group", func(link *bertymessenger.BertyLink) {
+			link.BertyGroup.Group.Secret = link.BertyGroup.Group.Secret[:bertymessenger.LinkGroupSecretLength-1]
+		}},
+		{"group-secret-sig", "group", func(link *bertymessenger.BertyLink) {
+			link.BertyGroup.Group.SecretSig = link.BertyGroup.Group.SecretSig[:bertymessenger.LinkGroupPublicKeyLength]
+		}},
+		{"group-sign-pub", "group", func(link *bertymessenger.BertyLink) {
+			link.BertyGroup.Group.SignPub = link.BertyGroup.Group.SignPub[:1]
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link := testContactLink()
+			if tc.webKind == "group" {
+				link = testGroupLink()
+			}
+			tc.truncate(link)
+			require.True(t, errcode.Is(link.IsValid(), errcode.ErrInvalidInput))
+			_, _, err := link.Marshal()
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+			// hand-crafted links are rejected too
+			bin, err := proto.Marshal(link)
+			require.NoError(t, err)
+			_, err = bertymessenger.UnmarshalLink("https://berty.tech/id#" + tc.webKind + "/" + base58.Encode(bin))
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		})
+	}
+
+	// the group secret can be stripped, the missing keys are not checked
+	stripped := testGroupLink()
+	stripped.BertyGroup.Group.Secret = nil
+	stripped.BertyGroup.Group.SecretSig = nil
+	require.NoError(t, stripped.IsValid())
+
+	message := &bertymessenger.BertyLink{
+		Kind:            bertymessenger.BertyLink_MessageV1Kind,
+		BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, bertymessenger.LinkGroupPublicKeyLength-1)},
+	}
+	require.True(t, errcode.Is(message.IsValid(), errcode.ErrInvalidInput))
+}
+
+func TestMarshalMessageLink(t *testing.T) {
+	for _, cid := range []string{"", "bafyreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy"} {
+		link := &bertymessenger.BertyLink{
+			Kind: bertymessenger.BertyLink_MessageV1Kind,
+			BertyMessageRef: &bertymessenger.BertyMessageRef{
+				GroupPK:    bytes.Repeat([]byte{3}, 32),
+				MessageCID: cid,
+			},
+		}
+		require.True(t, link.IsMessage())
+		require.False(t, link.IsGroup())
+
+		internal, web, err := link.Marshal()
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(web, bertymessenger.LinkWebPrefix+"message/"), web)
+
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err)
+			require.Equal(t, link, parsed, uri)
+
+			kind, err := bertymessenger.LinkKindOf(uri)
+			require.NoError(t, err)
+			require.Equal(t, bertymessenger.BertyLink_MessageV1Kind, kind)
+		}
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, ref := range []*bertymessenger.BertyMessageRef{nil, {}, {MessageCID: "bafyrei"}} {
+			link := &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_MessageV1Kind, BertyMessageRef: ref}
+			require.True(t, errcode.Is(link.IsValid(), errcode.ErrMissingInput))
+			require.False(t, link.IsMessage())
+			_, _, err := link.Marshal()
+			require.Error(t, err)
+		}
+
+		mixed := testGroupLink()
+		mixed.BertyMessageRef = &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32)}
+		require.True(t, errcode.Is(mixed.IsValid(), errcode.ErrInvalidInput))
+		mixed.Kind = bertymessenger.BertyLink_MessageV1Kind
+		require.True(t, errcode.Is(mixed.IsValid(), errcode.ErrInvalidInput))
+	})
+}
+
+func TestMarshalAccountLink(t *testing.T) {
+	link := &bertymessenger.BertyLink{
+		Kind:    bertymessenger.BertyLink_AccountPubV1Kind,
+		BertyID: &bertymessenger.BertyID{AccountPK: bytes.Repeat([]byte{2}, 32)},
+	}
+	require.True(t, link.IsAccount())
+	require.False(t, link.IsContact())
+	require.False(t, testContactLink().IsAccount())
+
+	internal, web, err := link.Marshal()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(web, bertymessenger.LinkWebPrefix+"account/"), web)
+	for _, uri := range []string{internal, web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err)
+		require.Equal(t, link, parsed, uri)
+
+		kind, err := bertymessenger.LinkKindOf(uri)
+		require.NoError(t, err)
+		require.Equal(t, bertymessenger.BertyLink_AccountPubV1Kind, kind)
+	}
+
+	// it is the smallest link of all kinds
+	for _, other := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink(), {
+		Kind:            bertymessenger.BertyLink_MessageV1Kind,
+		BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32)},
+	}} {
+		otherInternal, _, err := other.Marshal()
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(qrString(internal)), len(qrString(otherInternal)), other.Kind)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, id := range []*bertymessenger.BertyID{nil, {}, {DisplayName: "Alice"}} {
+			invalid := &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_AccountPubV1Kind, BertyID: id}
+			require.True(t, errcode.Is(invalid.IsValid(), errcode.ErrMissingInput))
+			require.False(t, invalid.IsAccount())
+			_, _, err := invalid.Marshal()
+			require.Error(t, err)
+		}
+
+		// an account link can't be used to contact the account
+		for _, edit := range []func(id *bertymessenger.BertyID){
+			func(id *bertymessenger.BertyID) { id.PublicRendezvousSeed = bytes.Repeat([]byte{1}, 32) },
+			func(id *bertymessenger.BertyID) { id.AdditionalRendezvousSeeds = [][]byte{bytes.Repeat([]byte{7}, 32)} },
+			func(id *bertymessenger.BertyID) { id.DisplayName = "Alice" },
+			func(id *bertymessenger.BertyID) { id.ReferralCode = "spring-21" },
+			func(id *bertymessenger.BertyID) { id.AccountPK = id.AccountPK[:16] },
+		} {
+			invalid := proto.Clone(link).(*bertymessenger.BertyLink)
+			edit(invalid.BertyID)
+			require.True(t, errcode.Is(invalid.IsValid(), errcode.ErrInvalidInput))
+		}
+
+		mixed := proto.Clone(link).(*bertymessenger.BertyLink)
+		mixed.BertyGroup = testGroupLink().BertyGroup
+		require.True(t, errcode.Is(mixed.IsValid(), errcode.ErrInvalidInput))
+	})
+}
+
+func TestLinkIsForGroup(t *testing.T) {
+	group := testGroupLink()
+	groupPK := group.BertyGroup.Group.PublicKey
+
+	require.True(t, group.IsForGroup(groupPK))
+	require.True(t, group.IsForGroup(bytes.Repeat([]byte{3}, 32)))
+	require.False(t, group.IsForGroup(bytes.Repeat([]byte{42}, 32)))
+	require.False(t, group.IsForGroup(groupPK[:16]))
+	require.False(t, group.IsForGroup(nil))
+
+	// wrong kinds
+	contact := testContactLink()
+	require.False(t, contact.IsForGroup(contact.BertyID.AccountPK))
+	require.False(t, (&bertymessenger.BertyLink{}).IsForGroup(groupPK))
+	require.False(t, (*bertymessenger.BertyLink)(nil).IsForGroup(groupPK))
+}
+
 func TestUnmarshalLink(t *testing.T) {
 	cases := []struct {
-		name               string
-		input              string
-		expectedErrcode    error
-		expectValidContact bool
-		expectValidGroup   bool
-		expectedName       string
+		name               string
+		input              string
+		expectedErrcode    error
+		expectValidContact bool
+		expectValidGroup   bool
+		expectedName       string
+	}{
+		{"empty", "", errcode.ErrMissingInput, false, false, ""},
+		{"invalid", "invalid", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid2", "berty://id/#key=blah&name=blih", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid3", "https://berty.tech/id#key=blah&name=blih", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid8", "https://berty.tech/id#contact/foobar/name=Alice", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid9", "https://berty.tech/id#group/foobar/name=Alice", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid10", "https://berty.tech/id#foobar/foobar/name=Alice", errcode.ErrLinkUnknownKind, false, false, ""},
+		{"invalid11", "https://berty.tech/id#foobar", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid12", "https://berty.tech/id#", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid13", "https://berty.tech/id", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid14", "https://berty.tech/", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid15", "https://berty.tech@invalid.domain/id#contact/" + validContactBlob + "/name=Alice", errcode.ErrInvalidInput, false, false, ""},
+		{"invalid16", "https://invalid.domain/contact#contact/" + validContactBlob + "/name=Alice", errcode.ErrInvalidInput, false, false, ""},
+		{"valid-web-contact-v1-other-host", "https://chat.example.org/id#contact/" + validContactBlob + "/name=Alice", nil, true, false, "Alice"},
+		{"valid-web-contact-v1-with-name", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice", nil, true, false, "Alice"},
+		{"valid-internal-contact-v1", "BERTY://PB/" + validContactInternalBlob, nil, true, false, "moul (cli)"},
+		{"valid-internal-contact-v1-alternative-scheme", "berty://pb/" + validContactInternalBlob, nil, true, false, "moul (cli)"},
+		{"valid-web-contact-v1-without-name", "https://berty.tech/id#contact/" + validContactBlob, nil, true, false, ""},
+		{"valid-web-contact-v1-with-unnecessary-fields", "https://berty.tech/id#contact/" + validContactBlob + "/foo=bar", nil, true, false, ""},
+		{"valid-web-contact-v1-with-unnecessary-fields-and-name-1", "https://berty.tech/id#contact/" + validContactBlob + "/foo=bar&name=Alice", nil, true, false, "Alice"},
+		{"valid-web-contact-v1-with-unnecessary-fields-and-name-2", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice&foo=bar", nil, true, false, "Alice"},
+		{"valid-web-contact-v1-with-unnecessary-fields-and-name-3", "https://berty.tech/id#contact/" + validContactBlob + "/bar=foo&name=Alice&foo=bar", nil, true, false, "Alice"},
+		{"valid-web-group-v1", "https://berty.tech/id#group/" + validGroupBlob + "/name=random-group-34191", nil, false, true, "random-group-34191"},
+		{"valid-internal-group-v1", "BERTY://PB/" + validGroupInternalBlob, nil, false, true, "random-group-34191"},
+		{"valid-escaped-name-1", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%20Foobar", nil, true, false, "Alice Foobar"},
+		{"valid-escaped-name-2", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice+Foobar", nil, true, false, "Alice Foobar"},
+		{"valid-escaped-name-3", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice Foobar", nil, true, false, "Alice Foobar"},
+		{"valid-escaped-name-4", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice/Foobar", nil, true, false, "Alice/Foobar"},
+		{"valid-escaped-name-5", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%2FFoobar", nil, true, false, "Alice/Foobar"},
+		{"valid-escaped-name-6", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%2fFoobar", nil, true, false, "Alice/Foobar"},
+		{"valid-escaped-name-7", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%26Bob", nil, true, false, "Alice&Bob"},
+		{"valid-escaped-name-8", "https://berty.tech/id#contact/" + validContactBlob + "/foo=bar&name=Alice%26Bob&bar=foo", nil, true, false, "Alice&Bob"},
+		{"valid-escaped-name-9", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%23Bob", nil, true, false, "Alice#Bob"},
+		{"valid-escaped-name-10", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice#Bob#Charlie", nil, true, false, "Alice#Bob#Charlie"},
+		{"valid-escaped-name-11", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice/Bob/Charlie&foo=bar%2Fbaz", nil, true, false, "Alice/Bob/Charlie"},
+		{"valid-escaped-name-12", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%2523Bob", nil, true, false, "Alice%23Bob"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := bertymessenger.UnmarshalLink(tc.input)
+			if tc.expectedErrcode == nil {
+				tc.expectedErrcode = errcode.ErrCode(-1)
+			}
+			assert.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
+			if tc.expectValidContact {
+				assert.True(t, link.IsContact())
+				assert.Equal(t, tc.expectedName, link.BertyID.DisplayName)
+			}
+			if tc.expectValidGroup {
+				assert.True(t, link.IsGroup())
+				assert.Equal(t, tc.expectedName, link.BertyGroup.DisplayName)
+			}
+		})
+	}
+}
+
+func TestUnmarshalLinkWebQuery(t *testing.T) {
+	prefix := "https://berty.tech/id#contact/" + validContactBlob + "/"
+	cases := []struct {
+		name             string
+		query            string
+		expectedName     string
+		expectedMetadata map[string]string
+	}{
+		{"raw-slash", "name=AC/DC+fans", "AC/DC fans", nil},
+		{"encoded-slash", "name=AC%2FDC+fans", "AC/DC fans", nil},
+		{"slashes-everywhere", "name=/a//b/&bio=//", "/a//b/", map[string]string{"bio": "//"}},
+		{"multiple-equal", "name=a=b=c&bio=x==y", "a=b=c", map[string]string{"bio": "x==y"}},
+		{"empty-values", "name=&bio=", "", map[string]string{"bio": ""}},
+		{"empty-query", "", "", nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := bertymessenger.UnmarshalLink(prefix + tc.query)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedName, link.BertyID.DisplayName)
+			require.Equal(t, tc.expectedMetadata, link.Metadata)
+
+			kind, err := bertymessenger.ValidateURI(prefix + tc.query)
+			require.NoError(t, err)
+			require.Equal(t, bertymessenger.BertyLink_ContactInviteV1Kind, kind)
+		})
+	}
+
+	// generated links with the same chars
+	group := testGroupLink()
+	group.BertyGroup.DisplayName = "AC/DC fans = a/b & c/d"
+	group.Metadata = map[string]string{"bio": "", "path": "/x/y/"}
+	_, web, err := group.Marshal()
+	require.NoError(t, err)
+	parsed, err := bertymessenger.UnmarshalLink(web)
+	require.NoError(t, err)
+	require.Equal(t, group, parsed)
+}
+
+func TestUnmarshalLinkAuthorityForms(t *testing.T) {
+	internal, _, err := testContactLink().Marshal()
+	require.NoError(t, err)
+	payload := strings.TrimPrefix(internal, "BERTY://PB1/")
+
+	cases := []struct {
+		name  string
+		input string
+	}{
+		{"raw", internal},
+		{"lowercased-host", "berty://pb1/" + payload},
+		{"lowercased-all", strings.ToLower(internal)},
+		{"empty-authority", "berty:///PB1/" + payload},
+		{"opaque", "berty:PB1/" + payload},
+		{"mixed-case", "Berty://Pb1/" + payload},
+		{"percent-encoded-path", "berty://pb1/" + url.PathEscape(payload)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := bertymessenger.UnmarshalLink(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, testContactLink(), link)
+		})
+	}
+}
+
+func TestUnmarshalInternalAndWebLink(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		internal, web, err := link.Marshal()
+		require.NoError(t, err)
+
+		parsed, err := bertymessenger.UnmarshalInternalLink(internal)
+		require.NoError(t, err)
+		require.Equal(t, link, parsed)
+		parsed, err = bertymessenger.UnmarshalWebLink(web)
+		require.NoError(t, err)
+		require.Equal(t, link, parsed)
+
+		// the other form is rejected
+		_, err = bertymessenger.UnmarshalInternalLink(web)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), web)
+		_, err = bertymessenger.UnmarshalWebLink(internal)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), internal)
+	}
+
+	// the options and the common checks apply
+	expired := testContactLink()
+	expired.ExpiresAtUnix = time.Now().Add(-time.Hour).Unix()
+	internal, web, err := expired.Marshal()
+	require.NoError(t, err)
+	_, err = bertymessenger.UnmarshalInternalLink(internal)
+	require.True(t, errcode.Is(err, errcode.ErrLinkExpired))
+	_, err = bertymessenger.UnmarshalWebLink(web)
+	require.True(t, errcode.Is(err, errcode.ErrLinkExpired))
+	_, err = bertymessenger.UnmarshalInternalLink(internal, bertymessenger.WithAllowExpired())
+	require.NoError(t, err)
+	_, err = bertymessenger.UnmarshalWebLink(web, bertymessenger.WithAllowExpired())
+	require.NoError(t, err)
+
+	for _, unmarshal := range []func(string, ...bertymessenger.UnmarshalOption) (*bertymessenger.BertyLink, error){
+		bertymessenger.UnmarshalInternalLink,
+		bertymessenger.UnmarshalWebLink,
+	} {
+		_, err := unmarshal("")
+		require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+		_, err = unmarshal("invalid")
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	}
+}
+
+func TestUnmarshalLinkInternalVersions(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		internal, _, err := link.Marshal()
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(internal, "BERTY://PB1/"), internal)
+		payload := strings.TrimPrefix(internal, "BERTY://PB1/")
+
+		// the unversioned links of the older apps are decoded as the first version
+		versioned, err := bertymessenger.UnmarshalLink("BERTY://PB1/" + payload)
+		require.NoError(t, err)
+		legacy, err := bertymessenger.UnmarshalLink("BERTY://PB/" + payload)
+		require.NoError(t, err)
+		require.Equal(t, link, versioned)
+		require.Equal(t, versioned, legacy)
+
+		for _, uri := range []string{"BERTY://PB/" + payload, "BERTY://PB1/" + payload} {
+			kind, err := bertymessenger.LinkKindOf(uri)
+			require.NoError(t, err)
+			require.Equal(t, link.Kind, kind)
+		}
+
+		// the unknown versions are rejected
+		for _, marker := range []string{"PB2", "PB0", "PB01"} {
+			_, err = bertymessenger.UnmarshalLink("BERTY://" + marker + "/" + payload)
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput), marker)
+		}
+	}
+
+	// the links of the older apps are still decoded
+	link, err := bertymessenger.UnmarshalLink("BERTY://PB/" + validContactInternalBlob)
+	require.NoError(t, err)
+	require.True(t, link.IsContact())
+}
+
+func TestUnmarshalLinkSchemeCasing(t *testing.T) {
+	message := &bertymessenger.BertyLink{
+		Kind:            bertymessenger.BertyLink_MessageV1Kind,
+		BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32)},
+	}
+
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink(), message} {
+		internal, web, err := link.Marshal()
+		require.NoError(t, err)
+
+		// canonical casing
+		require.True(t, strings.HasPrefix(internal, "BERTY://PB1/"), internal)
+		require.Equal(t, strings.ToUpper(internal), internal)
+		require.True(t, strings.HasPrefix(web, "https://berty.tech/id#"), web)
+
+		payload := strings.TrimPrefix(internal, "BERTY://PB1/")
+		fragment := strings.TrimPrefix(web, bertymessenger.LinkWebPrefix)
+		cases := []struct {
+			name  string
+			input string
+		}{
+			{"internal", internal},
+			{"internal-lowercased", strings.ToLower(internal)},
+			{"internal-lowercased-scheme", "berty://PB1/" + payload},
+			{"internal-lowercased-segment", "BERTY://pb1/" + payload},
+			{"internal-mixed-case", "bErTy://pB1/" + payload},
+			{"web", web},
+			{"web-uppercased-prefix", "HTTPS://BERTY.TECH/ID#" + fragment},
+			{"web-mixed-case-prefix", "Https://Berty.Tech/Id#" + fragment},
+		}
+		for _, tc := range cases {
+			t.Run(link.Kind.String()+"/"+tc.name, func(t *testing.T) {
+				parsed, err := bertymessenger.UnmarshalLink(tc.input)
+				require.NoError(t, err)
+				require.Equal(t, link, parsed)
+
+				kind, err := bertymessenger.LinkKindOf(tc.input)
+				require.NoError(t, err)
+				require.Equal(t, link.Kind, kind)
+			})
+		}
+	}
+}
+
+func TestUnmarshalLinkWithHTMLUnwrap(t *testing.T) {
+	link := testContactLink()
+	link.Metadata = map[string]string{"lang": "fr"}
+	internal, web, err := link.Marshal()
+	require.NoError(t, err)
+	require.Contains(t, web, "&")
+	escapedWeb := strings.ReplaceAll(web, "&", "&amp;")
+
+	cases := []struct {
+		name  string
+		input string
+		uri   string
+	}{
+		{"plain-internal", internal, internal},
+		{"plain-web", "  " + web + "\n", web},
+		{"html-anchor", `<a href="` + escapedWeb + `">Add me on Berty</a>`, web},
+		{"html-anchor-attributes", `<A class="link" HREF='` + internal + `' target="_blank">Alice</A>`, internal},
+		{"markdown-link", "[Add me on Berty](" + internal + ")", internal},
+		{"markdown-link-with-title", "[Alice](<" + web + `> "Alice on Berty")`, web},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected, err := bertymessenger.UnmarshalLink(tc.uri)
+			require.NoError(t, err)
+			parsed, err := bertymessenger.UnmarshalLink(tc.input, bertymessenger.WithHTMLUnwrap())
+			require.NoError(t, err)
+			assert.Equal(t, expected, parsed)
+		})
+	}
+
+	// without the option, the wrapped links are rejected
+	_, err = bertymessenger.UnmarshalLink("[Add me on Berty](" + internal + ")")
+	require.Error(t, err)
+}
+
+func TestUnmarshalLinkWithNestedLinkUnwrap(t *testing.T) {
+	link := testContactLink()
+	link.Metadata = map[string]string{"lang": "fr"}
+	internal, web, err := link.Marshal()
+	require.NoError(t, err)
+	wrap := func(uri string) string {
+		return "https://share.example.org/intent?title=Alice&u=" + url.QueryEscape(uri)
+	}
+
+	cases := []struct {
+		name  string
+		input string
+		uri   string
+	}{
+		{"plain-web", web, web},
+		{"plain-internal", internal, internal},
+		{"single-web", wrap(web), web},
+		{"single-internal", wrap(internal), internal},
+		{"double", "https://redirect.example.org/?to=" + url.QueryEscape(wrap(web)), web},
+		{"triple", "https://redirect.example.org/?to=" + url.QueryEscape("https://redirect.example.org/?to="+url.QueryEscape(wrap(web))), web},
+		{"unescaped-fragment", "https://share.example.org/?u=" + web, web},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected, err := bertymessenger.UnmarshalLink(tc.uri)
+			require.NoError(t, err)
+			parsed, err := bertymessenger.UnmarshalLink(tc.input, bertymessenger.WithNestedLinkUnwrap())
+			require.NoError(t, err)
+			assert.Equal(t, expected, parsed)
+		})
+	}
+
+	// the depth is capped
+	nested := wrap(web)
+	for i := 0; i < 10; i++ {
+		nested = "https://redirect.example.org/?to=" + url.QueryEscape(nested)
+	}
+	_, err = bertymessenger.UnmarshalLink(nested, bertymessenger.WithNestedLinkUnwrap())
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	require.Contains(t, err.Error(), "link wrapped in more than 3 URLs")
+
+	// the URLs without a wrapped link are rejected as usual, and the wrapped links are rejected without the option
+	_, err = bertymessenger.UnmarshalLink("https://share.example.org/?u=https%3A%2F%2Fexample.org%2F", bertymessenger.WithNestedLinkUnwrap())
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = bertymessenger.UnmarshalLink(wrap(web))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestUnmarshalLinkValidityPeriod(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour).Unix(), now.Add(time.Hour).Unix()
+
+	cases := []struct {
+		name            string
+		notBefore       int64
+		expiresAt       int64
+		expectActive    bool
+		expectedErrcode errcode.ErrCode
 	}{
-		{"empty", "", errcode.ErrMissingInput, false, false, ""},
-		{"invalid", "invalid", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid2", "berty://id/#key=blah&name=blih", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid3", "https://berty.tech/id#key=blah&name=blih", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid4", "berty://id/#key=CiDXcXUOl1rpm2FcbOf3TFtn-FYkl_sOwA5run1LGXHOPRIg4xCLGP-BWzgIWRH0Vz9D8aGAq1kyno5Oqv6ysAljZmA&name=Alice", errcode.ErrInvalidInput, false, false, ""},           // previous format
-		{"invalid5", "https://berty.tech/id#key=CiDXcXUOl1rpm2FcbOf3TFtn-FYkl_sOwA5run1LGXHOPRIg4xCLGP-BWzgIWRH0Vz9D8aGAq1kyno5Oqv6ysAljZmA&name=Alice", errcode.ErrInvalidInput, false, false, ""}, // previous format
-		{"invalid6", "berty://id/#key=CiDXcXUOl1rpm2FcbOf3TFtn-FYkl_sOwA5run1LGXHOPRIg4xCLGP-BWzgIWRH0Vz9D8aGAq1kyno5Oqv6ysAljZmA", errcode.ErrInvalidInput, false, false, ""},                      // previous format
-		{"invalid7", "https://berty.tech/id#key=CiDXcXUOl1rpm2FcbOf3TFtn-FYkl_sOwA5run1LGXHOPRIg4xCLGP-BWzgIWRH0Vz9D8aGAq1kyno5Oqv6ysAljZmA", errcode.ErrInvalidInput, false, false, ""},            // previous format
-		{"invalid8", "https://berty.tech/id#contact/foobar/name=Alice", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid9", "https://berty.tech/id#group/foobar/name=Alice", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid10", "https://berty.tech/id#foobar/foobar/name=Alice", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid11", "https://berty.tech/id#foobar", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid12", "https://berty.tech/id#", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid13", "https://berty.tech/id", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid14", "https://berty.tech/", errcode.ErrInvalidInput, false, false, ""},
-		{"invalid15", "https://invalid.domain/id#contact/" + validContactBlob + "/name=Alice", errcode.ErrInvalidInput, false, false, ""},
-		{"valid-web-contact-v1-with-name", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice", nil, true, false, "Alice"},
-		{"valid-internal-contact-v1", "BERTY://PB/" + validContactInternalBlob, nil, true, false, "moul (cli)"},
-		{"valid-internal-contact-v1-alternative-scheme", "berty://pb/" + validContactInternalBlob, nil, true, false, "moul (cli)"},
-		{"valid-web-contact-v1-without-name", "https://berty.tech/id#contact/" + validContactBlob, nil, true, false, ""},
-		{"valid-web-contact-v1-with-unnecessary-fields", "https://berty.tech/id#contact/" + validContactBlob + "/foo=bar", nil, true, false, ""},
-		{"valid-web-contact-v1-with-unnecessary-fields-and-name-1", "https://berty.tech/id#contact/" + validContactBlob + "/foo=bar&name=Alice", nil, true, false, "Alice"},
-		{"valid-web-contact-v1-with-unnecessary-fields-and-name-2", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice&foo=bar", nil, true, false, "Alice"},
-		{"valid-web-contact-v1-with-unnecessary-fields-and-name-3", "https://berty.tech/id#contact/" + validContactBlob + "/bar=foo&name=Alice&foo=bar", nil, true, false, "Alice"},
-		{"valid-web-group-v1", "https://berty.tech/id#group/" + validGroupBlob + "/name=random-group-34191", nil, false, true, "random-group-34191"},
-		{"valid-internal-group-v1", "BERTY://PB/" + validGroupInternalBlob, nil, false, true, "random-group-34191"},
-		{"valid-escaped-name-1", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%20Foobar", nil, true, false, "Alice Foobar"},
-		{"valid-escaped-name-2", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice+Foobar", nil, true, false, "Alice Foobar"},
-		{"valid-escaped-name-3", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice Foobar", nil, true, false, "Alice Foobar"},
-		{"valid-escaped-name-4", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice/Foobar", nil, true, false, "Alice/Foobar"},
-		{"valid-escaped-name-5", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%2FFoobar", nil, true, false, "Alice/Foobar"},
-		{"valid-escaped-name-6", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%2fFoobar", nil, true, false, "Alice/Foobar"},
-		{"valid-escaped-name-7", "https://berty.tech/id#contact/" + validContactBlob + "/name=Alice%26Bob", nil, true, false, "Alice&Bob"},
-		{"valid-escaped-name-8", "https://berty.tech/id#contact/" + validContactBlob + "/foo=bar&name=Alice%26Bob&bar=foo", nil, true, false, "Alice&Bob"},
+		{"no-validity-period", 0, 0, true, 0},
+		{"active", past, future, true, 0},
+		{"active-without-expiry", past, 0, true, 0},
+		{"not-yet-valid", future, 0, false, errcode.ErrLinkNotYetValid},
+		{"expired", 0, past, false, errcode.ErrLinkExpired},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			link, err := bertymessenger.UnmarshalLink(tc.input)
-			if tc.expectedErrcode == nil {
-				tc.expectedErrcode = errcode.ErrCode(-1)
+			link := testContactLink()
+			link.NotBeforeUnix = tc.notBefore
+			link.ExpiresAtUnix = tc.expiresAt
+			assert.Equal(t, tc.expectActive, link.IsActive(now))
+			assert.Equal(t, tc.expectedErrcode == errcode.ErrLinkExpired, link.IsExpired(now))
+			if tc.expiresAt == 0 {
+				assert.True(t, link.ExpiresAt().IsZero())
+			} else {
+				assert.Equal(t, time.Unix(tc.expiresAt, 0), link.ExpiresAt())
+			}
+
+			internal, web, err := link.Marshal()
+			require.NoError(t, err)
+
+			for _, uri := range []string{internal, web} {
+				parsed, err := bertymessenger.UnmarshalLink(uri)
+				if !tc.expectActive {
+					require.True(t, errcode.Is(err, tc.expectedErrcode))
+					require.Nil(t, parsed)
+				} else {
+					require.NoError(t, err)
+					assert.Equal(t, link, parsed)
+				}
+
+				// leniency override
+				parsed, err = bertymessenger.UnmarshalLink(uri, bertymessenger.WithAllowExpired())
+				require.NoError(t, err)
+				assert.Equal(t, link, parsed)
+				assert.Equal(t, link.ExpiresAt(), parsed.ExpiresAt())
 			}
+		})
+	}
+
+	t.Run("zero-expiry-backward-compatible", func(t *testing.T) {
+		// a zero expiry adds nothing to the links
+		link := testGroupLink()
+		internal, web, err := link.Marshal()
+		require.NoError(t, err)
+		require.NotContains(t, web, "exp=")
+
+		link.ExpiresAtUnix = future
+		expiringInternal, expiringWeb, err := link.Marshal()
+		require.NoError(t, err)
+		require.NotEqual(t, internal, expiringInternal)
+		require.Contains(t, expiringWeb, "exp="+strconv.FormatInt(future, 10))
+
+		require.True(t, link.IsExpired(now.Add(2*time.Hour)))
+		require.False(t, link.IsExpired(now))
+		require.False(t, (*bertymessenger.BertyLink)(nil).IsExpired(now))
+	})
+
+	t.Run("invalid-web-timestamp", func(t *testing.T) {
+		_, web, err := testContactLink().Marshal()
+		require.NoError(t, err)
+		_, err = bertymessenger.UnmarshalLink(web + "&exp=tomorrow")
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	})
+}
+
+func TestLinkRecommendedExpiry(t *testing.T) {
+	group := testGroupLink()
+	contact := testContactLink()
+	require.Greater(t, int64(group.RecommendedExpiry()), int64(0))
+	require.Equal(t, bertymessenger.RecommendedSecretLinkExpiry, group.RecommendedExpiry())
+	require.Zero(t, contact.RecommendedExpiry())
+
+	// without the secret, a group link is as public as a contact link
+	group.BertyGroup.Group.Secret = nil
+	require.Zero(t, group.RecommendedExpiry())
+
+	require.Zero(t, (&bertymessenger.BertyLink{}).RecommendedExpiry())
+}
+
+func TestLinkExpiryDisplayHint(t *testing.T) {
+	expiresAt := time.Now().Add(48 * time.Hour).Unix()
+
+	t.Run("default", func(t *testing.T) {
+		link := testContactLink()
+		link.ExpiresAtUnix = expiresAt
+		_, web, err := link.Marshal()
+		require.NoError(t, err)
+		assert.NotContains(t, web, "exp_hint=")
+
+		parsed, err := bertymessenger.UnmarshalLink(web)
+		require.NoError(t, err)
+		assert.Equal(t, bertymessenger.BertyLink_ExpiryRelative, parsed.ExpiryDisplayHint)
+	})
+
+	t.Run("round-trip", func(t *testing.T) {
+		link := testGroupLink()
+		link.ExpiresAtUnix = expiresAt
+		link.ExpiryDisplayHint = bertymessenger.BertyLink_ExpiryAbsolute
+		internal, web, err := link.Marshal()
+		require.NoError(t, err)
+		assert.Contains(t, web, "exp_hint=absolute")
+
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err)
+			assert.Equal(t, link, parsed)
+		}
+	})
+
+	t.Run("unknown-web-hint", func(t *testing.T) {
+		_, web, err := testContactLink().Marshal()
+		require.NoError(t, err)
+		parsed, err := bertymessenger.UnmarshalLink(web + "&exp_hint=countdown")
+		require.NoError(t, err)
+		assert.Equal(t, bertymessenger.BertyLink_ExpiryRelative, parsed.ExpiryDisplayHint)
+		assert.Empty(t, parsed.Metadata)
+	})
+
+	t.Run("unsupported-hint", func(t *testing.T) {
+		link := testContactLink()
+		link.ExpiryDisplayHint = 42
+		_, _, err := link.Marshal()
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	})
+}
+
+func TestUnmarshalLinkDoubleDecodeFallback(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			_, web, err := link.Marshal()
+			require.NoError(t, err)
+
+			// double-encode the blob, like some buggy third-party tools
+			parts := strings.Split(strings.TrimPrefix(web, bertymessenger.LinkWebPrefix), "/")
+			parts[1] = base58.Encode([]byte(parts[1]))
+			doubleEncoded := bertymessenger.LinkWebPrefix + strings.Join(parts, "/")
+
+			_, err = bertymessenger.UnmarshalLink(doubleEncoded)
+			require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+			parsed, err := bertymessenger.UnmarshalLink(doubleEncoded, bertymessenger.WithDoubleDecodeFallback())
+			require.NoError(t, err)
+			assert.Equal(t, link, parsed)
+
+			// the option does not change the decoding of the regular links
+			parsed, err = bertymessenger.UnmarshalLink(web, bertymessenger.WithDoubleDecodeFallback())
+			require.NoError(t, err)
+			assert.Equal(t, link, parsed)
+		})
+	}
+
+	_, err := bertymessenger.UnmarshalLink("https://berty.tech/id#contact/foobar/name=Alice", bertymessenger.WithDoubleDecodeFallback())
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestLinkKindOf(t *testing.T) {
+	contactInternal, contactWeb, err := testContactLink().Marshal()
+	require.NoError(t, err)
+	groupInternal, groupWeb, err := testGroupLink().Marshal()
+	require.NoError(t, err)
+
+	cases := []struct {
+		name            string
+		input           string
+		expectedKind    bertymessenger.BertyLink_Kind
+		expectedErrcode errcode.ErrCode
+	}{
+		{"contact-internal", contactInternal, bertymessenger.BertyLink_ContactInviteV1Kind, -1},
+		{"contact-web", contactWeb, bertymessenger.BertyLink_ContactInviteV1Kind, -1},
+		{"group-internal", groupInternal, bertymessenger.BertyLink_GroupV1Kind, -1},
+		{"group-web", groupWeb, bertymessenger.BertyLink_GroupV1Kind, -1},
+		{"legacy-contact-internal", "BERTY://PB/" + validContactInternalBlob, bertymessenger.BertyLink_ContactInviteV1Kind, -1},
+		{"empty", "", bertymessenger.BertyLink_UnknownKind, errcode.ErrMissingInput},
+		{"invalid", "invalid", bertymessenger.BertyLink_UnknownKind, errcode.ErrInvalidInput},
+		{"unknown-web-kind", "https://berty.tech/id#foobar/" + validContactBlob, bertymessenger.BertyLink_UnknownKind, errcode.ErrLinkUnknownKind},
+		{"upcoming-web-kind", "https://berty.tech/id#contacts/" + validContactBlob, bertymessenger.BertyLink_UnknownKind, errcode.ErrLinkNeedsUpdate},
+		{"empty-web-fragment", "https://berty.tech/id#", bertymessenger.BertyLink_UnknownKind, errcode.ErrInvalidInput},
+		{"invalid-internal-blob", "BERTY://PB/%%%", bertymessenger.BertyLink_UnknownKind, errcode.ErrInvalidInput},
+		{"unsupported-internal-type", "BERTY://FOO/" + validContactInternalBlob, bertymessenger.BertyLink_UnknownKind, errcode.ErrInvalidInput},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			kind, err := bertymessenger.LinkKindOf(tc.input)
 			assert.Equal(t, tc.expectedErrcode.Error(), errcode.Code(err).Error())
-			if tc.expectValidContact {
-				assert.True(t, link.IsContact())
-				assert.Equal(t, tc.expectedName, link.BertyID.DisplayName)
+			assert.Equal(t, tc.expectedKind, kind)
+		})
+	}
+}
+
+func BenchmarkLinkKindOf(b *testing.B) {
+	internal, web, err := testGroupLink().Marshal()
+	require.NoError(b, err)
+
+	forms := []struct {
+		name string
+		uri  string
+	}{{"internal", internal}, {"web", web}}
+	for _, form := range forms {
+		uri := form.uri
+		b.Run(form.name+"/LinkKindOf", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _ = bertymessenger.LinkKindOf(uri)
 			}
-			if tc.expectValidGroup {
-				assert.True(t, link.IsGroup())
-				assert.Equal(t, tc.expectedName, link.BertyGroup.DisplayName)
+		})
+		b.Run(form.name+"/UnmarshalLink", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _ = bertymessenger.UnmarshalLink(uri)
 			}
 		})
 	}
 }
 
+func TestMarshalLinkSingleForm(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		for _, opts := range [][]bertymessenger.MarshalOption{
+			nil,
+			{bertymessenger.WithoutDisplayName(), bertymessenger.WithScanChecksum()},
+			{bertymessenger.WithGroupedPayload(4), bertymessenger.WithAnalyticsTag("dir")},
+		} {
+			internal, web, err := link.Marshal(opts...)
+			require.NoError(t, err)
+			webOnly, err := link.MarshalWebOnly(opts...)
+			require.NoError(t, err)
+			require.Equal(t, web, webOnly)
+			internalOnly, err := link.MarshalInternalOnly(opts...)
+			require.NoError(t, err)
+			require.Equal(t, internal, internalOnly)
+		}
+	}
+
+	// only the web form has a length limit
+	link := testGroupLink()
+	link.Metadata = map[string]string{"bio": strings.Repeat("x", bertymessenger.DefaultURLLengthLimit)}
+	_, err := link.MarshalWebOnly()
+	require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+	_, err = link.MarshalInternalOnly()
+	require.NoError(t, err)
+
+	// both forms check the link
+	link.Metadata = map[string]string{"name": "reserved"}
+	_, err = link.MarshalWebOnly()
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = link.MarshalInternalOnly()
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = (&bertymessenger.BertyLink{}).MarshalInternalOnly()
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+}
+
+func BenchmarkMarshalContact(b *testing.B) {
+	benchmarkMarshal(b, testContactLink())
+}
+
+func BenchmarkMarshalGroup(b *testing.B) {
+	benchmarkMarshal(b, testGroupLink())
+}
+
+func benchmarkMarshal(b *testing.B, link *bertymessenger.BertyLink) {
+	link.Metadata = map[string]string{"bio": "hello", "color": "blue"}
+	b.Run("Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _, _ = link.Marshal()
+		}
+	})
+	b.Run("MarshalWebOnly", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = link.MarshalWebOnly()
+		}
+	})
+	b.Run("MarshalInternalOnly", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = link.MarshalInternalOnly()
+		}
+	})
+}
+
+func TestValidateURI(t *testing.T) {
+	message := &bertymessenger.BertyLink{
+		Kind:            bertymessenger.BertyLink_MessageV1Kind,
+		BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32)},
+	}
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink(), message} {
+		internal, web, err := link.Marshal()
+		require.NoError(t, err)
+		for _, uri := range []string{internal, web} {
+			kind, err := bertymessenger.ValidateURI(uri)
+			require.NoError(t, err)
+			require.Equal(t, link.Kind, kind)
+		}
+	}
+
+	// the malformed links return the same errors as UnmarshalLink
+	for _, uri := range []string{
+		"",
+		"invalid",
+		"https://berty.tech/id#",
+		"https://berty.tech/id#contact",
+		"https://berty.tech/id#foobar/" + validContactBlob,
+		"https://berty.tech/id#contacts/" + validContactBlob,
+		"https://berty.tech/id#contact/0OIl",
+		"https://berty.tech/id#contact/" + base58.Encode([]byte{0xff, 0xff}),
+		"https://berty.tech/id#contact/" + validContactBlob + "/exp=tomorrow",
+		"https://berty.tech/id#contact/" + validContactBlob + "/name=%zz",
+		"https://berty.tech/id#pin/" + validContactBlob,
+		"BERTY://PB/%%%",
+		"BERTY://FOO/" + validContactInternalBlob,
+		"BERTY://PC/ABC",
+	} {
+		kind, err := bertymessenger.ValidateURI(uri)
+		require.Error(t, err, uri)
+		require.Equal(t, bertymessenger.BertyLink_UnknownKind, kind)
+		_, expectedErr := bertymessenger.UnmarshalLink(uri)
+		require.Equal(t, errcode.Code(expectedErr), errcode.Code(err), uri)
+	}
+}
+
 func TestMarshalLinkFuzzing(t *testing.T) {
 	rand.Seed(srand.Fast())
 	for i := 0; i < 100; i++ {
@@ -227,14 +1884,118 @@ func TestMarshalLinkFuzzing(t *testing.T) {
 			len(qrString(web)),
 		)
 
-		// unmarshal and compare with original input
+		// unmarshal and compare with original input, the name of the web link being sanitized
+		internalLink, err := bertymessenger.UnmarshalLink(internal)
+		require.NoError(t, err)
+		assert.Equal(t, link, internalLink)
+
 		webLink, err := bertymessenger.UnmarshalLink(web)
 		require.NoError(t, err)
+		link.BertyID.DisplayName = bertymessenger.SanitizeDisplayName(link.BertyID.DisplayName)
 		assert.Equal(t, link, webLink)
+	}
+}
 
-		internalLink, err := bertymessenger.UnmarshalLink(internal)
-		require.NoError(t, err)
-		assert.Equal(t, link, internalLink)
+// TestMarshalLinkIsValidInvariant checks that Marshal never succeeds on a link rejected by IsValid,
+// and that the generated links are always decoded as valid links.
+func TestMarshalLinkIsValidInvariant(t *testing.T) {
+	rand.Seed(srand.Fast())
+	randomBytes := func() []byte {
+		switch rand.Intn(4) {
+		case 0:
+			return nil
+		case 1:
+			return []byte{}
+		default:
+			b := make([]byte, 1+rand.Intn(64))
+			rand.Read(b)
+			return b
+		}
+	}
+	randomString := func() string {
+		if rand.Intn(2) == 0 {
+			return ""
+		}
+		return []string{"name", "nbf", "exp", "a", "a&b=c", "a/b", "%zz", "\u00e9t\u00e9", " "}[rand.Intn(9)]
+	}
+
+	for i := 0; i < 1000; i++ {
+		link := &bertymessenger.BertyLink{
+			Kind: bertymessenger.BertyLink_Kind(rand.Intn(5)),
+		}
+		if rand.Intn(3) > 0 {
+			link.BertyID = &bertymessenger.BertyID{
+				PublicRendezvousSeed: randomBytes(),
+				AccountPK:            randomBytes(),
+				DisplayName:          randomString(),
+				ReferralCode:         randomString(),
+			}
+		}
+		if rand.Intn(3) > 0 {
+			link.BertyGroup = &bertymessenger.BertyGroup{DisplayName: randomString()}
+			if rand.Intn(4) > 0 {
+				link.BertyGroup.Group = &bertytypes.Group{
+					PublicKey: randomBytes(),
+					Secret:    randomBytes(),
+					SecretSig: randomBytes(),
+					GroupType: bertytypes.GroupType(rand.Intn(4)),
+					SignPub:   randomBytes(),
+				}
+			}
+		}
+		if rand.Intn(2) == 0 {
+			link.Metadata = map[string]string{}
+			for j := rand.Intn(3); j > 0; j-- {
+				link.Metadata[randomString()] = randomString()
+			}
+		}
+		if rand.Intn(4) == 0 {
+			link.NotBeforeUnix = rand.Int63n(1<<40) - 1<<39
+		}
+		if rand.Intn(4) == 0 {
+			link.ExpiresAtUnix = rand.Int63n(1<<40) - 1<<39
+		}
+
+		internal, web, err := link.Marshal()
+		if link.IsValid() != nil {
+			require.Error(t, err, "Marshal succeeded on an invalid link: %v", link)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for _, uri := range []string{internal, web} {
+			parsed, err := bertymessenger.UnmarshalLink(uri, bertymessenger.WithAllowExpired())
+			require.NoError(t, err, uri)
+			require.NoError(t, parsed.IsValid(), uri)
+		}
+	}
+}
+
+func testContactLink() *bertymessenger.BertyLink {
+	return &bertymessenger.BertyLink{
+		Kind: bertymessenger.BertyLink_ContactInviteV1Kind,
+		BertyID: &bertymessenger.BertyID{
+			DisplayName:          "Alice",
+			PublicRendezvousSeed: bytes.Repeat([]byte{1}, 32),
+			AccountPK:            bytes.Repeat([]byte{2}, 32),
+		},
+	}
+}
+
+func testGroupLink() *bertymessenger.BertyLink {
+	return &bertymessenger.BertyLink{
+		Kind: bertymessenger.BertyLink_GroupV1Kind,
+		BertyGroup: &bertymessenger.BertyGroup{
+			DisplayName: "The Group",
+			Group: &bertytypes.Group{
+				PublicKey: bytes.Repeat([]byte{3}, 32),
+				Secret:    bytes.Repeat([]byte{4}, 32),
+				SecretSig: bytes.Repeat([]byte{5}, 64),
+				GroupType: bertytypes.GroupTypeMultiMember,
+				SignPub:   bytes.Repeat([]byte{6}, 32),
+			},
+		},
 	}
 }
 
@@ -253,3 +2014,352 @@ const (
 	validGroupBlob         = "5QdUv6Fn3uvfPy8tqZSw7SDVFvv7cnNHhpMHtGNVHBHMBJscFiWxBDd9wnphtqMMdmcmNQin64m44XkBVFWoSRKPboXszWi1dvjJz7Z3WmfJMJMHRHuyub553R9h2JFxCBZBvqZyvxtVrqu9gMRG5TRk1DduS9suYCXB3finDx7uxvx1fkuWtDzeqPMBw9g6Zx"
 	validGroupInternalBlob = "EHJBK/TI1ETK.QPUU.E0ONINK9ZDPW2:.NB4DH/7C.HSXI..XUIS82*J7M1GJVWX/:O7X1C36NC5YAHW-D-M7A8NBAW3NPQP-Z8H.VPJOFVH1*0*FN202136-91H/UTNJXSNVFY7E$NV$A/O1BYIR:*H.N3JELJJE5V*U5Y319YNA9S1R.3TNO4-*0HW4W9*W/T3LOD3LW2JA/0:LZ31LH.4VKNWGN*LF-:89MXMYEN*R7*LSYR"
 )
+
+func TestSetLinkInternalPrefix(t *testing.T) {
+	defer func() { require.NoError(t, bertymessenger.SetLinkInternalPrefix(bertymessenger.LinkInternalPrefix)) }()
+	require.Equal(t, bertymessenger.LinkInternalPrefix, bertymessenger.CurrentLinkInternalPrefix())
+
+	for _, invalid := range []string{"MYCHAT", "MYCHAT:/", "://", "1CHAT://", "MY CHAT://", "MY_CHAT://"} {
+		require.True(t, errcode.Is(bertymessenger.SetLinkInternalPrefix(invalid), errcode.ErrInvalidInput), invalid)
+	}
+	require.Equal(t, bertymessenger.LinkInternalPrefix, bertymessenger.CurrentLinkInternalPrefix())
+
+	contact := testContactLink()
+	bertyInternal, web, err := contact.Marshal()
+	require.NoError(t, err)
+
+	require.NoError(t, bertymessenger.SetLinkInternalPrefix("my-chat+2://"))
+	require.Equal(t, "MY-CHAT+2://", bertymessenger.CurrentLinkInternalPrefix())
+
+	// the generated internal links use the configured scheme, the web links are not affected
+	internal, webAgain, err := contact.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, "MY-CHAT+2://"+strings.TrimPrefix(bertyInternal, "BERTY://"), internal)
+	require.Equal(t, web, webAgain)
+	for _, uri := range []string{internal, strings.ToLower(internal), web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err, uri)
+		require.Equal(t, contact, parsed)
+	}
+	kind, err := bertymessenger.LinkKindOf(internal)
+	require.NoError(t, err)
+	require.Equal(t, bertymessenger.BertyLink_ContactInviteV1Kind, kind)
+
+	// the links of the default scheme are rejected, unless accepted explicitly
+	_, err = bertymessenger.UnmarshalLink(bertyInternal)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	for _, uri := range []string{internal, bertyInternal} {
+		parsed, err := bertymessenger.UnmarshalInternalLink(uri, bertymessenger.WithInternalSchemes("my-chat+2", "BERTY"))
+		require.NoError(t, err, uri)
+		require.Equal(t, contact, parsed)
+	}
+	_, err = bertymessenger.UnmarshalLink(internal, bertymessenger.WithInternalSchemes("BERTY"))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = bertymessenger.UnmarshalLink(internal, bertymessenger.WithInternalSchemes("BERTY://"))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = bertymessenger.UnmarshalLink(internal, bertymessenger.WithInternalSchemes())
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+}
+
+func TestUnmarshalWebLinkForeignSubStructs(t *testing.T) {
+	webBlob := func(link *bertymessenger.BertyLink) string {
+		bin, err := proto.Marshal(link)
+		require.NoError(t, err)
+		return base58.Encode(bin)
+	}
+	group := testGroupLink().BertyGroup
+	contact := testContactLink().BertyID
+
+	// a group blob under the contact slug, or under the other slugs, is rejected
+	groupBlob := webBlob(&bertymessenger.BertyLink{BertyGroup: group})
+	for _, slug := range []string{"contact", "account", "message"} {
+		_, err := bertymessenger.UnmarshalLink("https://berty.tech/id#" + slug + "/" + groupBlob + "/name=Alice")
+		require.Error(t, err, slug)
+		require.True(t, errcode.Is(err, errcode.ErrMissingInput), slug)
+	}
+	contactBlob := webBlob(&bertymessenger.BertyLink{BertyID: contact})
+	_, err := bertymessenger.UnmarshalLink("https://berty.tech/id#group/" + contactBlob)
+	require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+
+	// the sub-structs of the other kinds don't linger
+	mixedBlob := webBlob(&bertymessenger.BertyLink{
+		BertyID:         contact,
+		BertyGroup:      group,
+		BertyMessageRef: &bertymessenger.BertyMessageRef{GroupPK: bytes.Repeat([]byte{3}, 32)},
+	})
+	link, err := bertymessenger.UnmarshalLink("https://berty.tech/id#contact/" + mixedBlob + "/name=Alice")
+	require.NoError(t, err)
+	require.Equal(t, bertymessenger.BertyLink_ContactInviteV1Kind, link.Kind)
+	require.Nil(t, link.BertyGroup)
+	require.Nil(t, link.BertyMessageRef)
+	require.NoError(t, link.IsValid())
+	require.Equal(t, contact.AccountPK, link.BertyID.AccountPK)
+}
+
+func TestUnmarshalInternalLinkIsValid(t *testing.T) {
+	encoder, err := basex.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$*-.:/")
+	require.NoError(t, err)
+	internalLink := func(link *bertymessenger.BertyLink) string {
+		bin, err := proto.Marshal(link)
+		require.NoError(t, err)
+		return "BERTY://PB1/" + encoder.Encode(bin)
+	}
+
+	// a contact link missing its rendezvous seed
+	contact := testContactLink()
+	contact.BertyID.PublicRendezvousSeed = nil
+	_, err = bertymessenger.UnmarshalLink(internalLink(contact))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	require.Equal(t, errcode.ErrInvalidInput.Error(), errcode.Code(err).Error())
+	_, err = bertymessenger.UnmarshalInternalLink(internalLink(contact))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	// a group link missing its group
+	_, err = bertymessenger.UnmarshalLink(internalLink(&bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_GroupV1Kind}))
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+	// a group leaking the secret forbidden by its type keeps the specific code
+	leaking := testGroupLink()
+	leaking.BertyGroup.Group.GroupType = bertytypes.GroupTypeUndefined
+	_, err = bertymessenger.UnmarshalLink(internalLink(leaking))
+	require.True(t, errcode.Is(err, errcode.ErrLinkGroupSecretMismatch))
+
+	// the valid links, and the groups of any type, are still accepted
+	group := testGroupLink()
+	group.BertyGroup.Group.GroupType = bertytypes.GroupTypeContact
+	group.BertyGroup.Group.Secret = nil
+	group.BertyGroup.Group.SecretSig = nil
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), group} {
+		parsed, err := bertymessenger.UnmarshalLink(internalLink(link))
+		require.NoError(t, err)
+		require.Equal(t, link, parsed)
+	}
+}
+
+func TestLinkGroups(t *testing.T) {
+	group := testGroupLink()
+	require.Equal(t, []*bertymessenger.BertyGroup{group.BertyGroup}, group.Groups())
+	require.False(t, group.IsGroupSet())
+
+	require.Nil(t, testContactLink().Groups())
+	require.Nil(t, (&bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_GroupV1Kind}).Groups())
+	require.Nil(t, (*bertymessenger.BertyLink)(nil).Groups())
+
+	// an invalid group set still returns its groups, but is not a valid group set
+	invalid := &bertymessenger.BertyLink{
+		Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+		BertyGroups: []*bertymessenger.BertyGroup{group.BertyGroup, group.BertyGroup},
+	}
+	require.Len(t, invalid.Groups(), 2)
+	require.False(t, invalid.IsGroupSet())
+	require.False(t, invalid.IsGroupBundle())
+	require.False(t, group.IsGroupBundle())
+}
+
+func TestQRBaseEncoding(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		internal, _, err := link.Marshal()
+		require.NoError(t, err)
+		payload := strings.TrimPrefix(internal, "BERTY://PB1/")
+
+		bin, err := bertymessenger.QRBaseDecode(payload)
+		require.NoError(t, err)
+		var decoded bertymessenger.BertyLink
+		require.NoError(t, proto.Unmarshal(bin, &decoded))
+		require.Equal(t, link, &decoded)
+		require.Equal(t, payload, bertymessenger.QRBaseEncode(bin))
+	}
+
+	// the leading zero bytes are kept
+	bin := []byte{0, 0, 1, 2, 3}
+	decoded, err := bertymessenger.QRBaseDecode(bertymessenger.QRBaseEncode(bin))
+	require.NoError(t, err)
+	require.Equal(t, bin, decoded)
+
+	for _, invalid := range []string{"abc", "AB%C", "AB+C", "AB C"} {
+		_, err := bertymessenger.QRBaseDecode(invalid)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), invalid)
+	}
+}
+
+func TestLinkDisplayNameNormalization(t *testing.T) {
+	const (
+		composed   = "Caf\u00e9 Am\u00e9lie"
+		decomposed = "Cafe\u0301 Ame\u0301lie"
+	)
+	require.NotEqual(t, composed, decomposed)
+	require.Equal(t, composed, bertymessenger.NormalizeDisplayName(decomposed))
+	require.Equal(t, composed, bertymessenger.NormalizeDisplayName(composed))
+
+	// the visually identical names generate the same links
+	composedLink := testGroupLink()
+	composedLink.BertyGroup.DisplayName = composed
+	decomposedLink := testGroupLink()
+	decomposedLink.BertyGroup.DisplayName = decomposed
+	composedInternal, composedWeb, err := composedLink.Marshal()
+	require.NoError(t, err)
+	decomposedInternal, decomposedWeb, err := decomposedLink.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, composedInternal, decomposedInternal)
+	require.Equal(t, composedWeb, decomposedWeb)
+	require.Equal(t, decomposed, decomposedLink.BertyGroup.DisplayName, "the input link should not be altered")
+
+	groupSet := &bertymessenger.BertyLink{
+		Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+		BertyGroups: []*bertymessenger.BertyGroup{decomposedLink.BertyGroup},
+	}
+	_, groupSetWeb, err := groupSet.Marshal()
+	require.NoError(t, err)
+	require.Contains(t, groupSetWeb, "name="+url.QueryEscape(composed))
+
+	// the parsed names are normalized, whatever the form of the link
+	contact := testContactLink()
+	contact.BertyID.DisplayName = decomposed
+	bin, err := proto.Marshal(contact)
+	require.NoError(t, err)
+	encoder, err := basex.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$*-.:/")
+	require.NoError(t, err)
+	identity := &bertymessenger.BertyLink{BertyID: &bertymessenger.BertyID{
+		PublicRendezvousSeed: contact.BertyID.PublicRendezvousSeed,
+		AccountPK:            contact.BertyID.AccountPK,
+	}}
+	identityBin, err := proto.Marshal(identity)
+	require.NoError(t, err)
+	for _, uri := range []string{
+		"BERTY://PB1/" + encoder.Encode(bin),
+		"https://berty.tech/id#contact/" + base58.Encode(identityBin) + "/name=" + url.QueryEscape(decomposed),
+	} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err, uri)
+		require.Equal(t, composed, parsed.BertyID.DisplayName, uri)
+	}
+}
+
+func TestUnmarshalLinkWithLimits(t *testing.T) {
+	internal, web, err := testContactLink().Marshal()
+	require.NoError(t, err)
+
+	for _, uri := range []string{internal, web} {
+		// just under the limit
+		_, err := bertymessenger.UnmarshalLinkWithLimits(uri, len(uri))
+		require.NoError(t, err, uri)
+		// just over the limit
+		_, err = bertymessenger.UnmarshalLinkWithLimits(uri, len(uri)-1)
+		require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge), uri)
+	}
+
+	// the decoded payload is bounded too, a compressed payload inflates to more than the size of the link
+	large := testGroupLink()
+	large.Metadata = map[string]string{"description": strings.Repeat("a", 4000)}
+	compressed, _, err := large.Marshal(bertymessenger.WithCompressedPayload())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(compressed, "BERTY://PBZ/"), compressed)
+	payloadSize := proto.Size(large)
+	require.Less(t, len(compressed), payloadSize)
+	_, err = bertymessenger.UnmarshalLinkWithLimits(compressed, payloadSize)
+	require.NoError(t, err)
+	_, err = bertymessenger.UnmarshalLinkWithLimits(compressed, payloadSize-1)
+	require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+
+	// UnmarshalLink has a default limit
+	_, err = bertymessenger.UnmarshalLink("https://berty.tech/id#contact/" + strings.Repeat("z", bertymessenger.DefaultMaxLinkSize))
+	require.True(t, errcode.Is(err, errcode.ErrLinkTooLarge))
+
+	_, err = bertymessenger.UnmarshalLinkWithLimits(internal, 0)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestGetBertyLinkWithName(t *testing.T) {
+	id := testContactLink().BertyID
+	id.DisplayName = "Alice"
+	link := id.GetBertyLinkWithName("Alice from the settings")
+	require.Equal(t, bertymessenger.BertyLink_ContactInviteV1Kind, link.Kind)
+	require.Equal(t, "Alice from the settings", link.BertyID.DisplayName)
+	require.Equal(t, id.AccountPK, link.BertyID.AccountPK)
+	require.NoError(t, link.IsValid())
+	// the source is not mutated
+	require.Equal(t, "Alice", id.DisplayName)
+	require.Equal(t, testContactLink().BertyID, id)
+
+	group := testGroupLink().BertyGroup
+	link = group.GetBertyLinkWithName("Our Group")
+	require.Equal(t, bertymessenger.BertyLink_GroupV1Kind, link.Kind)
+	require.Equal(t, "Our Group", link.BertyGroup.DisplayName)
+	require.True(t, proto.Equal(group.Group, link.BertyGroup.Group))
+	require.NoError(t, link.IsValid())
+	require.Equal(t, "The Group", group.DisplayName)
+	require.Equal(t, testGroupLink().BertyGroup, group)
+
+	link = (*bertymessenger.BertyID)(nil).GetBertyLinkWithName("Alice")
+	require.Equal(t, "Alice", link.BertyID.DisplayName)
+}
+
+func TestUnmarshalWebLinkEscapedFragment(t *testing.T) {
+	names := []string{"Alice", "Alice Bob & co", "100% sure", "a#b?c&d=e/f%g+h", "Émilie 🦄"}
+	for _, name := range names {
+		link := testContactLink()
+		link.BertyID.DisplayName = name
+		link.Metadata = map[string]string{"bio": name}
+		_, web, err := link.Marshal()
+		require.NoError(t, err, name)
+
+		// the raw form, the percent-encoded chars of its fragment are kept (see url.URL.EscapedFragment)
+		parsed, err := bertymessenger.UnmarshalLink(web)
+		require.NoError(t, err, web)
+		require.Equal(t, link, parsed, web)
+
+		// the fully-escaped forms, with the lowercase escapes of some apps
+		start := strings.Index(web, "#")
+		escaped := web[:start] + url.QueryEscape(web[start:])
+		require.NotContains(t, escaped, "#")
+		for _, uri := range []string{escaped, strings.ReplaceAll(escaped, "%2F", "%2f")} {
+			parsed, err := bertymessenger.UnmarshalLink(uri)
+			require.NoError(t, err, uri)
+			require.Equal(t, link, parsed, uri)
+			parsed, err = bertymessenger.UnmarshalWebLink(uri)
+			require.NoError(t, err, uri)
+			require.Equal(t, link, parsed, uri)
+		}
+	}
+
+	// an invalid escape is reported as usual
+	_, err := bertymessenger.UnmarshalLink("https://berty.tech/id%23contact%2F%zz")
+	require.Error(t, err)
+}
+
+func TestLinkIsValidGroupMissingKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		edit func(group *bertymessenger.BertyGroup)
+	}{
+		{"nil-group", func(group *bertymessenger.BertyGroup) { group.Group = nil }},
+		{"empty-public-key", func(group *bertymessenger.BertyGroup) { group.Group.PublicKey = nil }},
+		{"empty-sign-pub", func(group *bertymessenger.BertyGroup) { group.Group.SignPub = []byte{} }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link := testGroupLink()
+			tc.edit(link.BertyGroup)
+			set := &bertymessenger.BertyLink{
+				Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+				BertyGroups: []*bertymessenger.BertyGroup{testGroupLink().BertyGroup, link.BertyGroup},
+			}
+
+			for _, link := range []*bertymessenger.BertyLink{link, set} {
+				require.NotPanics(t, func() {
+					require.True(t, errcode.Is(link.IsValid(), errcode.ErrMissingInput))
+					_, _, err := link.Marshal()
+					require.True(t, errcode.Is(err, errcode.ErrMissingInput))
+
+					// hand-crafted links are rejected too
+					bin, err := proto.Marshal(link)
+					require.NoError(t, err)
+					_, err = bertymessenger.UnmarshalLink("BERTY://PB1/" + bertymessenger.QRBaseEncode(bin))
+					require.Error(t, err)
+					_, err = bertymessenger.UnmarshalLink(bertymessenger.LinkWebPrefix + link.Kind.WebSlug() + "/" + base58.Encode(bin))
+					require.Error(t, err)
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,730 @@
+package bertymessenger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gogo/protobuf/proto"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// FieldDiff describes a field that differs between two links.
+//
+// Secret fields are never printed: their Old and New values are summarized as "set" or "unset".
+// Identity is set for the fields identifying the contact, the group or the message (see CanonicalKey), so the changes
+// of the other fields, i.e., of the display name, can be told apart: the links are still of the same identity.
+type FieldDiff struct {
+	Field    string
+	Old      string
+	New      string
+	Identity bool
+}
+
+// Diff returns the list of fields that changed between link and other, i.e., to explain why they are not Equal.
+//
+// If the links have different kinds, a single "kind" change is returned. The keys are base64-encoded (standard encoding),
+// the fields of the groups of a group set are prefixed with "groups[{index}].".
+func (link *BertyLink) Diff(other *BertyLink) []FieldDiff {
+	if link.GetKind() != other.GetKind() {
+		return []FieldDiff{{Field: "kind", Old: link.GetKind().String(), New: other.GetKind().String(), Identity: true}}
+	}
+
+	changes := []FieldDiff{}
+	switch link.GetKind() {
+	case BertyLink_ContactInviteV1Kind:
+		old, cur := link.GetBertyID(), other.GetBertyID()
+		changes = diffPublicBytes(changes, "account_pk", old.GetAccountPK(), cur.GetAccountPK())
+		changes = diffPublicBytes(changes, "public_rendezvous_seed", old.GetPublicRendezvousSeed(), cur.GetPublicRendezvousSeed())
+		changes = diffPublicBytesList(changes, "additional_rendezvous_seeds", old.GetAdditionalRendezvousSeeds(), cur.GetAdditionalRendezvousSeeds())
+		changes = diffString(changes, "display_name", old.GetDisplayName(), cur.GetDisplayName())
+		changes = diffString(changes, "referral_code", old.GetReferralCode(), cur.GetReferralCode())
+	case BertyLink_AccountPubV1Kind:
+		changes = diffPublicBytes(changes, "account_pk", link.GetBertyID().GetAccountPK(), other.GetBertyID().GetAccountPK())
+	case BertyLink_GroupV1Kind:
+		changes = diffGroup(changes, "", link.GetBertyGroup(), other.GetBertyGroup())
+	case BertyLink_GroupSetV1Kind:
+		old, cur := link.GetBertyGroups(), other.GetBertyGroups()
+		for i := 0; i < len(old) || i < len(cur); i++ {
+			var oldGroup, curGroup *BertyGroup
+			if i < len(old) {
+				oldGroup = old[i]
+			}
+			if i < len(cur) {
+				curGroup = cur[i]
+			}
+			changes = diffGroup(changes, fmt.Sprintf("groups[%d].", i), oldGroup, curGroup)
+		}
+	case BertyLink_MessageV1Kind:
+		old, cur := link.GetBertyMessageRef(), other.GetBertyMessageRef()
+		changes = diffPublicBytes(changes, "group_pk", old.GetGroupPK(), cur.GetGroupPK())
+		changes = diffIdentityString(changes, "message_cid", old.GetMessageCID(), cur.GetMessageCID())
+	}
+	changes = diffString(changes, "not_before", formatDiffUnix(link.GetNotBeforeUnix()), formatDiffUnix(other.GetNotBeforeUnix()))
+	changes = diffString(changes, "expires_at", formatDiffUnix(link.GetExpiresAtUnix()), formatDiffUnix(other.GetExpiresAtUnix()))
+	changes = diffString(changes, "expiry_display_hint", link.GetExpiryDisplayHint().String(), other.GetExpiryDisplayHint().String())
+	changes = diffMetadata(changes, link.GetMetadata(), other.GetMetadata())
+	changes = diffBytes(changes, "pow_nonce", link.GetPoWNonce(), other.GetPoWNonce())
+	changes = diffBytes(changes, "sealed_note", link.GetSealedNote(), other.GetSealedNote())
+	changes = diffBytes(changes, "signature", link.GetSignature(), other.GetSignature())
+	return changes
+}
+
+// diffGroup reports the changed fields of a group, prefixed with prefix.
+func diffGroup(changes []FieldDiff, prefix string, oldGroup, curGroup *BertyGroup) []FieldDiff {
+	old, cur := oldGroup.GetGroup(), curGroup.GetGroup()
+	changes = diffPublicBytes(changes, prefix+"public_key", old.GetPublicKey(), cur.GetPublicKey())
+	changes = diffSecretBytes(changes, prefix+"secret", old.GetSecret(), cur.GetSecret())
+	changes = diffSecretBytes(changes, prefix+"secret_sig", old.GetSecretSig(), cur.GetSecretSig())
+	changes = diffIdentityString(changes, prefix+"group_type", old.GetGroupType().String(), cur.GetGroupType().String())
+	changes = diffPublicBytes(changes, prefix+"sign_pub", old.GetSignPub(), cur.GetSignPub())
+	changes = diffString(changes, prefix+"display_name", oldGroup.GetDisplayName(), curGroup.GetDisplayName())
+	return diffRegenerationLog(changes, prefix+"regeneration_log", oldGroup.GetRegenerationLog(), curGroup.GetRegenerationLog())
+}
+
+// diffRegenerationLog reports the changed entries of a regeneration log, as "{field}[{index}].{entry field}".
+func diffRegenerationLog(changes []FieldDiff, field string, old, cur []*BertyGroup_RegenerationEntry) []FieldDiff {
+	for i := 0; i < len(old) || i < len(cur); i++ {
+		var oldEntry, curEntry *BertyGroup_RegenerationEntry
+		if i < len(old) {
+			oldEntry = old[i]
+		}
+		if i < len(cur) {
+			curEntry = cur[i]
+		}
+		prefix := fmt.Sprintf("%s[%d].", field, i)
+		changes = diffString(changes, prefix+"regenerated_at", formatDiffUnix(oldEntry.GetRegeneratedAtUnix()), formatDiffUnix(curEntry.GetRegeneratedAtUnix()))
+		changes = diffBytes(changes, prefix+"regenerator_pk_hash", oldEntry.GetRegeneratorPKHash(), curEntry.GetRegeneratorPKHash())
+	}
+	return changes
+}
+
+// formatDiffUnix formats a unix timestamp of the validity period for Diff, the unset ones being empty.
+func formatDiffUnix(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+// diffMetadata reports the changed metadata keys, as "metadata.{key}", in alphabetical order.
+func diffMetadata(changes []FieldDiff, old, cur map[string]string) []FieldDiff {
+	keys := []string{}
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range cur {
+		if _, found := old[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		changes = diffString(changes, "metadata."+key, old[key], cur[key])
+	}
+	return changes
+}
+
+func diffString(changes []FieldDiff, field, old, cur string) []FieldDiff {
+	if old == cur {
+		return changes
+	}
+	return append(changes, FieldDiff{Field: field, Old: old, New: cur})
+}
+
+func diffIdentityString(changes []FieldDiff, field, old, cur string) []FieldDiff {
+	if old == cur {
+		return changes
+	}
+	return append(changes, FieldDiff{Field: field, Old: old, New: cur, Identity: true})
+}
+
+func diffPublicBytes(changes []FieldDiff, field string, old, cur []byte) []FieldDiff {
+	if bytes.Equal(old, cur) {
+		return changes
+	}
+	return append(changes, FieldDiff{Field: field, Old: base64.StdEncoding.EncodeToString(old), New: base64.StdEncoding.EncodeToString(cur), Identity: true})
+}
+
+// diffBytes reports a changed byte field that is neither secret nor part of the identity, i.e., a stamp or a signature.
+func diffBytes(changes []FieldDiff, field string, old, cur []byte) []FieldDiff {
+	if bytes.Equal(old, cur) {
+		return changes
+	}
+	return append(changes, FieldDiff{Field: field, Old: base64.StdEncoding.EncodeToString(old), New: base64.StdEncoding.EncodeToString(cur)})
+}
+
+// diffPublicBytesList reports the changed items of a list, as "{field}[{index}]", the missing items being empty.
+func diffPublicBytesList(changes []FieldDiff, field string, old, cur [][]byte) []FieldDiff {
+	for i := 0; i < len(old) || i < len(cur); i++ {
+		var oldItem, curItem []byte
+		if i < len(old) {
+			oldItem = old[i]
+		}
+		if i < len(cur) {
+			curItem = cur[i]
+		}
+		changes = diffPublicBytes(changes, fmt.Sprintf("%s[%d]", field, i), oldItem, curItem)
+	}
+	return changes
+}
+
+func diffSecretBytes(changes []FieldDiff, field string, old, cur []byte) []FieldDiff {
+	if bytes.Equal(old, cur) {
+		return changes
+	}
+	return append(changes, FieldDiff{Field: field, Old: secretSummary(old), New: secretSummary(cur), Identity: true})
+}
+
+func secretSummary(b []byte) string {
+	if len(b) == 0 {
+		return "unset"
+	}
+	return "set"
+}
+
+// Equal returns true if link and other have the same meaningful fields, i.e., if they are the same contact or group,
+// with the same validity period and metadata, whatever their display names.
+//
+// The links are compared in their normalized form (see Normalize), so an empty field is equal to a missing one.
+func (link *BertyLink) Equal(other *BertyLink) bool {
+	if link == nil || other == nil {
+		return link == other
+	}
+
+	withoutNames := func(link *BertyLink) *BertyLink {
+		clone := proto.Clone(link).(*BertyLink)
+		clone.Normalize()
+		if clone.BertyID != nil {
+			clone.BertyID.DisplayName = ""
+		}
+		for _, group := range append([]*BertyGroup{clone.BertyGroup}, clone.BertyGroups...) {
+			if group != nil {
+				group.DisplayName = ""
+			}
+		}
+		return clone
+	}
+	return proto.Equal(withoutNames(link), withoutNames(other))
+}
+
+// Normalize canonicalizes the link in place, so two links that marshal identically are also equal
+// with reflect.DeepEqual: the empty byte slices, lists and maps are set to nil, and the internal proto state
+// (i.e., the cached size of the groups) is reset.
+//
+// The empty sub-messages are kept, since they are marshaled, unlike the empty fields.
+func (link *BertyLink) Normalize() {
+	if link == nil {
+		return
+	}
+
+	link.PoWNonce = normalizedBytes(link.PoWNonce)
+	link.SealedNote = normalizedBytes(link.SealedNote)
+	link.Signature = normalizedBytes(link.Signature)
+	if len(link.Metadata) == 0 {
+		link.Metadata = nil
+	}
+	if id := link.BertyID; id != nil {
+		id.PublicRendezvousSeed = normalizedBytes(id.PublicRendezvousSeed)
+		id.AccountPK = normalizedBytes(id.AccountPK)
+		if len(id.AdditionalRendezvousSeeds) == 0 {
+			id.AdditionalRendezvousSeeds = nil
+		}
+		for i, seed := range id.AdditionalRendezvousSeeds {
+			id.AdditionalRendezvousSeeds[i] = normalizedBytes(seed)
+		}
+	}
+	normalizeGroup(link.BertyGroup)
+	if len(link.BertyGroups) == 0 {
+		link.BertyGroups = nil
+	}
+	for _, group := range link.BertyGroups {
+		normalizeGroup(group)
+	}
+	if ref := link.BertyMessageRef; ref != nil {
+		ref.GroupPK = normalizedBytes(ref.GroupPK)
+	}
+}
+
+func normalizeGroup(group *BertyGroup) {
+	if group == nil {
+		return
+	}
+	if g := group.Group; g != nil {
+		g.PublicKey = normalizedBytes(g.PublicKey)
+		g.Secret = normalizedBytes(g.Secret)
+		g.SecretSig = normalizedBytes(g.SecretSig)
+		g.SignPub = normalizedBytes(g.SignPub)
+		g.XXX_unrecognized = normalizedBytes(g.XXX_unrecognized)
+		g.XXX_sizecache = 0
+	}
+	if len(group.RegenerationLog) == 0 {
+		group.RegenerationLog = nil
+	}
+	for _, entry := range group.RegenerationLog {
+		if entry != nil {
+			entry.RegeneratorPKHash = normalizedBytes(entry.RegeneratorPKHash)
+		}
+	}
+}
+
+// normalizedBytes returns nil if b is empty, and b otherwise.
+func normalizedBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// CanonicalSigningBytes returns the deterministic binary representation of the identity of the link.
+//
+// It only contains the kind and the identifying fields, without any metadata (i.e., display name),
+// so editing or stripping the metadata of a link does not change its canonical form.
+// This is the payload that must be used when signing a link or verifying a link signature.
+func (link *BertyLink) CanonicalSigningBytes() ([]byte, error) {
+	if err := link.IsValid(); err != nil {
+		return nil, err
+	}
+
+	canonical := link.identity()
+	canonical.Kind = link.Kind
+
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(canonical); err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// CanonicalKey returns a stable and URL-safe string identifying the contact or the group of the link.
+//
+// It is derived from CanonicalSigningBytes, so the links of the same identity have the same key,
+// whatever their form (web or internal) and their metadata.
+func (link *BertyLink) CanonicalKey() (string, error) {
+	canonical, err := link.CanonicalSigningBytes()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// LinksConsistent returns true if the internal and web links, i.e., a QR code and its printed web link,
+// represent the same contact or group, so the UI can warn when one of them was swapped.
+//
+// Only the identities (see CanonicalKey) are compared, since the internal and web forms don't encode the same metadata.
+// The validity period is not checked.
+func LinksConsistent(internal, web string) (bool, error) {
+	if _, ok := internalLinkPayload(internal); !ok {
+		return false, errcode.ErrInvalidInput.Wrap(fmt.Errorf("not an internal link"))
+	}
+	if _, ok := webLinkFragmentStart(web); !ok {
+		return false, errcode.ErrInvalidInput.Wrap(fmt.Errorf("not a web link"))
+	}
+
+	keys := make([]string, 0, 2)
+	for _, uri := range []string{internal, web} {
+		link, err := UnmarshalLink(uri, WithAllowExpired())
+		if err != nil {
+			return false, err
+		}
+		key, err := link.CanonicalKey()
+		if err != nil {
+			return false, err
+		}
+		keys = append(keys, key)
+	}
+	return keys[0] == keys[1], nil
+}
+
+// MergeContactUpdates merges a contact link received again, i.e., with an updated display name or bio,
+// into the stored one, and returns whether the merged link differs from existing.
+//
+// The non-empty display name, referral code and metadata entries of incoming replace the ones of existing,
+// the other fields of existing are kept, since a web link doesn't carry all of them.
+// It returns ErrInvalidInput if the links are not contact links of the same identity (see CanonicalKey).
+func MergeContactUpdates(existing, incoming *BertyLink) (*BertyLink, bool, error) {
+	if existing.GetKind() != BertyLink_ContactInviteV1Kind || incoming.GetKind() != BertyLink_ContactInviteV1Kind {
+		return nil, false, errcode.ErrInvalidInput.Wrap(fmt.Errorf("only contact links can be merged"))
+	}
+	existingKey, err := existing.CanonicalKey()
+	if err != nil {
+		return nil, false, err
+	}
+	incomingKey, err := incoming.CanonicalKey()
+	if err != nil {
+		return nil, false, err
+	}
+	if existingKey != incomingKey {
+		return nil, false, errcode.ErrInvalidInput.Wrap(fmt.Errorf("the links are not of the same contact"))
+	}
+
+	merged := proto.Clone(existing).(*BertyLink)
+	if name := incoming.BertyID.DisplayName; name != "" {
+		merged.BertyID.DisplayName = name
+	}
+	if ref := incoming.BertyID.ReferralCode; ref != "" {
+		merged.BertyID.ReferralCode = ref
+	}
+	for key, value := range incoming.Metadata {
+		if value == "" {
+			continue
+		}
+		if merged.Metadata == nil {
+			merged.Metadata = map[string]string{}
+		}
+		merged.Metadata[key] = value
+	}
+	return merged, !proto.Equal(merged, existing), nil
+}
+
+// WithRendezvousSeed returns a copy of the contact link with its public rendezvous seed replaced by seed,
+// i.e., to reshare the contact after the rotation of its seed; link is left untouched.
+//
+// The additional rendezvous seeds are kept, the signature is dropped. It returns ErrInvalidInput if link is not a contact link, or if seed
+// doesn't have the expected length or is one of the additional seeds.
+func (link *BertyLink) WithRendezvousSeed(seed []byte) (*BertyLink, error) {
+	if link.GetKind() != BertyLink_ContactInviteV1Kind || link.BertyID == nil {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("only the rendezvous seed of a contact link can be replaced, not of a %q link", link.GetKind()))
+	}
+	if err := checkKeyLength("rendezvous seed", seed, LinkRendezvousSeedLength); err != nil {
+		return nil, err
+	}
+
+	rotated := proto.Clone(link).(*BertyLink)
+	rotated.BertyID.PublicRendezvousSeed = append([]byte(nil), seed...)
+	// the signature covers the rendezvous seed, the link has to be signed again (see MarshalSigned)
+	rotated.Signature = nil
+	if err := checkAdditionalRendezvousSeeds(rotated.BertyID); err != nil {
+		return nil, err
+	}
+	return rotated, nil
+}
+
+// fingerprintSize is the number of hash bytes kept in a fingerprint, 128 bits are enough against second-preimage attacks.
+const fingerprintSize = 16
+
+// Fingerprint returns a short human-readable digest of the identity of the link,
+// meant to be compared out-of-band, i.e., read aloud, in the "XXXX XXXX ..." format (8 groups of 4 hex digits).
+//
+// Like CanonicalKey, it is derived from CanonicalSigningBytes, so it does not depend on the metadata.
+func (link *BertyLink) Fingerprint() (string, error) {
+	canonical, err := link.CanonicalSigningBytes()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	digits := strings.ToUpper(hex.EncodeToString(sum[:fingerprintSize]))
+	groups := make([]string, 0, len(digits)/4)
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+	return strings.Join(groups, " "), nil
+}
+
+// MatchesFingerprint returns true if fp is the fingerprint of the link (see Fingerprint).
+//
+// The comparison is case-insensitive, ignores the whitespaces and the '-' and ':' separators,
+// and is done in constant time.
+func (link *BertyLink) MatchesFingerprint(fp string) bool {
+	expected, err := link.Fingerprint()
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(normalizeFingerprint(expected)), []byte(normalizeFingerprint(fp))) == 1
+}
+
+func normalizeFingerprint(fp string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' || r == ':' {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, fp)
+}
+
+const (
+	// identiconSaturation and identiconLightness are fixed, so all the placeholder colors are readable
+	// with a white text, only the hue depends on the identity.
+	identiconSaturation = 0.6
+	identiconLightness  = 0.45
+)
+
+// IdenticonColor returns a deterministic color derived from the identity of the link, i.e., for a placeholder avatar.
+//
+// Like CanonicalKey, it doesn't depend on the metadata, so the same contact always gets the same color,
+// whatever the form of the link and the device.
+func (link *BertyLink) IdenticonColor() (r, g, b uint8, err error) {
+	canonical, err := link.CanonicalSigningBytes()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	sum := sha256.Sum256(canonical)
+	hue := float64(binary.BigEndian.Uint16(sum[:2])%360) / 60
+
+	// HSL to RGB conversion
+	chroma := (1 - math.Abs(2*identiconLightness-1)) * identiconSaturation
+	x := chroma * (1 - math.Abs(math.Mod(hue, 2)-1))
+	var rf, gf, bf float64
+	switch int(hue) {
+	case 0:
+		rf, gf, bf = chroma, x, 0
+	case 1:
+		rf, gf, bf = x, chroma, 0
+	case 2:
+		rf, gf, bf = 0, chroma, x
+	case 3:
+		rf, gf, bf = 0, x, chroma
+	case 4:
+		rf, gf, bf = x, 0, chroma
+	default:
+		rf, gf, bf = chroma, 0, x
+	}
+	m := identiconLightness - chroma/2
+	toByte := func(v float64) uint8 { return uint8(math.Round((v + m) * 255)) }
+	return toByte(rf), toByte(gf), toByte(bf), nil
+}
+
+// MetadataCost returns, for each optional field that is set, how many chars it adds to the internal link,
+// i.e., to tell the users how much larger a bio makes their QR code.
+//
+// The keys are "display_name" (the names of the contact, the group or the groups of a group set), "referral_code",
+// "additional_rendezvous_seeds", "regeneration_log" (the logs of all the groups), "validity_period",
+// "expiry_display_hint", "pow_nonce", "sealed_note", "signature", and "metadata.{key}" for the metadata entries.
+// Each cost is computed by marshaling the link without the field, so the costs don't exactly add up
+// because of the base encoding.
+func (link *BertyLink) MetadataCost() (map[string]int, error) {
+	full, err := link.internalLinkLen()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := append([]*BertyGroup{link.GetBertyGroup()}, link.GetBertyGroups()...)
+	hasGroupField := func(isSet func(group *BertyGroup) bool) bool {
+		for _, group := range groups {
+			if group != nil && isSet(group) {
+				return true
+			}
+		}
+		return false
+	}
+	stripGroupField := func(link *BertyLink, strip func(group *BertyGroup)) {
+		for _, group := range append([]*BertyGroup{link.BertyGroup}, link.BertyGroups...) {
+			if group != nil {
+				strip(group)
+			}
+		}
+	}
+
+	fields := map[string]func(link *BertyLink){}
+	if link.GetBertyID().GetDisplayName() != "" || hasGroupField(func(group *BertyGroup) bool { return group.DisplayName != "" }) {
+		fields["display_name"] = func(link *BertyLink) {
+			if link.BertyID != nil {
+				link.BertyID.DisplayName = ""
+			}
+			stripGroupField(link, func(group *BertyGroup) { group.DisplayName = "" })
+		}
+	}
+	if link.GetBertyID().GetReferralCode() != "" {
+		fields["referral_code"] = func(link *BertyLink) { link.BertyID.ReferralCode = "" }
+	}
+	if len(link.GetBertyID().GetAdditionalRendezvousSeeds()) > 0 {
+		fields["additional_rendezvous_seeds"] = func(link *BertyLink) { link.BertyID.AdditionalRendezvousSeeds = nil }
+	}
+	if hasGroupField(func(group *BertyGroup) bool { return len(group.RegenerationLog) > 0 }) {
+		fields["regeneration_log"] = func(link *BertyLink) {
+			stripGroupField(link, func(group *BertyGroup) { group.RegenerationLog = nil })
+		}
+	}
+	if link.NotBeforeUnix != 0 || link.ExpiresAtUnix != 0 {
+		fields["validity_period"] = func(link *BertyLink) { link.NotBeforeUnix, link.ExpiresAtUnix = 0, 0 }
+	}
+	if link.ExpiryDisplayHint != BertyLink_ExpiryRelative {
+		fields["expiry_display_hint"] = func(link *BertyLink) { link.ExpiryDisplayHint = BertyLink_ExpiryRelative }
+	}
+	if len(link.PoWNonce) > 0 {
+		fields["pow_nonce"] = func(link *BertyLink) { link.PoWNonce = nil }
+	}
+	if len(link.SealedNote) > 0 {
+		fields["sealed_note"] = func(link *BertyLink) { link.SealedNote = nil }
+	}
+	if len(link.Signature) > 0 {
+		fields["signature"] = func(link *BertyLink) { link.Signature = nil }
+	}
+	for key := range link.Metadata {
+		key := key
+		fields["metadata."+key] = func(link *BertyLink) { delete(link.Metadata, key) }
+	}
+
+	costs := make(map[string]int, len(fields))
+	for field, strip := range fields {
+		stripped := proto.Clone(link).(*BertyLink)
+		strip(stripped)
+		size, err := stripped.internalLinkLen()
+		if err != nil {
+			return nil, err
+		}
+		costs[field] = full - size
+	}
+	return costs, nil
+}
+
+func (link *BertyLink) internalLinkLen() (int, error) {
+	internal, err := link.ToInternal()
+	if err != nil {
+		return 0, err
+	}
+	return len(internal), nil
+}
+
+// shortenIDSize is the number of hash bytes kept in a ShortenPayload ID, 96 bits are enough to avoid collisions.
+const shortenIDSize = 12
+
+// ShortenPayload returns the key/value pair that a link shortening service should persist.
+//
+// The id is a stable and URL-safe hash of the proto payload of the internal link, suitable as an URL path component:
+// it doesn't depend on the internal scheme (see SetLinkInternalPrefix) nor on the web prefix, so the same link has the
+// same id whatever the app that shortens it. fullInternal is the internal link that the shortened URL resolves to,
+// it is returned even if the web link of link would be too long.
+func (link *BertyLink) ShortenPayload() (id string, fullInternal string, err error) {
+	options, err := link.marshalOptions(nil)
+	if err != nil {
+		return "", "", err
+	}
+	fullInternal, err = link.marshalInternal(options)
+	if err != nil {
+		return "", "", err
+	}
+
+	var sum [sha256.Size]byte
+	if err := marshalDeterministic(link.strippedCopy(options), func(bin []byte) { sum = sha256.Sum256(bin) }); err != nil {
+		return "", "", err
+	}
+	id = base64.RawURLEncoding.EncodeToString(sum[:shortenIDSize])
+	return id, fullInternal, nil
+}
+
+// CleanLink returns uri without its analytics tag (see WithAnalyticsTag), the other parts of the link are kept as is.
+//
+// The links that are not web links don't have analytics tags, they are returned unchanged.
+func CleanLink(uri string) (string, error) {
+	start, ok := webLinkFragmentStart(uri)
+	if !ok {
+		return uri, nil
+	}
+
+	parts := strings.SplitN(uri[start:], "/", 3)
+	if len(parts) < 3 {
+		return uri, nil
+	}
+	kept := []string{}
+	for _, pair := range strings.Split(parts[2], "&") {
+		key, err := url.QueryUnescape(strings.SplitN(pair, "=", 2)[0])
+		if err != nil {
+			return "", errcode.ErrInvalidInput.Wrap(err)
+		}
+		if key != analyticsTagKey {
+			kept = append(kept, pair)
+		}
+	}
+
+	cleaned := uri[:start] + parts[0] + "/" + parts[1]
+	if len(kept) > 0 {
+		cleaned += "/" + strings.Join(kept, "&")
+	}
+	return cleaned, nil
+}
+
+// linkPreview is the JSON form of BertyLink.PreviewJSON, its fields must stay stable since they are used by the JS UIs.
+type linkPreview struct {
+	Kind        string `json:"kind"`
+	DisplayName string `json:"displayName"`
+	Fingerprint string `json:"fingerprint"`
+	HasSecret   bool   `json:"hasSecret"`
+	Expired     bool   `json:"expired"`
+}
+
+// PreviewJSON returns a small JSON object describing the link, i.e., to render a scan result in the JS UIs:
+//
+//	{"kind":"GroupV1Kind","displayName":"...","fingerprint":"XXXX ...","hasSecret":true,"expired":false}
+//
+// It never contains secret material, only whether the link carries a group secret.
+func (link *BertyLink) PreviewJSON() ([]byte, error) {
+	fingerprint, err := link.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	preview := linkPreview{
+		Kind:        link.Kind.String(),
+		Fingerprint: fingerprint,
+		HasSecret:   link.HasGroupSecret(),
+		Expired:     errcode.Is(link.checkActive(time.Now()), errcode.ErrLinkExpired),
+	}
+	switch link.Kind {
+	case BertyLink_ContactInviteV1Kind:
+		preview.DisplayName = link.BertyID.DisplayName
+	case BertyLink_GroupV1Kind:
+		preview.DisplayName = link.BertyGroup.DisplayName
+	}
+
+	out, err := json.Marshal(preview)
+	if err != nil {
+		return nil, errcode.ErrSerialization.Wrap(err)
+	}
+	return out, nil
+}
+
+// Redacted returns a copy of the link without its secret material, safe to be logged.
+//
+// The rendezvous seeds of contact links and the secrets of group links are removed,
+// the kind, the public keys and the metadata (i.e., display name) are kept.
+// The copy is a deep one, so it doesn't share any byte slice or regeneration log with link.
+func (link *BertyLink) Redacted() *BertyLink {
+	if link == nil {
+		return nil
+	}
+
+	redacted := proto.Clone(link).(*BertyLink)
+	if id := redacted.BertyID; id != nil {
+		id.PublicRendezvousSeed = nil
+		id.AdditionalRendezvousSeeds = nil
+	}
+	for _, group := range append([]*BertyGroup{redacted.BertyGroup}, redacted.BertyGroups...) {
+		if group.GetGroup() != nil {
+			group.Group.Secret = nil
+			group.Group.SecretSig = nil
+			// the unknown fields of a newer app could hold secrets too
+			group.Group.XXX_unrecognized = nil
+		}
+	}
+	return redacted
+}
+
+// UnmarshalLinkForLog parses uri like UnmarshalLink, and only returns the redacted form of the link.
+//
+// It is meant for logging paths that should never hold secret material,
+// so it returns the parsing errors, but expired links are still returned.
+func UnmarshalLinkForLog(uri string) (*BertyLink, error) {
+	link, err := UnmarshalLink(uri, WithAllowExpired())
+	if err != nil {
+		return nil, err
+	}
+	return link.Redacted(), nil
+}
@@ -0,0 +1,801 @@
+package bertymessenger_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mr-tron/base58"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+
+	"berty.tech/berty/v2/go/pkg/bertymessenger"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func TestLinkDiff(t *testing.T) {
+	t.Run("identical", func(t *testing.T) {
+		require.Empty(t, testContactLink().Diff(testContactLink()))
+	})
+
+	t.Run("name-only", func(t *testing.T) {
+		updated := testContactLink()
+		updated.BertyID.DisplayName = "Alice Liddell"
+		changes := testContactLink().Diff(updated)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "display_name", Old: "Alice", New: "Alice Liddell"},
+		}, changes)
+	})
+
+	t.Run("secret", func(t *testing.T) {
+		updated := testGroupLink()
+		updated.BertyGroup.Group.Secret = bytes.Repeat([]byte{42}, 32)
+		changes := testGroupLink().Diff(updated)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "secret", Old: "set", New: "set", Identity: true},
+		}, changes)
+
+		updated.BertyGroup.Group.Secret = nil
+		changes = testGroupLink().Diff(updated)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "secret", Old: "set", New: "unset", Identity: true},
+		}, changes)
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		old := testContactLink()
+		old.Metadata = map[string]string{"bio": "hello", "color": "red"}
+		updated := testContactLink()
+		updated.Metadata = map[string]string{"bio": "hello", "color": "blue", "return": "https://berty.tech/"}
+		changes := old.Diff(updated)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "metadata.color", Old: "red", New: "blue"},
+			{Field: "metadata.return", Old: "", New: "https://berty.tech/"},
+		}, changes)
+	})
+
+	t.Run("kind", func(t *testing.T) {
+		changes := testContactLink().Diff(testGroupLink())
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "kind", Old: "ContactInviteV1Kind", New: "GroupV1Kind", Identity: true},
+		}, changes)
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		updated := testContactLink()
+		updated.BertyID.AccountPK = bytes.Repeat([]byte{42}, 32)
+		updated.BertyID.DisplayName = "Alice Liddell"
+		changes := testContactLink().Diff(updated)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "account_pk", Old: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)), New: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{42}, 32)), Identity: true},
+			{Field: "display_name", Old: "Alice", New: "Alice Liddell"},
+		}, changes)
+	})
+
+	t.Run("validity-period", func(t *testing.T) {
+		updated := testContactLink()
+		updated.ExpiresAtUnix = 1600000000
+		changes := testContactLink().Diff(updated)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "expires_at", Old: "", New: "2020-09-13T12:26:40Z"},
+		}, changes)
+		require.False(t, testContactLink().Equal(updated))
+	})
+
+	t.Run("group-set", func(t *testing.T) {
+		old := &bertymessenger.BertyLink{
+			Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+			BertyGroups: []*bertymessenger.BertyGroup{testGroupLink().BertyGroup},
+		}
+		updated := proto.Clone(old).(*bertymessenger.BertyLink)
+		updated.BertyGroups[0].DisplayName = "Renamed"
+		updated.BertyGroups = append(updated.BertyGroups, testGroupLink().BertyGroup)
+		changes := old.Diff(updated)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "groups[0].display_name", Old: "The Group", New: "Renamed"},
+			{Field: "groups[1].public_key", Old: "", New: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)), Identity: true},
+			{Field: "groups[1].secret", Old: "unset", New: "set", Identity: true},
+			{Field: "groups[1].secret_sig", Old: "unset", New: "set", Identity: true},
+			{Field: "groups[1].group_type", Old: "GroupTypeUndefined", New: "GroupTypeMultiMember", Identity: true},
+			{Field: "groups[1].sign_pub", Old: "", New: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{6}, 32)), Identity: true},
+			{Field: "groups[1].display_name", Old: "", New: "The Group"},
+		}, changes)
+	})
+
+	t.Run("every-field", func(t *testing.T) {
+		regenerated := func(link *bertymessenger.BertyLink) *bertymessenger.BertyGroup {
+			if link.BertyGroup != nil {
+				return link.BertyGroup
+			}
+			return link.BertyGroups[0]
+		}
+		groupSet := func() *bertymessenger.BertyLink {
+			return &bertymessenger.BertyLink{
+				Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+				BertyGroups: []*bertymessenger.BertyGroup{testGroupLink().BertyGroup},
+			}
+		}
+		mutations := map[string]struct {
+			base   func() *bertymessenger.BertyLink
+			mutate func(link *bertymessenger.BertyLink)
+		}{
+			"not_before":          {testContactLink, func(link *bertymessenger.BertyLink) { link.NotBeforeUnix = 1600000000 }},
+			"expires_at":          {testContactLink, func(link *bertymessenger.BertyLink) { link.ExpiresAtUnix = 1600000000 }},
+			"expiry_display_hint": {testContactLink, func(link *bertymessenger.BertyLink) { link.ExpiryDisplayHint = bertymessenger.BertyLink_ExpiryAbsolute }},
+			"metadata":            {testContactLink, func(link *bertymessenger.BertyLink) { link.Metadata = map[string]string{"bio": "hello"} }},
+			"pow_nonce":           {testContactLink, func(link *bertymessenger.BertyLink) { link.PoWNonce = []byte{1, 2, 3} }},
+			"sealed_note":         {testContactLink, func(link *bertymessenger.BertyLink) { link.SealedNote = []byte{1, 2, 3} }},
+			"signature":           {testContactLink, func(link *bertymessenger.BertyLink) { link.Signature = bytes.Repeat([]byte{7}, 64) }},
+			"account_pk":          {testContactLink, func(link *bertymessenger.BertyLink) { link.BertyID.AccountPK = bytes.Repeat([]byte{42}, 32) }},
+			"public_rendezvous_seed": {testContactLink, func(link *bertymessenger.BertyLink) {
+				link.BertyID.PublicRendezvousSeed = bytes.Repeat([]byte{42}, 32)
+			}},
+			"additional_rendezvous_seeds": {testContactLink, func(link *bertymessenger.BertyLink) {
+				link.BertyID.AdditionalRendezvousSeeds = [][]byte{bytes.Repeat([]byte{42}, 32)}
+			}},
+			"referral_code": {testContactLink, func(link *bertymessenger.BertyLink) { link.BertyID.ReferralCode = "friend" }},
+			"group.secret":  {testGroupLink, func(link *bertymessenger.BertyLink) { link.BertyGroup.Group.Secret = nil }},
+			"group.regeneration_log": {testGroupLink, func(link *bertymessenger.BertyLink) {
+				regenerated(link).RegenerationLog = []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 1600000000}}
+			}},
+			"group.regeneration_log.regenerator_pk_hash": {testGroupLink, func(link *bertymessenger.BertyLink) {
+				regenerated(link).RegenerationLog = []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratorPKHash: bytes.Repeat([]byte{42}, 32)}}
+			}},
+			"groups.regeneration_log": {groupSet, func(link *bertymessenger.BertyLink) {
+				regenerated(link).RegenerationLog = []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 1600000000}}
+			}},
+			"groups.sign_pub": {groupSet, func(link *bertymessenger.BertyLink) { link.BertyGroups[0].Group.SignPub = nil }},
+		}
+		for name, mutation := range mutations {
+			updated := mutation.base()
+			mutation.mutate(updated)
+			require.False(t, mutation.base().Equal(updated), name)
+			require.NotEmpty(t, mutation.base().Diff(updated), name)
+			require.NotEmpty(t, updated.Diff(mutation.base()), name)
+		}
+	})
+
+	t.Run("stamps", func(t *testing.T) {
+		updated := testContactLink()
+		updated.ExpiryDisplayHint = bertymessenger.BertyLink_ExpiryAbsolute
+		updated.Signature = []byte{1, 2, 3}
+		changes := testContactLink().Diff(updated)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "expiry_display_hint", Old: "ExpiryRelative", New: "ExpiryAbsolute"},
+			{Field: "signature", Old: "", New: "AQID"},
+		}, changes)
+
+		group := testGroupLink()
+		group.BertyGroup.RegenerationLog = []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 1600000000, RegeneratorPKHash: []byte{1, 2, 3}}}
+		changes = testGroupLink().Diff(group)
+		require.Equal(t, []bertymessenger.FieldDiff{
+			{Field: "regeneration_log[0].regenerated_at", Old: "", New: "2020-09-13T12:26:40Z"},
+			{Field: "regeneration_log[0].regenerator_pk_hash", Old: "", New: "AQID"},
+		}, changes)
+	})
+}
+
+func TestLinkEqual(t *testing.T) {
+	require.True(t, testContactLink().Equal(testContactLink()))
+	require.True(t, testGroupLink().Equal(testGroupLink()))
+	require.False(t, testContactLink().Equal(testGroupLink()))
+	require.True(t, (*bertymessenger.BertyLink)(nil).Equal(nil))
+	require.False(t, testContactLink().Equal(nil))
+
+	// the display names are ignored, and so is the sanitization of the names of the web links
+	renamed := testContactLink()
+	renamed.BertyID.DisplayName = "Alice\u200f (work)"
+	require.True(t, testContactLink().Equal(renamed))
+	_, web, err := renamed.Marshal()
+	require.NoError(t, err)
+	parsed, err := bertymessenger.UnmarshalLink(web)
+	require.NoError(t, err)
+	require.True(t, renamed.Equal(parsed))
+	group := testGroupLink()
+	group.BertyGroup.DisplayName = "Renamed"
+	require.True(t, testGroupLink().Equal(group))
+
+	// nil and empty fields are equal
+	empty := testContactLink()
+	empty.Metadata = map[string]string{}
+	empty.PoWNonce = []byte{}
+	empty.BertyID.AdditionalRendezvousSeeds = [][]byte{}
+	require.True(t, testContactLink().Equal(empty))
+	require.True(t, empty.Equal(testContactLink()))
+
+	// the other fields are not ignored
+	for _, edit := range []func(link *bertymessenger.BertyLink){
+		func(link *bertymessenger.BertyLink) { link.BertyID.AccountPK = bytes.Repeat([]byte{9}, 32) },
+		func(link *bertymessenger.BertyLink) { link.BertyID.ReferralCode = "spring-21" },
+		func(link *bertymessenger.BertyLink) { link.ExpiresAtUnix = 1700000000 },
+		func(link *bertymessenger.BertyLink) { link.Metadata = map[string]string{"bio": "hello"} },
+	} {
+		other := testContactLink()
+		edit(other)
+		require.False(t, testContactLink().Equal(other))
+	}
+}
+
+func TestLinkNormalize(t *testing.T) {
+	link := testGroupLink()
+	link.Metadata = map[string]string{}
+	link.PoWNonce = []byte{}
+	link.SealedNote = []byte{}
+	link.BertyGroup.Group.SecretSig = []byte{}
+	link.BertyGroup.Group.Secret = []byte{}
+	link.BertyGroup.RegenerationLog = []*bertymessenger.BertyGroup_RegenerationEntry{}
+
+	expected := testGroupLink()
+	expected.BertyGroup.Group.SecretSig = nil
+	expected.BertyGroup.Group.Secret = nil
+	require.NotEqual(t, expected, link)
+
+	// the links marshal identically, and are equal once normalized
+	linkInternal, linkWeb, err := link.Marshal()
+	require.NoError(t, err)
+	expectedInternal, expectedWeb, err := expected.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, expectedInternal, linkInternal)
+	require.Equal(t, expectedWeb, linkWeb)
+
+	link.Normalize()
+	expected.Normalize()
+	require.Equal(t, expected, link)
+	require.True(t, expected.Equal(link))
+
+	// the empty sub-messages are kept, since they are marshaled
+	contact := &bertymessenger.BertyLink{Kind: bertymessenger.BertyLink_ContactInviteV1Kind, BertyID: &bertymessenger.BertyID{}}
+	contact.Normalize()
+	require.NotNil(t, contact.BertyID)
+
+	(*bertymessenger.BertyLink)(nil).Normalize()
+}
+
+func TestLinkCanonicalSigningBytes(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			withName, err := link.CanonicalSigningBytes()
+			require.NoError(t, err)
+			require.NotEmpty(t, withName)
+
+			withoutName := link
+			switch link.Kind {
+			case bertymessenger.BertyLink_ContactInviteV1Kind:
+				withoutName.BertyID.DisplayName = ""
+			case bertymessenger.BertyLink_GroupV1Kind:
+				withoutName.BertyGroup.DisplayName = ""
+			}
+			canonical, err := withoutName.CanonicalSigningBytes()
+			require.NoError(t, err)
+			require.Equal(t, withName, canonical)
+		})
+	}
+
+	t.Run("identity-change", func(t *testing.T) {
+		a, err := testContactLink().CanonicalSigningBytes()
+		require.NoError(t, err)
+		other := testContactLink()
+		other.BertyID.AccountPK = bytes.Repeat([]byte{42}, 32)
+		b, err := other.CanonicalSigningBytes()
+		require.NoError(t, err)
+		require.NotEqual(t, a, b)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := (&bertymessenger.BertyLink{}).CanonicalSigningBytes()
+		require.Error(t, err)
+	})
+}
+
+func TestLinkCanonicalKey(t *testing.T) {
+	contact := testContactLink()
+	key, err := contact.CanonicalKey()
+	require.NoError(t, err)
+	require.NotEmpty(t, key)
+	require.Equal(t, url.PathEscape(key), key)
+
+	// the metadata are ignored
+	renamed := testContactLink()
+	renamed.BertyID.DisplayName = "Bob"
+	renamed.Metadata = map[string]string{"bio": "hello"}
+	renamedKey, err := renamed.CanonicalKey()
+	require.NoError(t, err)
+	require.Equal(t, key, renamedKey)
+
+	// the identity is not
+	groupKey, err := testGroupLink().CanonicalKey()
+	require.NoError(t, err)
+	require.NotEqual(t, key, groupKey)
+
+	_, err = (&bertymessenger.BertyLink{}).CanonicalKey()
+	require.Error(t, err)
+}
+
+func TestLinksConsistent(t *testing.T) {
+	contact := testContactLink()
+	contact.Metadata = map[string]string{"bio": "hello"}
+	contactInternal, contactWeb, err := contact.Marshal()
+	require.NoError(t, err)
+	groupInternal, groupWeb, err := testGroupLink().Marshal()
+	require.NoError(t, err)
+
+	// the web form of a renamed contact still matches
+	renamed := testContactLink()
+	renamed.BertyID.DisplayName = "Bob"
+	_, renamedWeb, err := renamed.Marshal()
+	require.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		internal   string
+		web        string
+		consistent bool
+	}{
+		{"contact", contactInternal, contactWeb, true},
+		{"group", groupInternal, groupWeb, true},
+		{"renamed", contactInternal, renamedWeb, true},
+		{"swapped-web", contactInternal, groupWeb, false},
+		{"swapped-internal", groupInternal, contactWeb, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			consistent, err := bertymessenger.LinksConsistent(tc.internal, tc.web)
+			require.NoError(t, err)
+			require.Equal(t, tc.consistent, consistent)
+		})
+	}
+
+	_, err = bertymessenger.LinksConsistent(contactWeb, contactInternal)
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	_, err = bertymessenger.LinksConsistent(contactInternal, "https://berty.tech/id#contact/invalid")
+	require.Error(t, err)
+}
+
+func TestMergeContactUpdates(t *testing.T) {
+	existing := testContactLink()
+	existing.Metadata = map[string]string{"bio": "hello", "pronouns": "she/her"}
+
+	t.Run("metadata-update", func(t *testing.T) {
+		incoming := testContactLink()
+		incoming.BertyID.DisplayName = "Alice B."
+		incoming.Metadata = map[string]string{"bio": "hello world", "pronouns": ""}
+		_, web, err := incoming.Marshal()
+		require.NoError(t, err)
+		incoming, err = bertymessenger.UnmarshalLink(web)
+		require.NoError(t, err)
+
+		merged, changed, err := bertymessenger.MergeContactUpdates(existing, incoming)
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Equal(t, "Alice B.", merged.BertyID.DisplayName)
+		require.Equal(t, map[string]string{"bio": "hello world", "pronouns": "she/her"}, merged.Metadata)
+
+		// the input links are left untouched
+		require.Equal(t, "Alice", existing.BertyID.DisplayName)
+		require.Equal(t, "hello", existing.Metadata["bio"])
+	})
+
+	t.Run("no-op", func(t *testing.T) {
+		// a re-share without metadata doesn't erase the known ones
+		merged, changed, err := bertymessenger.MergeContactUpdates(existing, testContactLink())
+		require.NoError(t, err)
+		require.False(t, changed)
+		require.Equal(t, existing, merged)
+	})
+
+	t.Run("mismatched-identity", func(t *testing.T) {
+		other := testContactLink()
+		other.BertyID.AccountPK = bytes.Repeat([]byte{42}, 32)
+		_, _, err := bertymessenger.MergeContactUpdates(existing, other)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+
+		_, _, err = bertymessenger.MergeContactUpdates(existing, testGroupLink())
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	})
+}
+
+func TestLinkWithRendezvousSeed(t *testing.T) {
+	original := testContactLink()
+	original.BertyID.AdditionalRendezvousSeeds = [][]byte{bytes.Repeat([]byte{7}, 32)}
+	originalSeed := append([]byte(nil), original.BertyID.PublicRendezvousSeed...)
+	expected := proto.Clone(original).(*bertymessenger.BertyLink)
+
+	seed := bytes.Repeat([]byte{9}, 32)
+	rotated, err := original.WithRendezvousSeed(seed)
+	require.NoError(t, err)
+	require.Equal(t, seed, rotated.BertyID.PublicRendezvousSeed)
+	require.Equal(t, original.BertyID.AccountPK, rotated.BertyID.AccountPK)
+	require.Equal(t, original.BertyID.AdditionalRendezvousSeeds, rotated.BertyID.AdditionalRendezvousSeeds)
+	require.NoError(t, rotated.IsValid())
+
+	// the original link is not mutated, by the call nor by the later changes of the seed or of the copy
+	seed[0] = 42
+	rotated.BertyID.DisplayName = "Bob"
+	rotated.BertyID.AdditionalRendezvousSeeds[0][0] = 42
+	require.Equal(t, expected, original)
+	require.Equal(t, originalSeed, original.BertyID.PublicRendezvousSeed)
+	require.Equal(t, byte(9), rotated.BertyID.PublicRendezvousSeed[0])
+
+	// the rotated link is shared as any contact link
+	internal, _, err := rotated.Marshal()
+	require.NoError(t, err)
+	parsed, err := bertymessenger.UnmarshalLink(internal)
+	require.NoError(t, err)
+	require.Equal(t, rotated, parsed)
+
+	for _, invalid := range [][]byte{nil, bytes.Repeat([]byte{9}, 16), original.BertyID.AdditionalRendezvousSeeds[0]} {
+		_, err := original.WithRendezvousSeed(invalid)
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput), invalid)
+	}
+	for _, link := range []*bertymessenger.BertyLink{testGroupLink(), nil} {
+		_, err := link.WithRendezvousSeed(bytes.Repeat([]byte{9}, 32))
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+	}
+	require.Equal(t, expected, original)
+}
+
+func TestLinkMatchesFingerprint(t *testing.T) {
+	contact := testContactLink()
+	fp, err := contact.Fingerprint()
+	require.NoError(t, err)
+	require.Regexp(t, `^[0-9A-F]{4}( [0-9A-F]{4}){7}$`, fp)
+
+	// the metadata are ignored
+	renamed := testContactLink()
+	renamed.BertyID.DisplayName = "Bob"
+	renamedFP, err := renamed.Fingerprint()
+	require.NoError(t, err)
+	require.Equal(t, fp, renamedFP)
+
+	compact := strings.ReplaceAll(fp, " ", "")
+	for _, input := range []string{
+		fp,
+		compact,
+		strings.ToLower(fp),
+		strings.ReplaceAll(fp, " ", "-"),
+		strings.ReplaceAll(fp, " ", ":"),
+		"  " + strings.ReplaceAll(fp, " ", "\n") + "\t",
+	} {
+		assert.True(t, contact.MatchesFingerprint(input), input)
+	}
+
+	groupFP, err := testGroupLink().Fingerprint()
+	require.NoError(t, err)
+	for _, input := range []string{
+		"",
+		groupFP,
+		compact[:len(compact)-1],
+		compact + "0",
+		strings.Replace(compact, compact[:1], "G", 1),
+	} {
+		assert.False(t, contact.MatchesFingerprint(input), input)
+	}
+
+	assert.False(t, (&bertymessenger.BertyLink{}).MatchesFingerprint(fp))
+}
+
+func TestLinkIdenticonColor(t *testing.T) {
+	contact := testContactLink()
+	r, g, b, err := contact.IdenticonColor()
+	require.NoError(t, err)
+
+	// stable
+	for i := 0; i < 3; i++ {
+		r2, g2, b2, err := testContactLink().IdenticonColor()
+		require.NoError(t, err)
+		require.Equal(t, []uint8{r, g, b}, []uint8{r2, g2, b2})
+	}
+
+	// the display name is ignored, and the web and internal forms agree
+	renamed := testContactLink()
+	renamed.BertyID.DisplayName = "Bob"
+	internal, web, err := renamed.Marshal()
+	require.NoError(t, err)
+	for _, uri := range []string{internal, web} {
+		parsed, err := bertymessenger.UnmarshalLink(uri)
+		require.NoError(t, err)
+		r2, g2, b2, err := parsed.IdenticonColor()
+		require.NoError(t, err)
+		require.Equal(t, []uint8{r, g, b}, []uint8{r2, g2, b2})
+	}
+
+	// the identities get different colors
+	colors := map[[3]uint8]bool{{r, g, b}: true}
+	for i := byte(0); i < 8; i++ {
+		other := testContactLink()
+		other.BertyID.AccountPK = bytes.Repeat([]byte{i + 100}, 32)
+		r2, g2, b2, err := other.IdenticonColor()
+		require.NoError(t, err)
+		colors[[3]uint8{r2, g2, b2}] = true
+	}
+	require.Greater(t, len(colors), 1)
+
+	_, _, _, err = (&bertymessenger.BertyLink{}).IdenticonColor()
+	require.Error(t, err)
+}
+
+func TestLinkMetadataCost(t *testing.T) {
+	link := testContactLink()
+	link.BertyID.ReferralCode = "spring-campaign"
+	link.Metadata = map[string]string{
+		"bio":  strings.Repeat("I like trains. ", 4),
+		"lang": "fr",
+	}
+	costs, err := link.MetadataCost()
+	require.NoError(t, err)
+	require.Len(t, costs, 4)
+	for _, field := range []string{"display_name", "referral_code", "metadata.bio", "metadata.lang"} {
+		require.Greater(t, costs[field], 0, field)
+	}
+	require.Greater(t, costs["metadata.bio"], costs["metadata.lang"])
+
+	// the costs add up to the difference between the full and the minimal links, give or take the base encoding
+	full, _, err := link.Marshal()
+	require.NoError(t, err)
+	minimal := testContactLink()
+	minimal.BertyID.DisplayName = ""
+	minimalInternal, _, err := minimal.Marshal()
+	require.NoError(t, err)
+	sum := 0
+	for _, cost := range costs {
+		sum += cost
+	}
+	require.InDelta(t, len(full)-len(minimalInternal), sum, 4)
+
+	// the link is left untouched
+	require.Len(t, link.Metadata, 2)
+	require.NotEmpty(t, link.BertyID.DisplayName)
+
+	costs, err = minimal.MetadataCost()
+	require.NoError(t, err)
+	require.Empty(t, costs)
+
+	group := testGroupLink()
+	costs, err = group.MetadataCost()
+	require.NoError(t, err)
+	require.Len(t, costs, 1)
+	require.Greater(t, costs["display_name"], 0)
+
+	// every optional field has a cost
+	groupSet := &bertymessenger.BertyLink{
+		Kind:        bertymessenger.BertyLink_GroupSetV1Kind,
+		BertyGroups: []*bertymessenger.BertyGroup{testGroupLink().BertyGroup},
+	}
+	costs, err = groupSet.MetadataCost()
+	require.NoError(t, err)
+	require.Len(t, costs, 1)
+	require.Greater(t, costs["display_name"], 0)
+
+	for field, set := range map[string]func(link *bertymessenger.BertyLink){
+		"additional_rendezvous_seeds": func(link *bertymessenger.BertyLink) {
+			link.BertyID.AdditionalRendezvousSeeds = [][]byte{bytes.Repeat([]byte{7}, 32)}
+		},
+		"validity_period":     func(link *bertymessenger.BertyLink) { link.ExpiresAtUnix = time.Now().Add(time.Hour).Unix() },
+		"expiry_display_hint": func(link *bertymessenger.BertyLink) { link.ExpiryDisplayHint = bertymessenger.BertyLink_ExpiryAbsolute },
+		"pow_nonce":           func(link *bertymessenger.BertyLink) { link.PoWNonce = []byte{1, 2, 3, 4} },
+		"sealed_note":         func(link *bertymessenger.BertyLink) { link.SealedNote = bytes.Repeat([]byte{8}, 48) },
+		"signature":           func(link *bertymessenger.BertyLink) { link.Signature = bytes.Repeat([]byte{9}, 64) },
+	} {
+		link := proto.Clone(minimal).(*bertymessenger.BertyLink)
+		set(link)
+		costs, err := link.MetadataCost()
+		require.NoError(t, err, field)
+		require.Len(t, costs, 1, field)
+		require.Greater(t, costs[field], 0, field)
+	}
+	for name, link := range map[string]*bertymessenger.BertyLink{"group": testGroupLink(), "group-set": groupSet} {
+		link := proto.Clone(link).(*bertymessenger.BertyLink)
+		regenerated := link.BertyGroup
+		if regenerated == nil {
+			regenerated = link.BertyGroups[0]
+		}
+		regenerated.RegenerationLog = []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 1600000000, RegeneratorPKHash: bytes.Repeat([]byte{7}, 32)}}
+		costs, err := link.MetadataCost()
+		require.NoError(t, err, name)
+		require.Len(t, costs, 2, name)
+		require.Greater(t, costs["regeneration_log"], 0, name)
+	}
+
+	_, err = (&bertymessenger.BertyLink{}).MetadataCost()
+	require.Error(t, err)
+}
+
+func TestLinkPreviewJSON(t *testing.T) {
+	group := testGroupLink()
+	fingerprint, err := group.Fingerprint()
+	require.NoError(t, err)
+	out, err := group.PreviewJSON()
+	require.NoError(t, err)
+
+	var preview map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &preview))
+	require.Equal(t, map[string]interface{}{
+		"kind":        "GroupV1Kind",
+		"displayName": "The Group",
+		"fingerprint": fingerprint,
+		"hasSecret":   true,
+		"expired":     false,
+	}, preview)
+
+	// no secret material
+	for _, secret := range [][]byte{group.BertyGroup.Group.Secret, group.BertyGroup.Group.SecretSig} {
+		for _, encoded := range []string{base64.StdEncoding.EncodeToString(secret), base58.Encode(secret), hex.EncodeToString(secret)} {
+			require.NotContains(t, string(out), encoded)
+		}
+	}
+	require.NotContains(t, strings.ToLower(string(out)), "secretsig")
+
+	contact := testContactLink()
+	contact.ExpiresAtUnix = time.Now().Add(-time.Hour).Unix()
+	out, err = contact.PreviewJSON()
+	require.NoError(t, err)
+	preview = nil
+	require.NoError(t, json.Unmarshal(out, &preview))
+	require.Equal(t, "ContactInviteV1Kind", preview["kind"])
+	require.Equal(t, "Alice", preview["displayName"])
+	require.Equal(t, false, preview["hasSecret"])
+	require.Equal(t, true, preview["expired"])
+
+	// a link that is not valid yet is not expired
+	contact = testContactLink()
+	contact.NotBeforeUnix = time.Now().Add(time.Hour).Unix()
+	out, err = contact.PreviewJSON()
+	require.NoError(t, err)
+	preview = nil
+	require.NoError(t, json.Unmarshal(out, &preview))
+	require.Equal(t, false, preview["expired"])
+
+	_, err = (&bertymessenger.BertyLink{}).PreviewJSON()
+	require.Error(t, err)
+}
+
+func TestLinkShortenPayload(t *testing.T) {
+	id, internal, err := testGroupLink().ShortenPayload()
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	expectedInternal, err := testGroupLink().MarshalInternalOnly()
+	require.NoError(t, err)
+	require.Equal(t, expectedInternal, internal)
+
+	// stable
+	again, _, err := testGroupLink().ShortenPayload()
+	require.NoError(t, err)
+	require.Equal(t, id, again)
+
+	// URL-safe
+	require.Equal(t, id, url.PathEscape(id))
+	require.Regexp(t, "^[A-Za-z0-9_-]+$", id)
+
+	// distinct links have distinct IDs
+	other, _, err := testContactLink().ShortenPayload()
+	require.NoError(t, err)
+	require.NotEqual(t, id, other)
+
+	// the id doesn't depend on the internal scheme
+	require.NoError(t, bertymessenger.SetLinkInternalPrefix("MYCHAT://"))
+	defer func() { require.NoError(t, bertymessenger.SetLinkInternalPrefix(bertymessenger.LinkInternalPrefix)) }()
+	scheme, schemeInternal, err := testGroupLink().ShortenPayload()
+	require.NoError(t, err)
+	require.Equal(t, id, scheme)
+	require.True(t, strings.HasPrefix(schemeInternal, "MYCHAT://"))
+	require.NoError(t, bertymessenger.SetLinkInternalPrefix(bertymessenger.LinkInternalPrefix))
+
+	// the links whose web link is too long are shortened too
+	long := testLongWebLink(t)
+	longID, longInternal, err := long.ShortenPayload()
+	require.NoError(t, err)
+	require.NotEqual(t, id, longID)
+	parsed, err := bertymessenger.UnmarshalLink(longInternal)
+	require.NoError(t, err)
+	require.True(t, long.Equal(parsed))
+
+	_, _, err = (&bertymessenger.BertyLink{}).ShortenPayload()
+	require.Error(t, err)
+}
+
+func TestCleanLink(t *testing.T) {
+	contact := testContactLink()
+	contact.Metadata = map[string]string{"bio": "hello"}
+	internal, web, err := contact.Marshal()
+	require.NoError(t, err)
+	_, taggedWeb, err := contact.Marshal(bertymessenger.WithAnalyticsTag("poster"))
+	require.NoError(t, err)
+	require.NotEqual(t, web, taggedWeb)
+
+	anonymous := testContactLink()
+	anonymous.BertyID.DisplayName = ""
+	_, anonymousWeb, err := anonymous.Marshal()
+	require.NoError(t, err)
+	_, anonymousTaggedWeb, err := anonymous.Marshal(bertymessenger.WithAnalyticsTag("poster"))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"tagged", taggedWeb, web},
+		{"tag-only", anonymousTaggedWeb, anonymousWeb},
+		{"untagged", web, web},
+		{"untagged-without-values", anonymousWeb, anonymousWeb},
+		{"internal", internal, internal},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cleaned, err := bertymessenger.CleanLink(tc.input)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, cleaned)
+			require.NotContains(t, cleaned, "a=poster")
+		})
+	}
+
+	_, err = bertymessenger.CleanLink(web + "&%zz=1")
+	require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+}
+
+func TestUnmarshalLinkForLog(t *testing.T) {
+	for _, link := range []*bertymessenger.BertyLink{testContactLink(), testGroupLink()} {
+		t.Run(link.Kind.String(), func(t *testing.T) {
+			internal, web, err := link.Marshal()
+			require.NoError(t, err)
+
+			for _, uri := range []string{internal, web} {
+				redacted, err := bertymessenger.UnmarshalLinkForLog(uri)
+				require.NoError(t, err)
+				require.Equal(t, link.Kind, redacted.Kind)
+				switch link.Kind {
+				case bertymessenger.BertyLink_ContactInviteV1Kind:
+					require.Equal(t, link.BertyID.DisplayName, redacted.BertyID.DisplayName)
+					require.Equal(t, link.BertyID.AccountPK, redacted.BertyID.AccountPK)
+					require.Empty(t, redacted.BertyID.PublicRendezvousSeed)
+				case bertymessenger.BertyLink_GroupV1Kind:
+					require.Equal(t, link.BertyGroup.DisplayName, redacted.BertyGroup.DisplayName)
+					require.Equal(t, link.BertyGroup.Group.PublicKey, redacted.BertyGroup.Group.PublicKey)
+					require.Empty(t, redacted.BertyGroup.Group.Secret)
+					require.Empty(t, redacted.BertyGroup.Group.SecretSig)
+				}
+			}
+		})
+	}
+
+	t.Run("deep-copy", func(t *testing.T) {
+		link := testContactLink()
+		link.BertyID.AdditionalRendezvousSeeds = [][]byte{bytes.Repeat([]byte{7}, 32)}
+		link.PoWNonce = []byte{1, 2, 3}
+		link.Signature = bytes.Repeat([]byte{8}, 64)
+		redacted := link.Redacted()
+		require.Empty(t, redacted.BertyID.PublicRendezvousSeed)
+		require.Empty(t, redacted.BertyID.AdditionalRendezvousSeeds)
+		redacted.BertyID.AccountPK[0] = 42
+		redacted.PoWNonce[0] = 42
+		redacted.Signature[0] = 42
+		require.Equal(t, bytes.Repeat([]byte{2}, 32), link.BertyID.AccountPK)
+		require.Equal(t, []byte{1, 2, 3}, link.PoWNonce)
+		require.Equal(t, bytes.Repeat([]byte{8}, 64), link.Signature)
+		require.Len(t, link.BertyID.AdditionalRendezvousSeeds, 1)
+
+		group := testGroupLink()
+		group.BertyGroup.RegenerationLog = []*bertymessenger.BertyGroup_RegenerationEntry{{RegeneratedAtUnix: 1600000000}}
+		redacted = group.Redacted()
+		require.Empty(t, redacted.BertyGroup.Group.Secret)
+		require.Empty(t, redacted.BertyGroup.Group.SecretSig)
+		redacted.BertyGroup.RegenerationLog[0].RegeneratedAtUnix = 0
+		redacted.BertyGroup.Group.PublicKey[0] = 42
+		require.Equal(t, int64(1600000000), group.BertyGroup.RegenerationLog[0].RegeneratedAtUnix)
+		group.BertyGroup.RegenerationLog = nil
+		require.Equal(t, testGroupLink(), group)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		redacted, err := bertymessenger.UnmarshalLinkForLog("invalid")
+		require.True(t, errcode.Is(err, errcode.ErrInvalidInput))
+		require.Nil(t, redacted)
+	})
+}